@@ -0,0 +1,194 @@
+// Package amf implements AMF0 and AMF3 encoding used by rtmp commands and script data.
+//
+// Values are decoded into interface{} as follows:
+//
+//	number, AMF3 integer      float64
+//	boolean                   bool
+//	string, long string       string
+//	null                      nil
+//	undefined                 Undefined
+//	object, ECMA array        map[string]interface{}, or Object and ECMAArray keeping order
+//	typed object              *TypedObject
+//	strict array, AMF3 array  []interface{}, or *Array if it has associative part
+//	date                      time.Time
+//	XML document              XMLDocument
+//	AMF3 XML                  XML
+//	AMF3 ByteArray            []byte
+//	AMF3 vectors              []int32, []uint32, []float64, *ObjectVector
+//	AMF3 dictionary           *Dictionary
+//
+// Go values are encoded the other way around, structs are encoded as objects with fields
+// named by `amf:"name,omitempty"` tags, like encoding/json does.
+package amf
+
+import (
+	"bytes"
+	"io"
+)
+
+// Version is the AMF version, the values match objectEncoding of rtmp connect command
+type Version int
+
+const (
+	AMF0 Version = 0
+	AMF3 Version = 3
+)
+
+// Undefined is the undefined value
+type Undefined struct{}
+
+// XMLDocument is a legacy XML document, AMF0 XML document and AMF3 XMLDocument
+type XMLDocument string
+
+// XML is an AMF3 E4X XML document
+type XML string
+
+// Property is a name value pair of an object
+type Property struct {
+	Name  string
+	Value interface{}
+}
+
+// Object is an anonymous object keeping order of its properties. A decoder using
+// UseOrderedObjects decodes objects as Object instead of map[string]interface{}
+type Object []Property
+
+// Get returns value of the property, nil if it does not exist
+func (o Object) Get(name string) interface{} {
+	for _, p := range o {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return nil
+}
+
+// Set replaces value of the property, or appends it if it does not exist
+func (o Object) Set(name string, value interface{}) Object {
+	for i := range o {
+		if o[i].Name == name {
+			o[i].Value = value
+			return o
+		}
+	}
+	return append(o, Property{Name: name, Value: value})
+}
+
+// Map returns properties as a map
+func (o Object) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(o))
+	for _, p := range o {
+		m[p.Name] = p.Value
+	}
+	return m
+}
+
+// ECMAArray is an AMF0 ECMA array keeping order of its properties, e.g. onMetaData parameters.
+// It is written as an anonymous object in AMF3
+type ECMAArray []Property
+
+// Get returns value of the property, nil if it does not exist
+func (a ECMAArray) Get(name string) interface{} {
+	return Object(a).Get(name)
+}
+
+// Set replaces value of the property, or appends it if it does not exist
+func (a ECMAArray) Set(name string, value interface{}) ECMAArray {
+	return ECMAArray(Object(a).Set(name, value))
+}
+
+// Map returns properties as a map
+func (a ECMAArray) Map() map[string]interface{} {
+	return Object(a).Map()
+}
+
+// TypedObject is an object of a registered class, anonymous objects are decoded as map[string]interface{}
+type TypedObject struct {
+	ClassName string
+	Sealed    []string // names of AMF3 sealed members, in order of serialization
+	Dynamic   bool     // AMF3 members other than sealed ones are sent as dynamic members
+	Members   map[string]interface{}
+}
+
+// Array is an AMF3 array with both dense and associative parts, arrays having only one of
+// them are decoded as []interface{} or map[string]interface{}
+type Array struct {
+	Dense []interface{}
+	Assoc map[string]interface{}
+}
+
+// ObjectVector is an AMF3 Vector.<T> of objects
+type ObjectVector struct {
+	TypeName string
+	Fixed    bool
+	Items    []interface{}
+}
+
+// DictionaryEntry is a key value pair of a dictionary, keys can be of any type
+type DictionaryEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Dictionary is an AMF3 flash.utils.Dictionary
+type Dictionary struct {
+	WeakKeys bool
+	Entries  []DictionaryEntry
+}
+
+// Marshal returns AMF0 encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalVersion(AMF0, v)
+}
+
+// MarshalVersion returns encoding of v in the given AMF version
+func MarshalVersion(version Version, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, version).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the first AMF0 value of data into v, which must be a non-nil pointer
+func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalVersion(AMF0, data, v)
+}
+
+// UnmarshalVersion decodes the first value of data in the given AMF version into v
+func UnmarshalVersion(version Version, data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data), version).Decode(v)
+}
+
+// UnmarshalAll decodes all AMF0 values of data, e.g. payload of a command message
+func UnmarshalAll(data []byte) ([]interface{}, error) {
+	return UnmarshalAllVersion(AMF0, data)
+}
+
+// UnmarshalAllVersion decodes all values of data in the given AMF version
+func UnmarshalAllVersion(version Version, data []byte) ([]interface{}, error) {
+	arr := make([]interface{}, 0)
+	dec := NewDecoder(bytes.NewReader(data), version)
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return arr, nil
+			}
+			return arr, err
+		}
+		arr = append(arr, v)
+	}
+}
+
+// MarshalAll returns AMF0 encoding of the values one after another
+func MarshalAll(values ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, AMF0)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
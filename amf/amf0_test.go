@@ -0,0 +1,88 @@
+package amf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_amf0Types(t *testing.T) {
+	date := time.Unix(1600000000, 0).UTC()
+	long := strings.Repeat("x", 0x10000)
+	data := []interface{}{
+		float64(1.5),
+		true,
+		"connect",
+		Undefined{},
+		nil,
+		date,
+		long,
+		XMLDocument("<a/>"),
+		[]interface{}{float64(1), "two", false},
+		&TypedObject{ClassName: "Person", Dynamic: true, Members: map[string]interface{}{"name": "a"}},
+		map[string]interface{}{"nested": []interface{}{map[string]interface{}{"k": "v", "n": nil}}},
+		[]byte{1, 2}, // switches to AMF3
+	}
+	payload, err := MarshalAll(data...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr, err := UnmarshalAll(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arr) != len(data) {
+		t.Fatalf("expect %v values, got %v", len(data), len(arr))
+	}
+	for i := range data {
+		if !reflect.DeepEqual(arr[i], data[i]) {
+			t.Errorf("value %v mismatch, expect %v, got %v", i, data[i], arr[i])
+		}
+	}
+
+	// ECMA arrays and typed slices
+	payload, err = Marshal(ECMAArray{{Name: "times", Value: []float64{0, 2.5}}})
+	if err != nil || payload[0] != amf0ECMAArray {
+		t.Fatal("ecma array is expected")
+	}
+	var v interface{}
+	if err = Unmarshal(payload, &v); err != nil {
+		t.Fatal(err)
+	}
+	times := v.(map[string]interface{})["times"].([]interface{})
+	if len(times) != 2 || times[1] != 2.5 {
+		t.Fail()
+	}
+
+	// unknown types are not dropped silently
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Fail()
+	}
+}
+
+func Test_amf0Reference(t *testing.T) {
+	payload := []byte{
+		0x0A, 0x00, 0x00, 0x00, 0x02, // strict array of 2
+		0x03, 0x00, 0x01, 'k', 0x02, 0x00, 0x01, 'v', 0x00, 0x00, 0x09, // {k: "v"}
+		0x07, 0x00, 0x01, // reference to the object, the array itself is 0
+	}
+	arr, err := UnmarshalAll(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := arr[0].([]interface{})
+	if items[1].(map[string]interface{})["k"] != "v" {
+		t.Fail()
+	}
+	if _, err := UnmarshalAll([]byte{0x07, 0x00, 0x05}); err == nil {
+		t.Fail()
+	}
+	if _, err := UnmarshalAll([]byte{0x04}); err == nil {
+		t.Fail()
+	}
+	// truncated value
+	if _, err := UnmarshalAll([]byte{0x02, 0x00, 0x05, 'a'}); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,103 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_amf3(t *testing.T) {
+	date := time.Unix(1600000000, 123*int64(time.Millisecond)).UTC()
+	data := []interface{}{
+		nil,
+		Undefined{},
+		true,
+		false,
+		float64(0),
+		float64(-1),
+		float64(0x0FFFFFFF),
+		float64(-0x10000000),
+		1.5,
+		"connect",
+		"connect", // sent by reference
+		"",
+		XML("<a/>"),
+		XMLDocument("<b/>"),
+		date,
+		[]interface{}{"a", float64(1)},
+		map[string]interface{}{"name": "helloworld", "age": float64(12)},
+		&Array{Dense: []interface{}{"x"}, Assoc: map[string]interface{}{"k": "v"}},
+		&TypedObject{
+			ClassName: "Person",
+			Sealed:    []string{"name"},
+			Dynamic:   true,
+			Members:   map[string]interface{}{"name": "a", "extra": true},
+		},
+		&TypedObject{
+			ClassName: "Person", // traits sent by reference
+			Sealed:    []string{"name"},
+			Dynamic:   true,
+			Members:   map[string]interface{}{"name": "b"},
+		},
+		[]byte{1, 2, 3},
+		[]int32{-1, 2},
+		[]uint32{1, 0xFFFFFFFF},
+		[]float64{0.5, -2},
+		&ObjectVector{TypeName: "String", Fixed: true, Items: []interface{}{"s"}},
+		&Dictionary{Entries: []DictionaryEntry{{Key: float64(1), Value: "one"}}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, AMF3)
+	for _, v := range data {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	arr, err := UnmarshalAllVersion(AMF3, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arr) != len(data) {
+		t.Fatalf("expect %v values, got %v", len(data), len(arr))
+	}
+	for i := range data {
+		if !reflect.DeepEqual(arr[i], data[i]) {
+			t.Errorf("value %v mismatch, expect %v, got %v", i, data[i], arr[i])
+		}
+	}
+
+	// integers are written in variable length
+	payload, _ := MarshalVersion(AMF3, 0x3FFF)
+	if !bytes.Equal(payload, []byte{0x04, 0xFF, 0x7F}) {
+		t.Fail()
+	}
+	if payload, _ = MarshalVersion(AMF3, 300000000); payload[0] != amf3Double {
+		t.Fail()
+	}
+}
+
+func Test_amf3References(t *testing.T) {
+	// [obj, obj] where the second item references the first object
+	data := []byte{
+		0x09, 0x05, 0x01, // dense array of 2
+		0x0A, 0x0B, 0x01, 0x03, 'k', 0x06, 0x03, 'v', 0x01, // anonymous dynamic object {k: "v"}
+		0x0A, 0x02, // object reference 1, the array itself is 0
+	}
+	var v []interface{}
+	if err := UnmarshalVersion(AMF3, data, &v); err != nil {
+		t.Fatal(err)
+	}
+	first := v[0].(map[string]interface{})
+	second := v[1].(map[string]interface{})
+	first["k"] = "changed"
+	if second["k"] != "changed" {
+		t.Fail()
+	}
+
+	var o interface{}
+	if err := UnmarshalVersion(AMF3, []byte{0x0A, 0x08}, &o); err == nil {
+		t.Fail()
+	}
+}
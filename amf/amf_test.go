@@ -0,0 +1,214 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type testCodec struct {
+	Profile string `amf:"profile"`
+	Level   int    `amf:"level,omitempty"`
+}
+
+type testMetaData struct {
+	testCodec
+	Width     int       `amf:"width"`
+	Height    uint16    `amf:"height"`
+	FrameRate float64   `amf:"framerate"`
+	Stereo    bool      `amf:"stereo"`
+	Encoder   string    `amf:"encoder,omitempty"`
+	Times     []float64 `amf:"times"`
+	Extra     *string   `amf:"extra"`
+	Ignored   string    `amf:"-"`
+	Title     string
+	hidden    int
+}
+
+func Test_MarshalStruct(t *testing.T) {
+	extra := "x"
+	meta := testMetaData{
+		testCodec: testCodec{Profile: "high"},
+		Width:     1280,
+		Height:    720,
+		FrameRate: 29.97,
+		Stereo:    true,
+		Times:     []float64{0, 2},
+		Extra:     &extra,
+		Ignored:   "ignored",
+		Title:     "title",
+		hidden:    1,
+	}
+	for _, version := range []Version{AMF0, AMF3} {
+		data, err := MarshalVersion(version, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m map[string]interface{}
+		if err := UnmarshalVersion(version, data, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m["profile"] != "high" || m["width"] != float64(1280) || m["Title"] != "title" {
+			t.Errorf("version %v: unexpected object %v", version, m)
+		}
+		for _, key := range []string{"level", "encoder", "Ignored", "-", "hidden"} {
+			if _, ok := m[key]; ok {
+				t.Errorf("version %v: unexpected key %v", version, key)
+			}
+		}
+
+		var got testMetaData
+		if err := UnmarshalVersion(version, data, &got); err != nil {
+			t.Fatal(err)
+		}
+		meta.Ignored = ""
+		meta.hidden = 0
+		if got.Profile != "high" || got.Width != 1280 || got.Height != 720 || got.FrameRate != 29.97 ||
+			!got.Stereo || len(got.Times) != 2 || got.Times[1] != 2 || *got.Extra != "x" ||
+			got.Title != "title" || got.Ignored != "" {
+			t.Errorf("version %v: unexpected struct %+v", version, got)
+		}
+	}
+
+	// property names are matched case insensitively, unknown properties are ignored
+	data, _ := Marshal(map[string]interface{}{"WIDTH": 640, "unknown": "x"})
+	var got testMetaData
+	if err := Unmarshal(data, &got); err != nil || got.Width != 640 {
+		t.Fail()
+	}
+
+	// type mismatch
+	data, _ = Marshal(map[string]interface{}{"width": "wide"})
+	if err := Unmarshal(data, &got); err == nil {
+		t.Fail()
+	}
+	// other fields are assigned despite the mismatch
+	got = testMetaData{}
+	data, _ = Marshal(map[string]interface{}{"height": 720, "width": "wide", "encoder": "obs", "stereo": true})
+	if err := Unmarshal(data, &got); err == nil || got.Height != 720 || got.Encoder != "obs" || !got.Stereo {
+		t.Errorf("unexpected struct %+v, %v", got, err)
+	}
+	// non-pointer
+	if err := Unmarshal(data, got); err == nil {
+		t.Fail()
+	}
+}
+
+func Test_EncoderDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, AMF0)
+	enc.Encode("connect")
+	enc.Encode(1)
+	enc.Encode(map[string]interface{}{"app": "live", "objectEncoding": 0})
+
+	var (
+		name          string
+		transactionID int
+		cmd           struct {
+			App            string  `amf:"app"`
+			ObjectEncoding Version `amf:"objectEncoding"`
+		}
+	)
+	dec := NewDecoder(&buf, AMF0)
+	if err := dec.Decode(&name); err != nil || name != "connect" {
+		t.Fail()
+	}
+	if err := dec.Decode(&transactionID); err != nil || transactionID != 1 {
+		t.Fail()
+	}
+	if err := dec.Decode(&cmd); err != nil || cmd.App != "live" || cmd.ObjectEncoding != AMF0 {
+		t.Fail()
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Fail()
+	}
+}
+
+func Test_OrderedObjects(t *testing.T) {
+	key := func(s string) []byte { return append([]byte{0x00, byte(len(s))}, s...) }
+	number := func(f float64) []byte {
+		b := make([]byte, 9)
+		b[0] = amf0Number
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+		return b
+	}
+	var data []byte
+	data = append(data, amf0String)
+	data = append(data, key("onMetaData")...)
+	data = append(data, amf0ECMAArray, 0x00, 0x00, 0x00, 0x03)
+	data = append(data, key("duration")...)
+	data = append(data, number(10)...)
+	data = append(data, key("filesize")...)
+	data = append(data, number(1024)...)
+	data = append(data, key("keyframes")...)
+	data = append(data, amf0Object)
+	data = append(data, key("times")...)
+	data = append(data, amf0StrictArray, 0x00, 0x00, 0x00, 0x02)
+	data = append(data, number(0)...)
+	data = append(data, number(2)...)
+	data = append(data, key("filepositions")...)
+	data = append(data, amf0StrictArray, 0x00, 0x00, 0x00, 0x01)
+	data = append(data, number(13)...)
+	data = append(data, 0x00, 0x00, amf0ObjectEnd)
+	data = append(data, 0x00, 0x00, amf0ObjectEnd)
+
+	dec := NewDecoder(bytes.NewReader(data), AMF0)
+	dec.UseOrderedObjects()
+	values := make([]interface{}, 0)
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		values = append(values, v)
+	}
+	if len(values) != 2 {
+		t.Fatalf("expect 2 values, got %v", len(values))
+	}
+	meta, ok := values[1].(ECMAArray)
+	if !ok || meta[0].Name != "duration" || meta[2].Name != "keyframes" || meta.Get("filesize") != float64(1024) {
+		t.Fatalf("unexpected metadata %v", values[1])
+	}
+	if keyframes, ok := meta.Get("keyframes").(Object); !ok || keyframes[1].Name != "filepositions" {
+		t.Fatalf("unexpected keyframes %v", meta.Get("keyframes"))
+	}
+	payload, err := MarshalAll(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, data) {
+		t.Errorf("round trip mismatch\nexpect %x\ngot    %x", data, payload)
+	}
+
+	// AMF3 objects keep order too
+	obj := Object{}.Set("level", "status").Set("code", "NetConnection.Connect.Success").Set("objectEncoding", float64(3))
+	payload, err = MarshalVersion(AMF3, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec = NewDecoder(bytes.NewReader(payload), AMF3)
+	dec.UseOrderedObjects()
+	var v interface{}
+	if err = dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, obj) {
+		t.Errorf("expect %v, got %v", obj, v)
+	}
+
+	// ordered decoder still decodes into maps
+	dec = NewDecoder(bytes.NewReader(payload), AMF3)
+	dec.UseOrderedObjects()
+	var m map[string]interface{}
+	if err = dec.Decode(&m); err != nil || m["code"] != "NetConnection.Connect.Success" {
+		t.Errorf("unexpected map %v, %v", m, err)
+	}
+}
@@ -0,0 +1,755 @@
+package amf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"time"
+)
+
+// AMF0 markers
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0MovieClip   = 0x04
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0Reference   = 0x07
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+	amf0Unsupported = 0x0D
+	amf0RecordSet   = 0x0E
+	amf0XMLDocument = 0x0F
+	amf0TypedObject = 0x10
+	amf0AvmPlus     = 0x11
+)
+
+// AMF3 markers
+const (
+	amf3Undefined    = 0x00
+	amf3Null         = 0x01
+	amf3False        = 0x02
+	amf3True         = 0x03
+	amf3Integer      = 0x04
+	amf3Double       = 0x05
+	amf3String       = 0x06
+	amf3XMLDoc       = 0x07
+	amf3Date         = 0x08
+	amf3Array        = 0x09
+	amf3Object       = 0x0A
+	amf3XML          = 0x0B
+	amf3ByteArray    = 0x0C
+	amf3VectorInt    = 0x0D
+	amf3VectorUint   = 0x0E
+	amf3VectorDouble = 0x0F
+	amf3VectorObject = 0x10
+	amf3Dictionary   = 0x11
+)
+
+// preallocated capacity of arrays is limited, so that a forged length does not exhaust memory
+const maxPrealloc = 1024
+
+// Decoder reads AMF values from an input stream. Reference tables are kept between values,
+// as values of a message share them
+type Decoder struct {
+	r       *bufio.Reader
+	version Version
+	refs    []interface{} // AMF0 object references
+	amf3    *amf3Reader
+	ordered bool
+}
+
+// NewDecoder returns a decoder reading values of the given version from r
+func NewDecoder(r io.Reader, version Version) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	d := &Decoder{
+		r:       br,
+		version: version,
+		refs:    make([]interface{}, 0),
+	}
+	d.amf3 = newAmf3Reader(d.r)
+	return d
+}
+
+// UseOrderedObjects makes the decoder decode objects as Object and ECMA arrays as ECMAArray,
+// so that order of properties is kept
+func (d *Decoder) UseOrderedObjects() {
+	d.ordered = true
+	d.amf3.ordered = true
+}
+
+// Decode reads the next value into v, which must be a non-nil pointer. It returns io.EOF
+// if there is no more value
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("amf: Decode expects a non-nil pointer")
+	}
+	if _, err := d.r.Peek(1); err != nil {
+		return err
+	}
+
+	var value interface{}
+	var err error
+	if d.version == AMF3 {
+		value, err = d.amf3.readValue()
+	} else {
+		value, err = d.readValue()
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return assign(rv.Elem(), value)
+}
+
+func readByte(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func readBytes(r *bufio.Reader, n int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+func readUint16(r *bufio.Reader) (uint16, error) {
+	b, err := readBytes(r, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func readUint32(r *bufio.Reader) (uint32, error) {
+	b, err := readBytes(r, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func readDouble(r *bufio.Reader) (float64, error) {
+	b, err := readBytes(r, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+func msToTime(ms float64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+}
+
+func preallocSize(n uint32) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+func (d *Decoder) addRef(o interface{}) int {
+	d.refs = append(d.refs, o)
+	return len(d.refs) - 1
+}
+
+func (d *Decoder) readValue() (interface{}, error) {
+	marker, err := readByte(d.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf0Number:
+		return readDouble(d.r)
+	case amf0Boolean:
+		b, err := readByte(d.r)
+		return b != 0, err
+	case amf0String:
+		return d.readString()
+	case amf0Object:
+		return d.readObject(false)
+	case amf0Null, amf0Unsupported:
+		return nil, nil
+	case amf0Undefined:
+		return Undefined{}, nil
+	case amf0Reference:
+		index, err := readUint16(d.r)
+		if err != nil {
+			return nil, err
+		}
+		if int(index) >= len(d.refs) {
+			return nil, fmt.Errorf("amf: AMF0 reference %v out of range", index)
+		}
+		return d.refs[index], nil
+	case amf0ECMAArray:
+		// the count is not reliable, members are read until object-end marker
+		if _, err := readUint32(d.r); err != nil {
+			return nil, err
+		}
+		return d.readObject(true)
+	case amf0StrictArray:
+		return d.readStrictArray()
+	case amf0Date:
+		ms, err := readDouble(d.r)
+		if err != nil {
+			return nil, err
+		}
+		// time zone is reserved and ignored
+		if _, err := readUint16(d.r); err != nil {
+			return nil, err
+		}
+		return msToTime(ms), nil
+	case amf0LongString:
+		return d.readLongString()
+	case amf0XMLDocument:
+		str, err := d.readLongString()
+		return XMLDocument(str), err
+	case amf0TypedObject:
+		className, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		o := &TypedObject{
+			ClassName: className,
+			Dynamic:   true,
+			Members:   make(map[string]interface{}),
+		}
+		d.addRef(o)
+		props, err := d.readProperties()
+		for _, p := range props {
+			o.Members[p.Name] = p.Value
+		}
+		return o, err
+	case amf0AvmPlus:
+		// each switch to AMF3 starts with empty reference tables
+		r := newAmf3Reader(d.r)
+		r.ordered = d.ordered
+		return r.readValue()
+	case amf0MovieClip, amf0RecordSet:
+		return nil, fmt.Errorf("amf: AMF0 type %d is reserved", marker)
+	default:
+		return nil, fmt.Errorf("amf: unexpected AMF0 marker %d", marker)
+	}
+}
+
+func (d *Decoder) readString() (string, error) {
+	l, err := readUint16(d.r)
+	if err != nil {
+		return "", err
+	}
+	b, err := readBytes(d.r, int64(l))
+	return string(b), err
+}
+
+func (d *Decoder) readLongString() (string, error) {
+	l, err := readUint32(d.r)
+	if err != nil {
+		return "", err
+	}
+	b, err := readBytes(d.r, int64(l))
+	return string(b), err
+}
+
+// readObject reads properties of an anonymous object or ECMA array
+func (d *Decoder) readObject(ecma bool) (interface{}, error) {
+	index := d.addRef(nil)
+	props, err := d.readProperties()
+	var o interface{}
+	switch {
+	case d.ordered && ecma:
+		o = ECMAArray(props)
+	case d.ordered:
+		o = Object(props)
+	default:
+		o = Object(props).Map()
+	}
+	d.refs[index] = o
+	return o, err
+}
+
+// readProperties reads object properties until object-end marker
+func (d *Decoder) readProperties() ([]Property, error) {
+	props := make([]Property, 0)
+	for {
+		// tolerate objects truncated at the end of message
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return props, nil
+		}
+		key, err := d.readString()
+		if err != nil {
+			return props, err
+		}
+		if next, err := d.r.Peek(1); err == nil && next[0] == amf0ObjectEnd {
+			d.r.ReadByte()
+			return props, nil
+		}
+		value, err := d.readValue()
+		if err != nil {
+			return props, err
+		}
+		props = append(props, Property{Name: key, Value: value})
+	}
+}
+
+func (d *Decoder) readStrictArray() (interface{}, error) {
+	l, err := readUint32(d.r)
+	if err != nil {
+		return nil, err
+	}
+	index := d.addRef(nil)
+	arr := make([]interface{}, 0, preallocSize(l))
+	for i := uint32(0); i < l; i++ {
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	d.refs[index] = arr
+	return arr, nil
+}
+
+type amf3Traits struct {
+	className      string
+	dynamic        bool
+	externalizable bool
+	members        []string
+}
+
+// amf3Reader decodes AMF3 values, reference tables are kept until the reader is dropped
+type amf3Reader struct {
+	r       *bufio.Reader
+	strings []string
+	objects []interface{}
+	traits  []*amf3Traits
+	ordered bool
+}
+
+func newAmf3Reader(r *bufio.Reader) *amf3Reader {
+	return &amf3Reader{
+		r:       r,
+		strings: make([]string, 0),
+		objects: make([]interface{}, 0),
+		traits:  make([]*amf3Traits, 0),
+	}
+}
+
+func (r *amf3Reader) readU29() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		b, err := readByte(r.r)
+		if err != nil {
+			return 0, err
+		}
+		if i == 3 {
+			return v<<8 | uint32(b), nil
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v, nil
+}
+
+func (r *amf3Reader) readInteger() (int32, error) {
+	v, err := r.readU29()
+	if err != nil {
+		return 0, err
+	}
+	// sign extend 29 bits integer
+	if v&0x10000000 != 0 {
+		return int32(v) - 0x20000000, nil
+	}
+	return int32(v), nil
+}
+
+func (r *amf3Reader) readString() (string, error) {
+	ref, err := r.readU29()
+	if err != nil {
+		return "", err
+	}
+	if ref&1 == 0 {
+		index := int(ref >> 1)
+		if index >= len(r.strings) {
+			return "", fmt.Errorf("amf: AMF3 string reference %v out of range", index)
+		}
+		return r.strings[index], nil
+	}
+	b, err := readBytes(r.r, int64(ref>>1))
+	if err != nil {
+		return "", err
+	}
+	str := string(b)
+	// empty string is never sent by reference
+	if str != "" {
+		r.strings = append(r.strings, str)
+	}
+	return str, nil
+}
+
+// readObjectRef reads U29 header of a referenceable value, the referenced object is returned if it is a reference
+func (r *amf3Reader) readObjectRef() (uint32, interface{}, bool, error) {
+	ref, err := r.readU29()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if ref&1 == 0 {
+		index := int(ref >> 1)
+		if index >= len(r.objects) {
+			return 0, nil, false, fmt.Errorf("amf: AMF3 object reference %v out of range", index)
+		}
+		return 0, r.objects[index], true, nil
+	}
+	return ref >> 1, nil, false, nil
+}
+
+func (r *amf3Reader) addObject(o interface{}) int {
+	r.objects = append(r.objects, o)
+	return len(r.objects) - 1
+}
+
+func (r *amf3Reader) readValue() (interface{}, error) {
+	marker, err := readByte(r.r)
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case amf3Undefined:
+		return Undefined{}, nil
+	case amf3Null:
+		return nil, nil
+	case amf3False:
+		return false, nil
+	case amf3True:
+		return true, nil
+	case amf3Integer:
+		// numbers are float64 as AMF0 numbers
+		v, err := r.readInteger()
+		return float64(v), err
+	case amf3Double:
+		return readDouble(r.r)
+	case amf3String:
+		return r.readString()
+	case amf3XMLDoc, amf3XML:
+		return r.readXML(marker)
+	case amf3Date:
+		return r.readDate()
+	case amf3Array:
+		return r.readArray()
+	case amf3Object:
+		return r.readObject()
+	case amf3ByteArray:
+		return r.readByteArray()
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return r.readVector(marker)
+	case amf3Dictionary:
+		return r.readDictionary()
+	default:
+		return nil, fmt.Errorf("amf: unexpected AMF3 marker %d", marker)
+	}
+}
+
+func (r *amf3Reader) readXML(marker byte) (interface{}, error) {
+	l, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	b, err := readBytes(r.r, int64(l))
+	if err != nil {
+		return nil, err
+	}
+	if marker == amf3XMLDoc {
+		o = XMLDocument(b)
+	} else {
+		o = XML(b)
+	}
+	r.addObject(o)
+	return o, nil
+}
+
+func (r *amf3Reader) readDate() (interface{}, error) {
+	_, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	ms, err := readDouble(r.r)
+	if err != nil {
+		return nil, err
+	}
+	t := msToTime(ms)
+	r.addObject(t)
+	return t, nil
+}
+
+func (r *amf3Reader) readByteArray() (interface{}, error) {
+	l, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	b, err := readBytes(r.r, int64(l))
+	if err != nil {
+		return nil, err
+	}
+	r.addObject(b)
+	return b, nil
+}
+
+func (r *amf3Reader) readArray() (interface{}, error) {
+	l, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	index := r.addObject(nil)
+
+	assoc := make(map[string]interface{})
+	for {
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			break
+		}
+		if assoc[key], err = r.readValue(); err != nil {
+			return nil, err
+		}
+	}
+	dense := make([]interface{}, 0, preallocSize(l))
+	for i := uint32(0); i < l; i++ {
+		v, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		dense = append(dense, v)
+	}
+
+	switch {
+	case len(assoc) == 0:
+		o = dense
+	case len(dense) == 0:
+		o = assoc
+	default:
+		o = &Array{Dense: dense, Assoc: assoc}
+	}
+	r.objects[index] = o
+	return o, nil
+}
+
+func (r *amf3Reader) readTraits(ref uint32) (*amf3Traits, error) {
+	// ref is U29O-traits without the object reference bit
+	if ref&1 == 0 {
+		index := int(ref >> 1)
+		if index >= len(r.traits) {
+			return nil, fmt.Errorf("amf: AMF3 traits reference %v out of range", index)
+		}
+		return r.traits[index], nil
+	}
+	traits := &amf3Traits{
+		externalizable: ref&2 != 0,
+		dynamic:        ref&4 != 0,
+	}
+	var err error
+	if traits.className, err = r.readString(); err != nil {
+		return nil, err
+	}
+	if !traits.externalizable {
+		count := ref >> 3
+		traits.members = make([]string, 0, preallocSize(count))
+		for i := uint32(0); i < count; i++ {
+			name, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			traits.members = append(traits.members, name)
+		}
+	}
+	r.traits = append(r.traits, traits)
+	return traits, nil
+}
+
+func (r *amf3Reader) readObject() (interface{}, error) {
+	ref, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	traits, err := r.readTraits(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if traits.externalizable {
+		switch traits.className {
+		case "flex.messaging.io.ArrayCollection", "flex.messaging.io.ObjectProxy":
+			// the wrapped value is serialized as is
+			index := r.addObject(nil)
+			v, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			r.objects[index] = v
+			return v, nil
+		default:
+			return nil, fmt.Errorf("amf: externalizable AMF3 class %v is not supported", traits.className)
+		}
+	}
+
+	index := r.addObject(nil)
+	props := make([]Property, 0, len(traits.members))
+	for _, name := range traits.members {
+		value, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, Property{Name: name, Value: value})
+	}
+	if traits.dynamic {
+		for {
+			name, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				break
+			}
+			value, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, Property{Name: name, Value: value})
+		}
+	}
+
+	switch {
+	case traits.className != "":
+		o = &TypedObject{
+			ClassName: traits.className,
+			Sealed:    traits.members,
+			Dynamic:   traits.dynamic,
+			Members:   Object(props).Map(),
+		}
+	case r.ordered:
+		o = Object(props)
+	default:
+		o = Object(props).Map()
+	}
+	r.objects[index] = o
+	return o, nil
+}
+
+func (r *amf3Reader) readVector(marker byte) (interface{}, error) {
+	l, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	fixed, err := readByte(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf3VectorInt:
+		v := make([]int32, 0, preallocSize(l))
+		for i := uint32(0); i < l; i++ {
+			n, err := readUint32(r.r)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, int32(n))
+		}
+		o = v
+	case amf3VectorUint:
+		v := make([]uint32, 0, preallocSize(l))
+		for i := uint32(0); i < l; i++ {
+			n, err := readUint32(r.r)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, n)
+		}
+		o = v
+	case amf3VectorDouble:
+		v := make([]float64, 0, preallocSize(l))
+		for i := uint32(0); i < l; i++ {
+			n, err := readDouble(r.r)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, n)
+		}
+		o = v
+	default:
+		typeName, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		v := &ObjectVector{
+			TypeName: typeName,
+			Fixed:    fixed != 0,
+			Items:    make([]interface{}, 0, preallocSize(l)),
+		}
+		r.addObject(v)
+		for i := uint32(0); i < l; i++ {
+			item, err := r.readValue()
+			if err != nil {
+				return nil, err
+			}
+			v.Items = append(v.Items, item)
+		}
+		return v, nil
+	}
+	r.addObject(o)
+	return o, nil
+}
+
+func (r *amf3Reader) readDictionary() (interface{}, error) {
+	l, o, isRef, err := r.readObjectRef()
+	if err != nil || isRef {
+		return o, err
+	}
+	weak, err := readByte(r.r)
+	if err != nil {
+		return nil, err
+	}
+	dict := &Dictionary{
+		WeakKeys: weak != 0,
+		Entries:  make([]DictionaryEntry, 0, preallocSize(l)),
+	}
+	r.addObject(dict)
+	for i := uint32(0); i < l; i++ {
+		var entry DictionaryEntry
+		if entry.Key, err = r.readValue(); err != nil {
+			return nil, err
+		}
+		if entry.Value, err = r.readValue(); err != nil {
+			return nil, err
+		}
+		dict.Entries = append(dict.Entries, entry)
+	}
+	return dict, nil
+}
@@ -0,0 +1,585 @@
+package amf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+const (
+	amf3IntMax = 0x0FFFFFFF
+	amf3IntMin = -0x10000000
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// Encoder writes AMF values to an output stream. AMF3 strings and traits are sent by reference
+// once written by the encoder, objects are always written inline
+type Encoder struct {
+	w       io.Writer
+	version Version
+	amf3    *amf3Writer
+}
+
+// NewEncoder returns an encoder writing values of the given version to w
+func NewEncoder(w io.Writer, version Version) *Encoder {
+	return &Encoder{
+		w:       w,
+		version: version,
+		amf3:    newAmf3Writer(),
+	}
+}
+
+// Encode writes encoding of v
+func (e *Encoder) Encode(v interface{}) error {
+	var data []byte
+	var err error
+	if e.version == AMF3 {
+		e.amf3.data = e.amf3.data[:0]
+		if err = e.amf3.writeValue(reflect.ValueOf(v)); err != nil {
+			return err
+		}
+		data = e.amf3.data
+	} else {
+		if data, err = appendAMF0(nil, reflect.ValueOf(v)); err != nil {
+			return err
+		}
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// indirect follows pointers and interfaces, invalid value is returned for nil
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		// AMF types with pointer receivers are handled as they are
+		if v.Kind() == reflect.Ptr && isAMFPointer(v.Type()) {
+			return v
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isAMFPointer(t reflect.Type) bool {
+	switch t {
+	case reflect.TypeOf(&TypedObject{}), reflect.TypeOf(&Array{}),
+		reflect.TypeOf(&ObjectVector{}), reflect.TypeOf(&Dictionary{}):
+		return true
+	}
+	return false
+}
+
+// isAMF3Only tells whether the value can only be written by switching to AMF3 in AMF0
+func isAMF3Only(v reflect.Value) bool {
+	switch v.Type() {
+	case reflect.TypeOf(XML("")), reflect.TypeOf(&Array{}), reflect.TypeOf(&ObjectVector{}),
+		reflect.TypeOf(&Dictionary{}), bytesType:
+		return true
+	}
+	return false
+}
+
+func appendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendDouble(dst []byte, v float64) []byte {
+	dst = append(dst, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(dst[len(dst)-8:], math.Float64bits(v))
+	return dst
+}
+
+func timeToMs(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func appendAMF0(dst []byte, v reflect.Value) ([]byte, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return append(dst, amf0Null), nil
+	}
+	if isAMF3Only(v) {
+		// each switch to AMF3 starts with empty reference tables
+		w := newAmf3Writer()
+		if err := w.writeValue(v); err != nil {
+			return nil, err
+		}
+		return append(append(dst, amf0AvmPlus), w.data...), nil
+	}
+
+	switch v.Type() {
+	case reflect.TypeOf(Undefined{}):
+		return append(dst, amf0Undefined), nil
+	case reflect.TypeOf(XMLDocument("")):
+		dst = append(dst, amf0XMLDocument)
+		dst = appendUint32(dst, uint32(v.Len()))
+		return append(dst, v.String()...), nil
+	case timeType:
+		dst = append(dst, amf0Date)
+		dst = appendDouble(dst, timeToMs(v.Interface().(time.Time)))
+		return append(dst, 0, 0), nil // time zone is reserved
+	case reflect.TypeOf(Object{}):
+		return appendAMF0Properties(append(dst, amf0Object), v.Interface().(Object))
+	case reflect.TypeOf(ECMAArray{}):
+		dst = append(dst, amf0ECMAArray)
+		dst = appendUint32(dst, uint32(v.Len()))
+		return appendAMF0Properties(dst, v.Interface().(ECMAArray))
+	case reflect.TypeOf(&TypedObject{}):
+		o := v.Interface().(*TypedObject)
+		dst = append(dst, amf0TypedObject)
+		var err error
+		if dst, err = appendAMF0Key(dst, o.ClassName); err != nil {
+			return nil, err
+		}
+		return appendAMF0Map(dst, reflect.ValueOf(o.Members))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(dst, amf0Boolean, 0x01), nil
+		}
+		return append(dst, amf0Boolean, 0x00), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendDouble(append(dst, amf0Number), float64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendDouble(append(dst, amf0Number), float64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return appendDouble(append(dst, amf0Number), v.Float()), nil
+	case reflect.String:
+		str := v.String()
+		if len(str) > 0xFFFF {
+			dst = append(dst, amf0LongString)
+			dst = appendUint32(dst, uint32(len(str)))
+			return append(dst, str...), nil
+		}
+		dst = append(dst, amf0String)
+		dst = appendUint16(dst, uint16(len(str)))
+		return append(dst, str...), nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("amf: map key type %v is not supported", v.Type().Key())
+		}
+		return appendAMF0Map(append(dst, amf0Object), v)
+	case reflect.Struct:
+		return appendAMF0Struct(append(dst, amf0Object), v)
+	case reflect.Slice, reflect.Array:
+		dst = append(dst, amf0StrictArray)
+		dst = appendUint32(dst, uint32(v.Len()))
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if dst, err = appendAMF0(dst, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	default:
+		return nil, fmt.Errorf("amf: AMF0 type %v is not supported", v.Type())
+	}
+}
+
+// appendAMF0Key writes object property name, which is a string without marker
+func appendAMF0Key(dst []byte, key string) ([]byte, error) {
+	if len(key) > 0xFFFF {
+		return nil, errors.New("amf: property name too long")
+	}
+	dst = appendUint16(dst, uint16(len(key)))
+	return append(dst, key...), nil
+}
+
+// appendAMF0Map writes map entries as properties followed by object-end marker
+func appendAMF0Map(dst []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	iter := v.MapRange()
+	for iter.Next() {
+		if dst, err = appendAMF0Key(dst, iter.Key().String()); err != nil {
+			return nil, err
+		}
+		if dst, err = appendAMF0(dst, iter.Value()); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, 0x00, 0x00, amf0ObjectEnd), nil
+}
+
+// appendAMF0Properties writes properties in order followed by object-end marker
+func appendAMF0Properties(dst []byte, props []Property) ([]byte, error) {
+	var err error
+	for _, p := range props {
+		if dst, err = appendAMF0Key(dst, p.Name); err != nil {
+			return nil, err
+		}
+		if dst, err = appendAMF0(dst, reflect.ValueOf(p.Value)); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, 0x00, 0x00, amf0ObjectEnd), nil
+}
+
+func appendAMF0Struct(dst []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if dst, err = appendAMF0Key(dst, f.name); err != nil {
+			return nil, err
+		}
+		if dst, err = appendAMF0(dst, fv); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, 0x00, 0x00, amf0ObjectEnd), nil
+}
+
+// amf3Writer encodes AMF3 values, strings and traits are sent by reference once written.
+// Objects are always written inline, so that values shared in the input are duplicated.
+type amf3Writer struct {
+	data    []byte
+	strings map[string]int
+	traits  map[string]int
+}
+
+func newAmf3Writer() *amf3Writer {
+	return &amf3Writer{
+		data:    make([]byte, 0),
+		strings: make(map[string]int),
+		traits:  make(map[string]int),
+	}
+}
+
+func (w *amf3Writer) writeU29(v uint32) error {
+	switch {
+	case v < 0x80:
+		w.data = append(w.data, byte(v))
+	case v < 0x4000:
+		w.data = append(w.data, byte(v>>7|0x80), byte(v&0x7F))
+	case v < 0x200000:
+		w.data = append(w.data, byte(v>>14|0x80), byte(v>>7|0x80), byte(v&0x7F))
+	case v < 0x20000000:
+		w.data = append(w.data, byte(v>>22|0x80), byte(v>>15|0x80), byte(v>>8|0x80), byte(v))
+	default:
+		return fmt.Errorf("amf: %v out of U29 range", v)
+	}
+	return nil
+}
+
+func (w *amf3Writer) writeInteger(v int64) error {
+	if v < amf3IntMin || v > amf3IntMax {
+		w.data = appendDouble(append(w.data, amf3Double), float64(v))
+		return nil
+	}
+	w.data = append(w.data, amf3Integer)
+	return w.writeU29(uint32(v) & 0x1FFFFFFF)
+}
+
+func (w *amf3Writer) writeString(str string) error {
+	if index, ok := w.strings[str]; ok {
+		return w.writeU29(uint32(index) << 1)
+	}
+	if str != "" {
+		w.strings[str] = len(w.strings)
+	}
+	if err := w.writeU29(uint32(len(str))<<1 | 1); err != nil {
+		return err
+	}
+	w.data = append(w.data, str...)
+	return nil
+}
+
+func (w *amf3Writer) writeBytes(b []byte) error {
+	if err := w.writeU29(uint32(len(b))<<1 | 1); err != nil {
+		return err
+	}
+	w.data = append(w.data, b...)
+	return nil
+}
+
+func (w *amf3Writer) writeValue(v reflect.Value) error {
+	v = indirect(v)
+	if !v.IsValid() {
+		w.data = append(w.data, amf3Null)
+		return nil
+	}
+
+	switch v.Type() {
+	case reflect.TypeOf(Undefined{}):
+		w.data = append(w.data, amf3Undefined)
+		return nil
+	case reflect.TypeOf(XMLDocument("")):
+		w.data = append(w.data, amf3XMLDoc)
+		return w.writeBytes([]byte(v.String()))
+	case reflect.TypeOf(XML("")):
+		w.data = append(w.data, amf3XML)
+		return w.writeBytes([]byte(v.String()))
+	case timeType:
+		w.data = append(w.data, amf3Date, 0x01)
+		w.data = appendDouble(w.data, timeToMs(v.Interface().(time.Time)))
+		return nil
+	case bytesType:
+		w.data = append(w.data, amf3ByteArray)
+		return w.writeBytes(v.Bytes())
+	case reflect.TypeOf([]int32(nil)):
+		w.writeVectorHeader(amf3VectorInt, v.Len(), false)
+		for _, n := range v.Interface().([]int32) {
+			w.data = appendUint32(w.data, uint32(n))
+		}
+		return nil
+	case reflect.TypeOf([]uint32(nil)):
+		w.writeVectorHeader(amf3VectorUint, v.Len(), false)
+		for _, n := range v.Interface().([]uint32) {
+			w.data = appendUint32(w.data, n)
+		}
+		return nil
+	case reflect.TypeOf([]float64(nil)):
+		w.writeVectorHeader(amf3VectorDouble, v.Len(), false)
+		for _, n := range v.Interface().([]float64) {
+			w.data = appendDouble(w.data, n)
+		}
+		return nil
+	case reflect.TypeOf(&ObjectVector{}):
+		o := v.Interface().(*ObjectVector)
+		w.writeVectorHeader(amf3VectorObject, len(o.Items), o.Fixed)
+		if err := w.writeString(o.TypeName); err != nil {
+			return err
+		}
+		for _, item := range o.Items {
+			if err := w.writeValue(reflect.ValueOf(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.TypeOf(&Dictionary{}):
+		return w.writeDictionary(v.Interface().(*Dictionary))
+	case reflect.TypeOf(&Array{}):
+		o := v.Interface().(*Array)
+		return w.writeArray(reflect.ValueOf(o.Dense), reflect.ValueOf(o.Assoc))
+	case reflect.TypeOf(&TypedObject{}):
+		o := v.Interface().(*TypedObject)
+		return w.writeObject(o.ClassName, o.Sealed, o.Dynamic, reflect.ValueOf(o.Members))
+	case reflect.TypeOf(Object{}):
+		return w.writeProperties(v.Interface().(Object))
+	case reflect.TypeOf(ECMAArray{}):
+		return w.writeProperties(v.Interface().(ECMAArray))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			w.data = append(w.data, amf3True)
+		} else {
+			w.data = append(w.data, amf3False)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return w.writeInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > amf3IntMax {
+			w.data = appendDouble(append(w.data, amf3Double), float64(v.Uint()))
+			return nil
+		}
+		return w.writeInteger(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		w.data = appendDouble(append(w.data, amf3Double), v.Float())
+		return nil
+	case reflect.String:
+		w.data = append(w.data, amf3String)
+		return w.writeString(v.String())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("amf: map key type %v is not supported", v.Type().Key())
+		}
+		return w.writeObject("", nil, true, v)
+	case reflect.Struct:
+		return w.writeStruct(v)
+	case reflect.Slice, reflect.Array:
+		return w.writeArray(v, reflect.Value{})
+	default:
+		return fmt.Errorf("amf: AMF3 type %v is not supported", v.Type())
+	}
+}
+
+func (w *amf3Writer) writeVectorHeader(marker byte, l int, fixed bool) {
+	w.data = append(w.data, marker)
+	w.writeU29(uint32(l)<<1 | 1)
+	if fixed {
+		w.data = append(w.data, 0x01)
+	} else {
+		w.data = append(w.data, 0x00)
+	}
+}
+
+func (w *amf3Writer) writeDictionary(dict *Dictionary) error {
+	w.data = append(w.data, amf3Dictionary)
+	if err := w.writeU29(uint32(len(dict.Entries))<<1 | 1); err != nil {
+		return err
+	}
+	if dict.WeakKeys {
+		w.data = append(w.data, 0x01)
+	} else {
+		w.data = append(w.data, 0x00)
+	}
+	for _, entry := range dict.Entries {
+		if err := w.writeValue(reflect.ValueOf(entry.Key)); err != nil {
+			return err
+		}
+		if err := w.writeValue(reflect.ValueOf(entry.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArray writes dense part from a slice and associative part from a map, either can be invalid
+func (w *amf3Writer) writeArray(dense reflect.Value, assoc reflect.Value) error {
+	w.data = append(w.data, amf3Array)
+	l := 0
+	if dense.IsValid() {
+		l = dense.Len()
+	}
+	if err := w.writeU29(uint32(l)<<1 | 1); err != nil {
+		return err
+	}
+	if assoc.IsValid() {
+		if err := w.writeMembers(assoc, nil); err != nil {
+			return err
+		}
+	}
+	if err := w.writeString(""); err != nil {
+		return err
+	}
+	for i := 0; i < l; i++ {
+		if err := w.writeValue(dense.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTraits writes traits inline the first time, and by reference afterwards
+func (w *amf3Writer) writeTraits(className string, sealed []string, dynamic bool) error {
+	key := fmt.Sprintf("%v/%v/%q", className, dynamic, sealed)
+	if index, ok := w.traits[key]; ok {
+		return w.writeU29(uint32(index)<<2 | 0x01)
+	}
+	w.traits[key] = len(w.traits)
+	flags := uint32(len(sealed))<<4 | 0x03
+	if dynamic {
+		flags |= 0x08
+	}
+	if err := w.writeU29(flags); err != nil {
+		return err
+	}
+	if err := w.writeString(className); err != nil {
+		return err
+	}
+	for _, name := range sealed {
+		if err := w.writeString(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMembers writes map entries not in skip as name value pairs
+func (w *amf3Writer) writeMembers(m reflect.Value, skip map[string]bool) error {
+	iter := m.MapRange()
+	for iter.Next() {
+		name := iter.Key().String()
+		if name == "" || skip[name] {
+			continue
+		}
+		if err := w.writeString(name); err != nil {
+			return err
+		}
+		if err := w.writeValue(iter.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *amf3Writer) writeObject(className string, sealed []string, dynamic bool, members reflect.Value) error {
+	w.data = append(w.data, amf3Object)
+	if err := w.writeTraits(className, sealed, dynamic); err != nil {
+		return err
+	}
+
+	isSealed := make(map[string]bool)
+	for _, name := range sealed {
+		isSealed[name] = true
+		var v reflect.Value
+		if members.IsValid() && !members.IsNil() {
+			v = members.MapIndex(reflect.ValueOf(name))
+		}
+		if err := w.writeValue(v); err != nil {
+			return err
+		}
+	}
+	if !dynamic {
+		return nil
+	}
+	if members.IsValid() {
+		if err := w.writeMembers(members, isSealed); err != nil {
+			return err
+		}
+	}
+	return w.writeString("")
+}
+
+// writeProperties writes properties in order as anonymous dynamic object
+func (w *amf3Writer) writeProperties(props []Property) error {
+	w.data = append(w.data, amf3Object)
+	if err := w.writeTraits("", nil, true); err != nil {
+		return err
+	}
+	for _, p := range props {
+		if p.Name == "" {
+			continue
+		}
+		if err := w.writeString(p.Name); err != nil {
+			return err
+		}
+		if err := w.writeValue(reflect.ValueOf(p.Value)); err != nil {
+			return err
+		}
+	}
+	return w.writeString("")
+}
+
+// writeStruct writes struct as anonymous dynamic object
+func (w *amf3Writer) writeStruct(v reflect.Value) error {
+	w.data = append(w.data, amf3Object)
+	if err := w.writeTraits("", nil, true); err != nil {
+		return err
+	}
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		if err := w.writeString(f.name); err != nil {
+			return err
+		}
+		if err := w.writeValue(fv); err != nil {
+			return err
+		}
+	}
+	return w.writeString("")
+}
@@ -0,0 +1,312 @@
+package amf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field is an encoded field of a struct
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns fields of struct type t named by `amf:"name,omitempty"` tags.
+// Fields tagged "-" and unexported fields are ignored, embedded structs without tag are flattened
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	fields := typeFields(t, nil)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func typeFields(t reflect.Type, index []int) []field {
+	fields := make([]field, 0)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("amf")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		opts := ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			fields = append(fields, typeFields(ft, fieldIndex)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// fieldByIndex returns the field, false if it is in a nil embedded struct pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc returns the field for setting, nil embedded struct pointers are allocated
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// UnmarshalTypeError describes a value not appropriate for the Go type it is decoded into
+type UnmarshalTypeError struct {
+	Value interface{}
+	Type  reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("amf: cannot unmarshal %T into Go value of type %v", e.Value, e.Type)
+}
+
+// assign stores decoded value src into dst
+func assign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	if _, ok := src.(Undefined); ok {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), src)
+	}
+
+	mismatch := &UnmarshalTypeError{Value: src, Type: dst.Type()}
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toFloat(src)
+		if !ok || dst.OverflowInt(int64(n)) {
+			return mismatch
+		}
+		dst.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := toFloat(src)
+		if !ok || n < 0 || dst.OverflowUint(uint64(n)) {
+			return mismatch
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, ok := toFloat(src)
+		if !ok {
+			return mismatch
+		}
+		dst.SetFloat(n)
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dst.SetString(v)
+		case XMLDocument:
+			dst.SetString(string(v))
+		case XML:
+			dst.SetString(string(v))
+		default:
+			return mismatch
+		}
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			return mismatch
+		}
+		members := membersOf(src)
+		if members == nil {
+			return mismatch
+		}
+		return assignStruct(dst, members)
+	case reflect.Map:
+		members := membersOf(src)
+		if members == nil || dst.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for k, v := range members {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(elem, v); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+		}
+	case reflect.Slice, reflect.Array:
+		items := reflect.ValueOf(itemsOf(src))
+		if items.Kind() != reflect.Slice {
+			return mismatch
+		}
+		if dst.Kind() == reflect.Slice {
+			dst.Set(reflect.MakeSlice(dst.Type(), items.Len(), items.Len()))
+		} else if dst.Len() < items.Len() {
+			return mismatch
+		}
+		for i := 0; i < items.Len(); i++ {
+			if err := assign(dst.Index(i), items.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// toFloat returns numbers, including items of AMF3 int and uint vectors
+func toFloat(src interface{}) (float64, bool) {
+	switch v := src.(type) {
+	case float64:
+		return v, true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// membersOf returns properties of object like values
+func membersOf(src interface{}) map[string]interface{} {
+	switch v := src.(type) {
+	case map[string]interface{}:
+		return v
+	case Object:
+		return v.Map()
+	case ECMAArray:
+		return v.Map()
+	case *TypedObject:
+		return v.Members
+	case *Array:
+		return v.Assoc
+	}
+	return nil
+}
+
+// itemsOf returns items of array like values
+func itemsOf(src interface{}) interface{} {
+	switch v := src.(type) {
+	case *Array:
+		return v.Dense
+	case *ObjectVector:
+		return v.Items
+	case []interface{}, []byte, []int32, []uint32, []float64:
+		return v
+	}
+	return nil
+}
+
+// assignStruct assigns members to fields of the struct. Like encoding/json, a member of
+// mismatched type does not stop the others from being assigned, and the first error is returned
+func assignStruct(dst reflect.Value, members map[string]interface{}) error {
+	fields := cachedFields(dst.Type())
+	keys := make([]string, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // the same error is returned on every run
+	var firstErr error
+	for _, key := range keys {
+		value := members[key]
+		var f *field
+		for i := range fields {
+			if fields[i].name == key {
+				f = &fields[i]
+				break
+			}
+		}
+		if f == nil {
+			for i := range fields {
+				if strings.EqualFold(fields[i].name, key) {
+					f = &fields[i]
+					break
+				}
+			}
+		}
+		if f == nil {
+			continue
+		}
+		if err := assign(fieldByIndexAlloc(dst, f.index), value); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
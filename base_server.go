@@ -1,6 +1,7 @@
 package rtmp
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -10,6 +11,9 @@ import (
 	l "github.com/junli1026/gortmp/logging"
 )
 
+// errCloseAfterReply is returned by serverImpl.read to close the connection once the reply is written
+var errCloseAfterReply = errors.New("connection closed by server")
+
 type serverImpl interface {
 	newContext(conn net.Conn) interface{}
 	read(data []byte, context interface{}) (int, []byte, error)
@@ -72,8 +76,8 @@ func (h *connHandler) writeAll(data []byte) error {
 }
 
 func (h *connHandler) run() {
-	h.s.wg.Add(1)
-	defer h.s.wg.Done()
+	defer h.s.removeConn(h.conn)
+	defer h.conn.Close()
 	for {
 		if err := h.read(); err != nil {
 			h.s.impl.close(err, h.context)
@@ -82,8 +86,17 @@ func (h *connHandler) run() {
 
 		for {
 			length, reply, err := h.s.impl.read(h.readbuf, h.context)
+			if err == errCloseAfterReply {
+				if werr := h.writeAll(reply); werr != nil {
+					err = werr
+				}
+				l.Logger.Infof("close connection from %v", h.conn.RemoteAddr())
+				h.s.impl.close(err, h.context)
+				return
+			}
 			if err != nil {
 				l.Logger.Errorf("application 'read' returns error: %v", err)
+				h.s.impl.close(err, h.context)
 				return
 			}
 
@@ -110,65 +123,108 @@ const (
 )
 
 type baseServer struct {
-	addr     *net.TCPAddr
-	listener *net.TCPListener
-	mux      sync.Mutex
-	state    serverState
-	impl     serverImpl
-	wg       sync.WaitGroup
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	mux       sync.Mutex
+	state     serverState
+	impl      serverImpl
+	wg        sync.WaitGroup
 }
 
 func newBaseServer(impl serverImpl) *baseServer {
 	return &baseServer{
-		listener: nil,
-		state:    stopped,
-		wg:       sync.WaitGroup{},
-		impl:     impl,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		state:     stopped,
+		wg:        sync.WaitGroup{},
+		impl:      impl,
 	}
 }
 
 func (s *baseServer) listenAndServe(addr string) error {
-	s.mux.Lock()
-	if s.state != stopped {
-		defer s.mux.Unlock()
-		return errors.New("server is not in STOPPED state")
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
 	}
-	s.state = running
-	s.mux.Unlock()
 
-	var err error
-	s.addr, err = net.ResolveTCPAddr("tcp", addr)
+	listener, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
-		l.Logger.Fatal(err)
+		return err
 	}
+	return s.serve(listener)
+}
 
-	s.listener, err = net.ListenTCP("tcp", s.addr)
+func (s *baseServer) listenAndServeTLS(addr string, config *tls.Config) error {
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		l.Logger.Fatal(err)
+		return err
 	}
-	defer s.listener.Close()
+	return s.serve(tls.NewListener(listener, config))
+}
+
+func (s *baseServer) addListener(listener net.Listener) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state == stopping {
+		return errors.New("server is in STOPPING state")
+	}
+	s.state = running
+	s.listeners[listener] = struct{}{}
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *baseServer) removeListener(listener net.Listener) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.listeners, listener)
+	s.wg.Done()
+}
+
+// serve accepts connections on listener until the server stops, it can be called
+// concurrently to serve several listeners with one server
+func (s *baseServer) serve(listener net.Listener) error {
+	if err := s.addListener(listener); err != nil {
+		listener.Close()
+		return err
+	}
+	defer s.removeListener(listener)
+	defer listener.Close()
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			s.mux.Lock()
-			if s.state == stopping {
-				s.mux.Unlock()
-				break
+			if s.serverState() == stopping {
+				return nil
 			}
-			s.mux.Unlock()
-			if err != io.EOF {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				l.Logger.Warning(err)
+				time.Sleep(10 * time.Millisecond)
+				continue
 			}
-			continue
+			l.Logger.Errorf("listener %v stops accepting: %v", listener.Addr(), err)
+			return err
 		}
-		defer conn.Close()
 		l.Logger.Infof("new connection accepted from %v\n", conn.RemoteAddr().String())
 
 		h := newHandler(conn, s)
+		s.addConn(conn)
 		go h.run()
 	}
-	return nil
+}
+
+func (s *baseServer) addConn(conn net.Conn) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+}
+
+func (s *baseServer) removeConn(conn net.Conn) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.conns, conn)
+	s.wg.Done()
 }
 
 func (s *baseServer) serverState() serverState {
@@ -186,10 +242,15 @@ func (s *baseServer) stop() {
 		return
 	}
 	s.state = stopping
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
 	s.mux.Unlock()
 
-	s.listener.Close()
-	s.wg.Wait() // wait for all active connection to close
+	s.wg.Wait() // wait for all listeners and active connections to close
 
 	s.mux.Lock()
 	s.state = stopped
@@ -1,6 +1,7 @@
 package rtmp
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -83,3 +84,80 @@ func Test_Echo(t *testing.T) {
 	wg.Wait()
 	s.stop()
 }
+
+// pipeListener is an in-memory listener accepting net.Pipe connections
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+func (l *pipeListener) Dial() net.Conn {
+	server, client := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+func Test_ServeMultipleListeners(t *testing.T) {
+	s := newEchoServer()
+	pipe := newPipeListener()
+	tcp, err := net.Listen("tcp", "127.0.0.1:1236")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.serve(pipe) }()
+	go func() { errs <- s.serve(tcp) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// binding an address in use returns error instead of exiting
+	if err := s.listenAndServe("127.0.0.1:1236"); err == nil {
+		t.Fail()
+	}
+
+	replies := senddata(pipe.Dial(), []string{"through", "pipe"})
+	if len(replies) != 2 || replies[0] != "through" || replies[1] != "pipe" {
+		t.Fail()
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:1236")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies = senddata(conn, []string{"through", "tcp"})
+	if len(replies) != 2 || replies[0] != "through" || replies[1] != "tcp" {
+		t.Fail()
+	}
+
+	s.stop()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fail()
+		}
+	}
+}
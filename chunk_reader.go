@@ -37,6 +37,14 @@ func (r *chunkReader) setChunkSize(chunkSize int) {
 	r.chunkSize = chunkSize
 }
 
+// abort discards the partially received message of the chunk stream
+func (r *chunkReader) abort(csid int) {
+	if cs, ok := r.getStream(csid); ok {
+		cs.payload = make([]byte, 0)
+		cs.remain = 0
+	}
+}
+
 func (r *chunkReader) getStream(csid int) (st *chunkStream, ok bool) {
 	if csid < 64 {
 		st = r.streams0[csid]
@@ -66,18 +74,27 @@ func (r *chunkReader) read(data []byte) (*message.RawMessage, int, error) {
 	var cs *chunkStream = nil
 	if st, ok := r.getStream(int(h.chunkStreamID)); ok {
 		cs = st
-		cs.curr = h
 	} else {
 		cs = &chunkStream{
 			chunkStreamID: int(h.chunkStreamID),
 			prev:          nil,
-			curr:          h,
 			payload:       make([]byte, 0),
 			remain:        0,
 		}
 		r.setStream(int(h.chunkStreamID), cs)
 	}
-	updateHeader(cs.curr, cs.prev)
+
+	// fmt 3 chunk repeats extended timestamp of the previous header
+	if h.format == 3 && cs.prev != nil && cs.prev.extended {
+		if len(data) < length+4 {
+			return nil, 0, nil
+		}
+		length += 4
+	}
+	if err = updateHeader(h, cs.prev, len(cs.payload) > 0); err != nil {
+		return nil, 0, err
+	}
+	cs.curr = h
 
 	/* process chunk message payload */
 	var consumed int
@@ -121,14 +138,20 @@ type chunkHeader struct {
 	streamID       uint32
 	timestampDelta uint32
 	messageLength  uint32
+	extended       bool // timestamp field is 0xFFFFFF, extended timestamp follows
 }
 
-func updateHeader(curr *chunkHeader, prev *chunkHeader) error {
+// updateHeader fills fields omitted by compressed header from the previous header of the chunk stream
+func updateHeader(curr *chunkHeader, prev *chunkHeader, continuation bool) error {
 	if curr.format != 0 && prev == nil {
 		return errors.New("first message fmt is not 0")
 	}
 
 	switch curr.format {
+	case 0:
+		// like ffmpeg and librtmp, a new message with fmt 3 after fmt 0 repeats the timestamp field
+		// of fmt 0 as its delta
+		curr.timestampDelta = curr.timestamp
 	case 1:
 		curr.streamID = prev.streamID
 		curr.timestamp = prev.timestamp + curr.timestampDelta
@@ -143,8 +166,14 @@ func updateHeader(curr *chunkHeader, prev *chunkHeader) error {
 		}
 		curr.streamID = prev.streamID
 		curr.messageLength = prev.messageLength
+		curr.timestampDelta = prev.timestampDelta
 		curr.timestamp = prev.timestamp
+		if !continuation {
+			// a new message with fmt 3 has the same timestamp delta as the previous one
+			curr.timestamp += prev.timestampDelta
+		}
 		curr.typeID = prev.typeID
+		curr.extended = prev.extended
 	}
 
 	logging.Logger.Debugf(
@@ -208,11 +237,11 @@ func (h *chunkHeader) readBasicHeader(data []byte) (int, error) {
 		}
 		h.chunkStreamID = uint32(data[1]) + 64
 		return 2, nil
-	case 0x3F:
+	case 0x01:
 		if len(data) < 3 {
 			return 0, nil
 		}
-		h.chunkStreamID = uint32(data[2])*256 + uint32(data[1])
+		h.chunkStreamID = uint32(data[2])*256 + uint32(data[1]) + 64
 		return 3, nil
 	default:
 		h.chunkStreamID = uint32(chunkStreamID)
@@ -232,6 +261,7 @@ func (h *chunkHeader) readMessageType0(data []byte) (int, error) {
 
 	// read extended timestamp
 	if h.timestamp >= 0xFFFFFF {
+		h.extended = true
 		if len(data) < chunkHeaderSize[0]+4 {
 			return 0, nil
 		}
@@ -253,6 +283,7 @@ func (h *chunkHeader) readMessageType1(data []byte) (int, error) {
 
 	// read extended timestamp delta
 	if h.timestampDelta >= 0xFFFFFF {
+		h.extended = true
 		if len(data) < chunkHeaderSize[1]+4 {
 			return 0, nil
 		}
@@ -270,6 +301,7 @@ func (h *chunkHeader) readMessageType2(data []byte) (int, error) {
 
 	// read extended timestamp delta
 	if h.timestampDelta >= 0xFFFFFF {
+		h.extended = true
 		if len(data) < chunkHeaderSize[2]+4 {
 			return 0, nil
 		}
@@ -0,0 +1,55 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/junli1026/gortmp/message"
+)
+
+func Test_ChunkReaderAbort(t *testing.T) {
+	w := newChunkWriter()
+	first, err := w.write(nil, message.NewVideoMessage(1, 0, make([]byte, 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.write(nil, message.NewVideoMessage(1, 40, []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first chunk of the message is received, then the message is aborted
+	r := newChunkReader()
+	msgs, err := readAll(r, first[:12+128])
+	if err != nil || len(msgs) != 0 {
+		t.Fatal("message is expected to be incomplete")
+	}
+	r.abort(6)
+
+	msgs, err = readAll(r, second)
+	if err != nil || len(msgs) != 1 {
+		t.Fatal("message after abort is expected to be read")
+	}
+	if msgs[0].Timestamp != 40 || !bytes.Equal(msgs[0].Raw, []byte{1, 2, 3}) {
+		t.Fail()
+	}
+}
+
+func Test_ChunkReaderType3AfterType0(t *testing.T) {
+	// audio of ffmpeg: fmt 0 with timestamp 23, then fmt 3 messages of the same length
+	data := []byte{
+		0x04, 0x00, 0x00, 0x17, 0x00, 0x00, 0x02, 0x08, 0x01, 0x00, 0x00, 0x00, 0xAF, 0x01,
+		0xC4, 0xAF, 0x02,
+		0xC4, 0xAF, 0x03,
+	}
+	msgs, err := readAll(newChunkReader(), data)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("unexpected messages %v, %v", msgs, err)
+	}
+	// the timestamp of fmt 0 is the delta of following fmt 3 messages
+	for i, msg := range msgs {
+		if msg.Timestamp != uint32(23*(i+1)) || msg.StreamID != 1 || msg.MsgType != 8 || msg.Raw[1] != byte(i+1) {
+			t.Errorf("unexpected message %v: %+v", i, msg)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/junli1026/gortmp/message"
+)
+
+// chunkWriter splits outbound messages into chunks, the header of each chunk stream is
+// compressed against the previous message of the same chunk stream
+type chunkWriter struct {
+	streams   map[int]*chunkHeader
+	chunkSize int
+}
+
+func newChunkWriter() *chunkWriter {
+	return &chunkWriter{
+		streams:   make(map[int]*chunkHeader),
+		chunkSize: 128,
+	}
+}
+
+func (w *chunkWriter) setChunkSize(chunkSize int) {
+	w.chunkSize = chunkSize
+}
+
+// write appends chunks of the message to dst
+func (w *chunkWriter) write(dst []byte, msg message.Message) ([]byte, error) {
+	raw, err := message.ToRaw(msg)
+	if err != nil {
+		return dst, err
+	}
+	if raw.ChunkStreamID < 2 || raw.ChunkStreamID > 65599 {
+		return dst, fmt.Errorf("chunk stream id %v out of range", raw.ChunkStreamID)
+	}
+
+	curr := &chunkHeader{
+		chunkStreamID: uint32(raw.ChunkStreamID),
+		timestamp:     raw.Timestamp,
+		typeID:        raw.MsgType,
+		streamID:      uint32(raw.StreamID),
+		messageLength: uint32(len(raw.Raw)),
+	}
+	prev := w.streams[raw.ChunkStreamID]
+	curr.format = selectFormat(curr, prev)
+	w.streams[raw.ChunkStreamID] = curr
+
+	// value of timestamp field, extended timestamp is written after message header if it does not fit
+	field := curr.timestamp
+	if curr.format != 0 {
+		field = curr.timestampDelta
+	}
+	curr.extended = field >= 0xFFFFFF
+
+	dst = writeBasicHeader(dst, curr.format, raw.ChunkStreamID)
+	switch curr.format {
+	case 0:
+		dst = appendUint24(dst, minUint32(field, 0xFFFFFF))
+		dst = appendUint24(dst, curr.messageLength)
+		dst = append(dst, curr.typeID)
+		dst = append(dst, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(dst[len(dst)-4:], curr.streamID)
+	case 1:
+		dst = appendUint24(dst, minUint32(field, 0xFFFFFF))
+		dst = appendUint24(dst, curr.messageLength)
+		dst = append(dst, curr.typeID)
+	case 2:
+		dst = appendUint24(dst, minUint32(field, 0xFFFFFF))
+	}
+	if curr.extended {
+		dst = appendUint32(dst, field)
+	}
+
+	payload := raw.Raw
+	for {
+		sz := len(payload)
+		if sz > w.chunkSize {
+			sz = w.chunkSize
+		}
+		dst = append(dst, payload[:sz]...)
+		payload = payload[sz:]
+		if len(payload) == 0 {
+			break
+		}
+		// continuation chunk
+		dst = writeBasicHeader(dst, 3, raw.ChunkStreamID)
+		if curr.extended {
+			dst = appendUint32(dst, field)
+		}
+	}
+	return dst, nil
+}
+
+// selectFormat picks the smallest header able to describe curr given the previous header
+func selectFormat(curr *chunkHeader, prev *chunkHeader) byte {
+	if prev == nil || curr.streamID != prev.streamID || curr.timestamp < prev.timestamp {
+		return 0
+	}
+	curr.timestampDelta = curr.timestamp - prev.timestamp
+	if curr.messageLength != prev.messageLength || curr.typeID != prev.typeID {
+		return 1
+	}
+	// a fmt 3 message repeats the delta of previous message, peers disagree on the delta of fmt 0
+	// so fmt 3 only follows fmt 1 or fmt 2
+	if prev.format == 0 || curr.timestampDelta != prev.timestampDelta || prev.extended {
+		return 2
+	}
+	return 3
+}
+
+func writeBasicHeader(dst []byte, format byte, csid int) []byte {
+	switch {
+	case csid < 64:
+		return append(dst, format<<6|byte(csid))
+	case csid < 320:
+		return append(dst, format<<6, byte(csid-64))
+	default:
+		return append(dst, format<<6|1, byte((csid-64)&0xFF), byte((csid-64)>>8))
+	}
+}
+
+func appendUint24(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func minUint32(a uint32, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/junli1026/gortmp/message"
+)
+
+func readAll(r *chunkReader, data []byte) ([]*message.RawMessage, error) {
+	msgs := make([]*message.RawMessage, 0)
+	for len(data) > 0 {
+		msg, consumed, err := r.read(data)
+		if err != nil {
+			return nil, err
+		}
+		if consumed == 0 {
+			break
+		}
+		data = data[consumed:]
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func Test_ChunkWriterRoundTrip(t *testing.T) {
+	large := make([]byte, 1000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	msgs := []*message.VideoMessage{
+		message.NewVideoMessage(1, 0, []byte{1, 2, 3}),
+		message.NewVideoMessage(1, 40, []byte{4, 5, 6}),
+		message.NewVideoMessage(1, 80, []byte{7, 8, 9}),    // same delta and length, fmt 3
+		message.NewVideoMessage(1, 120, large),             // length changes, fmt 1
+		message.NewVideoMessage(1, 0xFFFFFF+10, large),     // extended delta
+		message.NewVideoMessage(1, 0xFFFFFF+20, []byte{1}), // extended timestamp is not repeated
+		message.NewVideoMessage(1, 5, []byte{1}),           // negative delta, fmt 0
+		message.NewVideoMessage(2, 5, []byte{1}),           // stream changes, fmt 0
+	}
+	for _, csid := range []int{6, 100, 400} {
+		w := newChunkWriter()
+		r := newChunkReader()
+		data := make([]byte, 0)
+		var err error
+		for _, msg := range msgs {
+			msg.ChunkStreamID = csid
+			if data, err = w.write(data, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := readAll(r, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(msgs) {
+			t.Fatalf("csid %v: expect %v messages, got %v", csid, len(msgs), len(got))
+		}
+		for i, msg := range got {
+			if msg.ChunkStreamID != csid ||
+				msg.Timestamp != msgs[i].Timestamp ||
+				msg.StreamID != msgs[i].StreamID ||
+				msg.MsgType != 9 ||
+				!bytes.Equal(msg.Raw, msgs[i].Raw) {
+				t.Errorf("csid %v: message %v mismatch", csid, i)
+			}
+		}
+	}
+}
+
+func Test_ChunkWriterFormat(t *testing.T) {
+	w := newChunkWriter()
+	w.setChunkSize(4096)
+	sizes := make([]int, 0)
+	for i := 0; i < 4; i++ {
+		data, _ := w.write(nil, message.NewAudioMessage(1, uint32(i*23), []byte{0xAF, 0x01, 0x02}))
+		sizes = append(sizes, len(data)-3)
+	}
+	// fmt 0, fmt 2 (delta changes from 0 to 23), then fmt 3
+	if sizes[0] != 12 || sizes[1] != 4 || sizes[2] != 1 || sizes[3] != 1 {
+		t.Errorf("unexpected header sizes %v", sizes)
+	}
+
+	// fmt 2 follows fmt 0 even if the delta equals the timestamp of fmt 0
+	w = newChunkWriter()
+	sizes = sizes[:0]
+	for i := 1; i <= 3; i++ {
+		data, _ := w.write(nil, message.NewAudioMessage(1, uint32(i*40), []byte{0xAF, 0x01, 0x02}))
+		sizes = append(sizes, len(data)-3)
+	}
+	if sizes[0] != 12 || sizes[1] != 4 || sizes[2] != 1 {
+		t.Errorf("unexpected header sizes %v", sizes)
+	}
+
+	// fmt 3 continuation chunks carry extended timestamp
+	w = newChunkWriter()
+	data, _ := w.write(nil, message.NewAudioMessage(1, 0x01000000, make([]byte, 200)))
+	if len(data) != 12+4+128+1+4+72 {
+		t.Errorf("unexpected length %v", len(data))
+	}
+	r := newChunkReader()
+	msgs, err := readAll(r, data)
+	if err != nil || len(msgs) != 1 || msgs[0].Timestamp != 0x01000000 {
+		t.Fail()
+	}
+}
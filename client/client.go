@@ -0,0 +1,427 @@
+// Package client is an rtmp client publishing streams to or playing streams from rtmp servers,
+// with the same StreamData the server passes to its handlers
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/logging"
+	"github.com/junli1026/gortmp/message"
+)
+
+// Setting is the setting of Client
+type Setting struct {
+	Timeout     time.Duration // timeout of dialing, handshake and writes, default 10 seconds
+	ReadTimeout time.Duration // the connection is closed if nothing is received in time, default 60 seconds
+	TLSConfig   *tls.Config   // config of rtmps connections, nil verifies the host of the url
+	ChunkSize   int           // chunk size of messages sent, default 4096
+	FlashVer    string        // flashVer of connect, default "FMLE/3.0 (compatible; gortmp)"
+}
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultReadTimeout = 60 * time.Second
+	defaultChunkSize   = 4096
+	defaultFlashVer    = "FMLE/3.0 (compatible; gortmp)"
+)
+
+// ErrUnpublished is returned by Read when the played stream is unpublished, the client keeps
+// playing and gets data again once the stream is published again
+var ErrUnpublished = errors.New("stream unpublished")
+
+// Client is a connection to an rtmp server publishing or playing one stream
+type Client struct {
+	conn       *rtmp.ClientConn
+	setting    Setting
+	app        string
+	tcURL      string
+	streamName string
+	streamID   int
+	txID       int
+	publishing bool
+	meta       *rtmp.StreamMeta
+	pending    []*rtmp.StreamData // data received while waiting for command results
+	readDone   chan struct{}      // closed when the read loop of publishing exits
+	readMux    sync.Mutex
+	readErr    error // error ending the read loop of publishing
+}
+
+// Dial connects to the server of an "rtmp://host[:port]/app/stream" or "rtmps://" url, the last
+// path element and the query are the stream name, e.g. a stream key, and the rest is the app.
+// The default port is 1935 for rtmp and 443 for rtmps
+func Dial(rawURL string, setting *Setting) (*Client, error) {
+	c := &Client{
+		setting: *setting,
+	}
+	if c.setting.Timeout <= 0 {
+		c.setting.Timeout = defaultTimeout
+	}
+	if c.setting.ReadTimeout <= 0 {
+		c.setting.ReadTimeout = defaultReadTimeout
+	}
+	if c.setting.ChunkSize <= 0 {
+		c.setting.ChunkSize = defaultChunkSize
+	}
+	if c.setting.FlashVer == "" {
+		c.setting.FlashVer = defaultFlashVer
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var host string
+	if host, c.app, c.streamName, err = splitURL(u); err != nil {
+		return nil, err
+	}
+	c.tcURL = u.Scheme + "://" + u.Host + "/" + c.app
+
+	dialer := &net.Dialer{Timeout: c.setting.Timeout}
+	var conn net.Conn
+	if u.Scheme == "rtmps" {
+		config := c.setting.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, config)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.conn = rtmp.NewClientConn(conn, c.setting.ReadTimeout, c.setting.Timeout)
+	if err = c.conn.Handshake(); err == nil {
+		err = c.connect()
+	}
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// splitURL returns address, app and stream name of the url
+func splitURL(u *url.URL) (string, string, string, error) {
+	port := "1935"
+	switch u.Scheme {
+	case "rtmp":
+	case "rtmps":
+		port = "443"
+	default:
+		return "", "", "", fmt.Errorf("unsupported scheme %v", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	app, name := path.Split(strings.Trim(u.Path, "/"))
+	app = strings.TrimSuffix(app, "/")
+	if app == "" || name == "" {
+		return "", "", "", fmt.Errorf("missing app or stream name in %v", u.Path)
+	}
+	if u.RawQuery != "" {
+		name += "?" + u.RawQuery
+	}
+	return host, app, name, nil
+}
+
+func (c *Client) nextTxID() int {
+	c.txID++
+	return c.txID
+}
+
+func (c *Client) connect() error {
+	cmd := message.NewAmf0CommandMessage("connect", c.nextTxID())
+	cmd.SetCommandObject(amf.Object{
+		{Name: "app", Value: c.app},
+		{Name: "type", Value: "nonprivate"},
+		{Name: "flashVer", Value: c.setting.FlashVer},
+		{Name: "tcUrl", Value: c.tcURL},
+		{Name: "fpad", Value: false},
+		{Name: "capabilities", Value: 15},
+		{Name: "audioCodecs", Value: 3575},
+		{Name: "videoCodecs", Value: 252},
+		{Name: "videoFunction", Value: 1},
+		{Name: "objectEncoding", Value: 0},
+	})
+	if err := c.conn.WriteMessage(cmd); err != nil {
+		return err
+	}
+	if _, err := c.waitResult(cmd.TransactionID); err != nil {
+		return fmt.Errorf("connect %v: %v", c.tcURL, err)
+	}
+	return c.conn.WriteMessage(message.NewSetChunkSizeMessage(c.setting.ChunkSize))
+}
+
+// createStream creates the message stream of publishing or playing
+func (c *Client) createStream() error {
+	cmd := message.NewAmf0CommandMessage("createStream", c.nextTxID())
+	if err := c.conn.WriteMessage(cmd); err != nil {
+		return err
+	}
+	result, err := c.waitResult(cmd.TransactionID)
+	if err != nil {
+		return fmt.Errorf("createStream: %v", err)
+	}
+	if len(result.Others) < 1 {
+		return errors.New("createStream: missing stream id")
+	}
+	id, ok := result.Others[0].(float64)
+	if !ok {
+		return fmt.Errorf("createStream: invalid stream id %v", result.Others[0])
+	}
+	c.streamID = int(id)
+	c.meta = rtmp.NewStreamMeta(c.app, c.tcURL, c.streamName, c.streamID)
+	return nil
+}
+
+// Publish starts publishing the stream of the url as a live stream
+func (c *Client) Publish() error {
+	release := message.NewAmf0CommandMessage("releaseStream", c.nextTxID())
+	release.AddOther(c.streamName)
+	fcPublish := message.NewAmf0CommandMessage("FCPublish", c.nextTxID())
+	fcPublish.AddOther(c.streamName)
+	if err := c.conn.WriteMessage(release, fcPublish); err != nil {
+		return err
+	}
+	if err := c.createStream(); err != nil {
+		return err
+	}
+
+	cmd := message.NewAmf0CommandMessage("publish", 0)
+	cmd.StreamID = c.streamID
+	cmd.ChunkStreamID = 8
+	cmd.AddOther(c.streamName)
+	cmd.AddOther("live")
+	if err := c.conn.WriteMessage(cmd); err != nil {
+		return err
+	}
+	if err := c.waitStatus("NetStream.Publish.Start"); err != nil {
+		return err
+	}
+	c.publishing = true
+	c.readDone = make(chan struct{})
+	go c.readLoop()
+	return nil
+}
+
+// readLoop reads the connection while publishing, so that pings are answered, acknowledgements
+// are sent and messages of the server do not pile up. The error ending it is returned by Write
+func (c *Client) readLoop() {
+	defer close(c.readDone)
+	for {
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// a server may send nothing to a publisher for a long time
+				continue
+			}
+			c.setReadError(err)
+			return
+		}
+		if cmd, ok := msg.(*message.Amf0CommandMessage); ok && cmd.Name == "onStatus" {
+			if level, _, _ := status(cmd); level == "error" {
+				c.setReadError(statusError(cmd))
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) setReadError(err error) {
+	c.readMux.Lock()
+	c.readErr = err
+	c.readMux.Unlock()
+}
+
+func (c *Client) readError() error {
+	c.readMux.Lock()
+	defer c.readMux.Unlock()
+	return c.readErr
+}
+
+// Play starts playing the stream of the url, data of the stream is returned by Read
+func (c *Client) Play() error {
+	if err := c.createStream(); err != nil {
+		return err
+	}
+	cmd := message.NewAmf0CommandMessage("play", 0)
+	cmd.StreamID = c.streamID
+	cmd.ChunkStreamID = 8
+	cmd.AddOther(c.streamName)
+	cmd.AddOther(-2) // live or recorded
+	buffer := message.NewSetBufferLengthMessage(uint32(c.streamID), 3000)
+	if err := c.conn.WriteMessage(cmd, buffer); err != nil {
+		return err
+	}
+	return c.waitStatus("NetStream.Play.Start")
+}
+
+// waitResult waits for _result of the command, _error is returned as error
+func (c *Client) waitResult(txID int) (*message.Amf0CommandMessage, error) {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return nil, err
+		}
+		if cmd.TransactionID != txID {
+			continue
+		}
+		switch cmd.Name {
+		case "_result":
+			return cmd, nil
+		case "_error":
+			return nil, statusError(cmd)
+		}
+	}
+}
+
+// waitStatus waits for onStatus of the code, statuses of level error are returned as error
+func (c *Client) waitStatus(code string) error {
+	for {
+		cmd, err := c.readCommand()
+		if err != nil {
+			return err
+		}
+		if cmd.Name != "onStatus" {
+			continue
+		}
+		level, statusCode, _ := status(cmd)
+		if level == "error" {
+			return statusError(cmd)
+		}
+		if statusCode == code {
+			return nil
+		}
+	}
+}
+
+// readCommand returns the next command, stream data read meanwhile is kept for Read
+func (c *Client) readCommand() (*message.Amf0CommandMessage, error) {
+	for {
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if cmd, ok := msg.(*message.Amf0CommandMessage); ok {
+			return cmd, nil
+		}
+		if c.meta != nil {
+			c.pending = append(c.pending, rtmp.MessageToStreamData(c.meta, msg)...)
+		}
+	}
+}
+
+// status returns level, code and description of onStatus or _error
+func status(cmd *message.Amf0CommandMessage) (string, string, string) {
+	for _, other := range cmd.Others {
+		if info, ok := other.(map[string]interface{}); ok {
+			level, _ := info["level"].(string)
+			code, _ := info["code"].(string)
+			description, _ := info["description"].(string)
+			return level, code, description
+		}
+	}
+	return "", "", ""
+}
+
+func statusError(cmd *message.Amf0CommandMessage) error {
+	_, code, description := status(cmd)
+	if code == "" {
+		return fmt.Errorf("%v of %v", cmd.Name, cmd.Others)
+	}
+	return fmt.Errorf("%v: %v", code, description)
+}
+
+// Meta returns meta of the stream, it is updated with metadata and sequence headers written or
+// read. It is nil before publishing or playing
+func (c *Client) Meta() *rtmp.StreamMeta {
+	return c.meta
+}
+
+// Write publishes stream data, e.g. data passed to a server handler or created by
+// rtmp.NewStreamData. Flv headers are skipped. Write must not be called concurrently. Once the
+// server closes the connection or reports an error, Write returns the error
+func (c *Client) Write(data *rtmp.StreamData) error {
+	if !c.publishing {
+		return errors.New("stream is not published")
+	}
+	if err := c.readError(); err != nil {
+		return err
+	}
+	msg := rtmp.StreamDataToMessage(c.streamID, data)
+	if msg == nil {
+		return nil
+	}
+	if data.Type == rtmp.FlvScript || data.SequenceHeader {
+		// the meta follows metadata and sequence headers published
+		rtmp.MessageToStreamData(c.meta, msg)
+	}
+	return c.conn.WriteMessage(msg)
+}
+
+// Read returns the next data of the played stream. ErrUnpublished is returned when the stream is
+// unpublished, and statuses of level error end playing
+func (c *Client) Read() (*rtmp.StreamData, error) {
+	if c.meta == nil || c.publishing {
+		return nil, errors.New("stream is not played")
+	}
+	for len(c.pending) == 0 {
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if cmd, ok := msg.(*message.Amf0CommandMessage); ok && cmd.Name == "onStatus" {
+			level, code, _ := status(cmd)
+			if level == "error" {
+				return nil, statusError(cmd)
+			}
+			if code == "NetStream.Play.UnpublishNotify" {
+				return nil, ErrUnpublished
+			}
+			continue
+		}
+		c.pending = rtmp.MessageToStreamData(c.meta, msg)
+	}
+	data := c.pending[0]
+	c.pending = c.pending[1:]
+	return data, nil
+}
+
+// Close stops publishing or playing and closes the connection
+func (c *Client) Close() error {
+	var msgs []message.Message
+	if c.publishing {
+		unpublish := message.NewAmf0CommandMessage("FCUnpublish", c.nextTxID())
+		unpublish.AddOther(c.streamName)
+		msgs = append(msgs, unpublish)
+	}
+	if c.meta != nil {
+		deleteStream := message.NewAmf0CommandMessage("deleteStream", c.nextTxID())
+		deleteStream.AddOther(c.streamID)
+		msgs = append(msgs, deleteStream)
+	}
+	if err := c.conn.WriteMessage(msgs...); err != nil {
+		logging.Logger.Warnf("failed to close stream %v: %v", c.streamName, err)
+	}
+	err := c.conn.Close()
+	if c.readDone != nil {
+		<-c.readDone
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/amf"
+)
+
+func Test_SplitURL(t *testing.T) {
+	cases := []struct {
+		url, host, app, name string
+	}{
+		{"rtmp://localhost/live/test", "localhost:1935", "live", "test"},
+		{"rtmps://example.com/live2/app/key?token=1", "example.com:443", "live2/app", "key?token=1"},
+		{"rtmp://127.0.0.1:1936/live/test/", "127.0.0.1:1936", "live", "test"},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		host, app, name, err := splitURL(u)
+		if err != nil || host != c.host || app != c.app || name != c.name {
+			t.Errorf("unexpected split of %v: %v %v %v %v", c.url, host, app, name, err)
+		}
+	}
+	for _, s := range []string{"http://localhost/live/test", "rtmp://localhost/test"} {
+		u, _ := url.Parse(s)
+		if _, _, _, err := splitURL(u); err == nil {
+			t.Errorf("%v is expected to be invalid", s)
+		}
+	}
+}
+
+func avcSequenceHeader() []byte {
+	sps, _ := hex.DecodeString("67640028acd940780227e540")
+	pps, _ := hex.DecodeString("68ebe3cb22c0")
+	video := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, byte(len(sps))}
+	video = append(video, sps...)
+	video = append(video, 0x01, 0x00, byte(len(pps)))
+	return append(video, pps...)
+}
+
+func Test_PublishAndPlay(t *testing.T) {
+	s := rtmp.NewServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	defer s.Stop()
+	streamURL := "rtmp://" + listener.Addr().String() + "/live/test"
+	setting := &Setting{ReadTimeout: 5 * time.Second}
+
+	pub, err := Dial(streamURL, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	metaData, _ := amf.MarshalAll("onMetaData", amf.ECMAArray{{Name: "videocodecid", Value: 7}})
+	input := []*rtmp.StreamData{
+		rtmp.NewStreamData(rtmp.FlvScript, 0, metaData),
+		rtmp.NewStreamData(rtmp.FlvVideo, 0, avcSequenceHeader()),
+		rtmp.NewStreamData(rtmp.FlvVideo, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA}),
+		rtmp.NewStreamData(rtmp.FlvVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB}),
+	}
+	for _, data := range input {
+		if err := pub.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pub.Meta().VideoCodecString() != "avc1.640028" {
+		t.Errorf("unexpected publishing meta %+v", pub.Meta())
+	}
+
+	player, err := Dial(streamURL, setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+	if err := player.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached headers and GOP are played as the server passes them to handlers
+	header, err := player.Read()
+	if err != nil || header.Type != rtmp.FlvHeader || header.Data[4] != 0x01 {
+		t.Fatalf("unexpected flv header %+v, %v", header, err)
+	}
+	for i, expected := range input {
+		data, err := player.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Type != expected.Type || data.Timestamp != expected.Timestamp || !bytes.Equal(data.Data, expected.Data) ||
+			data.Keyframe != expected.Keyframe || !bytes.Equal(data.Payload, expected.Payload) {
+			t.Errorf("unexpected data %v: %+v", i, data)
+		}
+	}
+	if player.Meta().VideoCodecString() != "avc1.640028" || player.Meta().Width() != 1920 {
+		t.Errorf("unexpected playing meta %+v", player.Meta())
+	}
+
+	live := rtmp.NewStreamData(rtmp.FlvVideo, 80, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xCC})
+	if err := pub.Write(live); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := player.Read(); err != nil || !bytes.Equal(data.Data, live.Data) || data.DTS != 80 {
+		t.Errorf("unexpected live data %+v, %v", data, err)
+	}
+	pub.Close()
+	if _, err := player.Read(); err != ErrUnpublished {
+		t.Errorf("unexpected error %v after unpublishing", err)
+	}
+}
+
+func Test_PublishRejected(t *testing.T) {
+	s := rtmp.NewServer()
+	s.OnPublish(func(meta *rtmp.StreamMeta, query url.Values) error {
+		if query.Get("key") != "secret" {
+			return rtmp.ErrBadName
+		}
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	defer s.Stop()
+
+	pub, err := Dial("rtmp://"+listener.Addr().String()+"/live/test?key=wrong", &Setting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.Publish(); err == nil {
+		t.Error("publishing is expected to be rejected")
+	}
+}
+
+func Test_PublishReadLoop(t *testing.T) {
+	s := rtmp.NewServer()
+	s.ConfigPing(&rtmp.PingSetting{Interval: 20 * time.Millisecond, Timeout: 100 * time.Millisecond})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+
+	pub, err := Dial("rtmp://"+listener.Addr().String()+"/live/test", &Setting{ReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// pings are answered while nothing is written, so the server keeps the publisher
+	time.Sleep(300 * time.Millisecond)
+	if err := pub.Write(rtmp.NewStreamData(rtmp.FlvVideo, 0, avcSequenceHeader())); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hub().Meta("live", "test") == nil {
+		t.Fatal("publisher is expected to be kept")
+	}
+
+	// the error of the closed connection is returned by the next write
+	s.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err = pub.Write(rtmp.NewStreamData(rtmp.FlvVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB})); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("write is expected to fail after the server stops")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,261 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/junli1026/gortmp/logging"
+	"github.com/junli1026/gortmp/message"
+)
+
+// setDataFrame is the AMF0 encoded "@setDataFrame" name preceding metadata of publishers
+var setDataFrame = []byte{0x02, 0x00, 0x0D, '@', 's', 'e', 't', 'D', 'a', 't', 'a', 'F', 'r', 'a', 'm', 'e'}
+
+// ClientConn is the client side of an rtmp connection, it is the transport of package client.
+// It does the handshake, chunks messages and handles protocol control messages, while commands
+// are left to the caller. ReadMessage is not safe for concurrent use, WriteMessage is
+type ClientConn struct {
+	conn         net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	chunkReader  *chunkReader
+	chunkWriter  *chunkWriter
+	readBuf      []byte
+	writeMux     sync.Mutex
+	writeBuf     []byte
+	windowSize   int
+	received     uint32
+}
+
+// NewClientConn creates the client side of a connection to an rtmp server, reads or writes not
+// done within their timeouts fail
+func NewClientConn(conn net.Conn, readTimeout time.Duration, writeTimeout time.Duration) *ClientConn {
+	return &ClientConn{
+		conn:         conn,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+		chunkReader:  newChunkReader(),
+		chunkWriter:  newChunkWriter(),
+		readBuf:      make([]byte, 0),
+		windowSize:   2500000,
+	}
+}
+
+// Handshake sends C0C1, answers S0S1 with C2 and waits for S2
+func (c *ClientConn) Handshake() error {
+	c.conn.SetDeadline(time.Now().Add(c.writeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(generateC0C1()); err != nil {
+		return err
+	}
+	s0s1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.conn, s0s1); err != nil {
+		return err
+	}
+	if s0s1[0] != 3 {
+		logging.Logger.Warnf("unexpected rtmp version %v", s0s1[0])
+	}
+	if _, err := c.conn.Write(generateC2(s0s1[1:])); err != nil {
+		return err
+	}
+	s2 := make([]byte, handshakeSize)
+	_, err := io.ReadFull(c.conn, s2)
+	return err
+}
+
+// ReadMessage returns the next message of the server. Protocol control messages are handled
+// before they are returned, ping requests are answered and acknowledgements are sent
+func (c *ClientConn) ReadMessage() (message.Message, error) {
+	buf := make([]byte, 1024*10)
+	for {
+		raw, consumed, err := c.chunkReader.read(c.readBuf)
+		if err != nil {
+			return nil, err
+		}
+		c.readBuf = c.readBuf[consumed:]
+		c.received += uint32(consumed)
+		if raw != nil {
+			msg, err := message.Deserialize(raw)
+			if err != nil {
+				return nil, err
+			}
+			return msg, c.handle(msg)
+		}
+		if consumed > 0 {
+			continue
+		}
+
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return nil, err
+		}
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		c.readBuf = append(c.readBuf, buf[:n]...)
+	}
+}
+
+func (c *ClientConn) handle(msg message.Message) error {
+	var reply []message.Message
+	switch v := msg.(type) {
+	case *message.SetChunkSizeMessage:
+		c.chunkReader.setChunkSize(v.ChunkSize)
+	case *message.AbortMessage:
+		c.chunkReader.abort(int(v.AbortChunkStreamID))
+	case *message.AckWindowSizeMessage:
+		c.windowSize = v.WindowSize
+	case *message.UserControlMessage:
+		if v.EventType == message.EventPingRequest {
+			reply = append(reply, message.NewPingResponseMessage(v.PingTimestamp))
+		}
+	}
+	if c.received >= uint32(c.windowSize) {
+		reply = append(reply, message.NewAcknowledgementMessage(c.received))
+		c.received = 0
+	}
+	return c.WriteMessage(reply...)
+}
+
+// WriteMessage writes messages to the server, a SetChunkSize message takes effect for the
+// messages following it
+func (c *ClientConn) WriteMessage(msgs ...message.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+
+	var err error
+	data := c.writeBuf[:0]
+	for _, msg := range msgs {
+		if data, err = c.chunkWriter.write(data, msg); err != nil {
+			return err
+		}
+		if v, ok := msg.(*message.SetChunkSizeMessage); ok {
+			c.chunkWriter.setChunkSize(v.ChunkSize)
+		}
+	}
+	c.writeBuf = data
+	if err = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		return err
+	}
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// Close closes the connection
+func (c *ClientConn) Close() error {
+	return c.conn.Close()
+}
+
+// NewStreamMeta creates meta of a stream published or played by a client, it is updated with
+// metadata and sequence headers passing through MessageToStreamData. The query of stream name
+// is split off as for streams of the server
+func NewStreamMeta(app string, url string, streamName string, streamID int) *StreamMeta {
+	name, query := splitQuery(streamName)
+	return &StreamMeta{
+		app:        app,
+		url:        url,
+		streamName: name,
+		query:      query,
+		streamID:   streamID,
+	}
+}
+
+// NewStreamData creates stream data of a FlvScript, FlvVideo or FlvAudio tag body, e.g. a frame
+// of an encoder to publish. The frame view is filled as for data of the server
+func NewStreamData(dataType StreamDataType, timestamp uint32, body []byte) *StreamData {
+	data := &StreamData{
+		Type:      dataType,
+		Timestamp: timestamp,
+		DTS:       int64(timestamp),
+	}
+	switch dataType {
+	case FlvVideo:
+		(&StreamMeta{}).setMediaTag(data, flvTagVideo, body)
+	case FlvAudio:
+		(&StreamMeta{}).setMediaTag(data, flvTagAudio, body)
+	default:
+		data.Data = flvTag(flvTagScript, timestamp, body)
+	}
+	return data
+}
+
+// MessageToStreamData converts a message received by a client into stream data of the stream.
+// Like data of the server, a flv header goes before the first tag, timestamps are normalized to
+// start from 0, and the stream is updated with metadata and sequence headers. Messages other
+// than audio, video, aggregate and onMetaData messages return nothing
+func MessageToStreamData(meta *StreamMeta, msg message.Message) []*StreamData {
+	var data *StreamData
+	switch v := msg.(type) {
+	case *message.AggregateMessage:
+		var result []*StreamData
+		for _, sub := range v.Messages {
+			result = append(result, MessageToStreamData(meta, sub)...)
+		}
+		return result
+	case *message.Amf0DataMessage:
+		name := v.CommandName
+		if name == "@setDataFrame" {
+			name = v.CallbackName
+		}
+		if name != "onMetaData" && name != "onmetadata" {
+			return nil
+		}
+		body := bytes.TrimPrefix(v.Raw, setDataFrame)
+		meta.setMetaData(body)
+		data = &StreamData{
+			Type: FlvScript,
+			Data: flvTag(flvTagScript, 0, body),
+		}
+	case *message.VideoMessage:
+		data = meta.mediaData(FlvVideo, v.Timestamp, v.Raw)
+	case *message.AudioMessage:
+		data = meta.mediaData(FlvAudio, v.Timestamp, v.Raw)
+	default:
+		return nil
+	}
+	if header := meta.flvHeaderData(); header != nil {
+		return []*StreamData{header, data}
+	}
+	return []*StreamData{data}
+}
+
+func (stream *StreamMeta) mediaData(dataType StreamDataType, timestamp uint32, raw []byte) *StreamData {
+	if stream.timestamps == nil {
+		stream.timestamps = newTimestampNormalizer(TimestampSetting{}, 0)
+	}
+	data := &StreamData{
+		Type: dataType,
+	}
+	data.DTS, _ = stream.timestamps.normalize(timestamp, dataType)
+	data.Timestamp = uint32(data.DTS)
+	tagType := byte(flvTagAudio)
+	if dataType == FlvVideo {
+		tagType = flvTagVideo
+	}
+	stream.setMediaTag(data, tagType, raw)
+	return data
+}
+
+// StreamDataToMessage converts stream data into the message a client publishes on the message
+// stream, metadata is sent with @setDataFrame. Flv headers return nil
+func StreamDataToMessage(streamID int, data *StreamData) message.Message {
+	switch data.Type {
+	case FlvScript:
+		body := data.body()
+		if !bytes.HasPrefix(body, setDataFrame) {
+			body = append(append([]byte{}, setDataFrame...), body...)
+		}
+		return message.NewAmf0DataMessage(streamID, data.Timestamp, body)
+	case FlvVideo:
+		return message.NewVideoMessage(streamID, data.Timestamp, data.body())
+	case FlvAudio:
+		return message.NewAudioMessage(streamID, data.Timestamp, data.body())
+	}
+	return nil
+}
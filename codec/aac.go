@@ -0,0 +1,127 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AAC audio object types
+const (
+	AACMain = 1
+	AACLC   = 2
+	AACSSR  = 3
+	AACLTP  = 4
+	AACSBR  = 5  // HE-AAC
+	AACPS   = 29 // HE-AAC v2
+)
+
+var aacSampleRates = [13]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AudioSpecificConfig is the AAC decoder configuration, ISO/IEC 14496-3 1.6.2.1
+type AudioSpecificConfig struct {
+	ObjectType     int // as signaled, 5 or 29 for HE-AAC with explicit SBR signaling
+	SampleRate     int // output sample rate, which is the SBR rate for HE-AAC
+	CoreSampleRate int // sample rate of the AAC core, which is half of SampleRate for HE-AAC
+	Channels       int // 0 if defined by program config element
+	ChannelConfig  int
+}
+
+// ParseAudioSpecificConfig parses AudioSpecificConfig, the payload of AAC sequence header
+func ParseAudioSpecificConfig(data []byte) (*AudioSpecificConfig, error) {
+	r := newBitReader(data)
+	c := &AudioSpecificConfig{}
+	var err error
+	if c.ObjectType, err = readObjectType(r); err != nil {
+		return nil, err
+	}
+	if c.SampleRate, err = readSampleRate(r); err != nil {
+		return nil, err
+	}
+	v, err := r.readBits(4)
+	if err != nil {
+		return nil, err
+	}
+	c.ChannelConfig = int(v)
+	switch {
+	case c.ChannelConfig == 7:
+		c.Channels = 8
+	case c.ChannelConfig < 7:
+		c.Channels = c.ChannelConfig
+	}
+	c.CoreSampleRate = c.SampleRate
+	if c.ObjectType == AACSBR || c.ObjectType == AACPS {
+		if c.SampleRate, err = readSampleRate(r); err != nil {
+			return nil, err
+		}
+	}
+	if c.SampleRate == 0 {
+		return nil, errors.New("codec: invalid AAC sample rate")
+	}
+	return c, nil
+}
+
+func readObjectType(r *bitReader) (int, error) {
+	v, err := r.readBits(5)
+	if err != nil {
+		return 0, err
+	}
+	if v == 31 {
+		ext, err := r.readBits(6)
+		return 32 + int(ext), err
+	}
+	return int(v), nil
+}
+
+func readSampleRate(r *bitReader) (int, error) {
+	index, err := r.readBits(4)
+	if err != nil {
+		return 0, err
+	}
+	if index == 15 {
+		rate, err := r.readBits(24)
+		return int(rate), err
+	}
+	if int(index) >= len(aacSampleRates) {
+		return 0, nil
+	}
+	return aacSampleRates[index], nil
+}
+
+// Codec returns the RFC 6381 codec string, e.g. "mp4a.40.2"
+func (c *AudioSpecificConfig) Codec() string {
+	return fmt.Sprintf("mp4a.40.%d", c.ObjectType)
+}
+
+// ADTSHeader returns the 7 bytes ADTS header of a raw AAC frame of the size, ISO/IEC 13818-7 6.2.
+// HE-AAC is signaled as AAC LC at the core sample rate, which decoders upsample implicitly
+func (c *AudioSpecificConfig) ADTSHeader(size int) ([]byte, error) {
+	objectType := c.ObjectType
+	if objectType == AACSBR || objectType == AACPS {
+		objectType = AACLC
+	}
+	if objectType < AACMain || objectType > AACLTP {
+		return nil, fmt.Errorf("codec: AAC object type %v is not supported by ADTS", c.ObjectType)
+	}
+	index := -1
+	for i, rate := range aacSampleRates {
+		if rate == c.CoreSampleRate {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("codec: AAC sample rate %v is not supported by ADTS", c.CoreSampleRate)
+	}
+	frameLength := size + 7
+	if frameLength > 0x1FFF {
+		return nil, errors.New("codec: AAC frame is too large for ADTS")
+	}
+	profile := objectType - 1
+	return []byte{
+		0xFF, 0xF1, // syncword, MPEG-4, no CRC
+		byte(profile<<6 | index<<2 | c.ChannelConfig>>2&0x01),
+		byte(c.ChannelConfig&0x03<<6 | frameLength>>11),
+		byte(frameLength >> 3),
+		byte(frameLength&0x07<<5 | 0x1F),
+		0xFC, // buffer fullness 0x7FF, one raw data block
+	}, nil
+}
@@ -0,0 +1,52 @@
+package codec
+
+import (
+	"testing"
+)
+
+func Test_AudioSpecificConfig(t *testing.T) {
+	tests := []struct {
+		data       []byte
+		codec      string
+		sampleRate int
+		channels   int
+	}{
+		{[]byte{0x12, 0x10}, "mp4a.40.2", 44100, 2},
+		{[]byte{0x11, 0x90}, "mp4a.40.2", 48000, 2},
+		{[]byte{0x13, 0x88}, "mp4a.40.2", 22050, 1},
+		{[]byte{0x2B, 0x92, 0x08, 0x00}, "mp4a.40.5", 44100, 2}, // HE-AAC 22050 to 44100
+		{[]byte{0x12, 0x38}, "mp4a.40.2", 44100, 8},
+	}
+	for _, test := range tests {
+		c, err := ParseAudioSpecificConfig(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Codec() != test.codec || c.SampleRate != test.sampleRate || c.Channels != test.channels {
+			t.Errorf("unexpected config of %x: %v %+v", test.data, c.Codec(), c)
+		}
+	}
+	if _, err := ParseAudioSpecificConfig([]byte{0x12}); err == nil {
+		t.Error("truncated config is expected to fail")
+	}
+}
+
+func Test_ADTSHeader(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		header []byte
+	}{
+		{[]byte{0x12, 0x10}, []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}},
+		{[]byte{0x2B, 0x92, 0x08, 0x00}, []byte{0xFF, 0xF1, 0x5C, 0x80, 0x02, 0x1F, 0xFC}}, // core 22050
+	}
+	for _, test := range tests {
+		c, err := ParseAudioSpecificConfig(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := c.ADTSHeader(9)
+		if err != nil || string(header) != string(test.header) {
+			t.Errorf("unexpected ADTS header of %x: %x %v", test.data, header, err)
+		}
+	}
+}
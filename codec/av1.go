@@ -0,0 +1,187 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AV1CodecConfig is an AV1CodecConfigurationRecord (av1C)
+type AV1CodecConfig struct {
+	Profile              int
+	Level                int
+	Tier                 int
+	BitDepth             int
+	Monochrome           bool
+	ChromaSubsamplingX   int
+	ChromaSubsamplingY   int
+	ChromaSamplePosition int
+	ConfigOBUs           []byte
+}
+
+// ParseAV1CodecConfig parses AV1CodecConfigurationRecord, the payload of AV1 sequence start
+func ParseAV1CodecConfig(data []byte) (*AV1CodecConfig, error) {
+	if len(data) < 4 {
+		return nil, errShortData
+	}
+	if data[0] != 0x81 {
+		return nil, fmt.Errorf("codec: unsupported AV1CodecConfigurationRecord marker and version 0x%02x", data[0])
+	}
+	c := &AV1CodecConfig{
+		Profile:              int(data[1] >> 5),
+		Level:                int(data[1] & 0x1F),
+		Tier:                 int(data[2] >> 7),
+		BitDepth:             8,
+		Monochrome:           data[2]&0x10 != 0,
+		ChromaSubsamplingX:   int(data[2] >> 3 & 0x01),
+		ChromaSubsamplingY:   int(data[2] >> 2 & 0x01),
+		ChromaSamplePosition: int(data[2] & 0x03),
+		ConfigOBUs:           data[4:],
+	}
+	if data[2]&0x40 != 0 { // high_bitdepth
+		c.BitDepth = 10
+		if data[2]&0x20 != 0 { // twelve_bit
+			c.BitDepth = 12
+		}
+	}
+	return c, nil
+}
+
+// Codec returns the codec string of AV1 codec ISO media file format binding, e.g. "av01.0.08M.08"
+func (c *AV1CodecConfig) Codec() string {
+	tier := "M"
+	if c.Tier == 1 {
+		tier = "H"
+	}
+	return fmt.Sprintf("av01.%d.%02d%s.%02d", c.Profile, c.Level, tier, c.BitDepth)
+}
+
+// AV1SequenceHeader is the information of an AV1 sequence header OBU
+type AV1SequenceHeader struct {
+	Profile int
+	Level   int
+	Tier    int
+	Width   int // max frame size
+	Height  int
+}
+
+const av1OBUSequenceHeader = 1
+
+// ParseAV1SequenceHeader finds and parses the sequence header among OBUs, e.g. configOBUs of
+// AV1CodecConfigurationRecord
+func ParseAV1SequenceHeader(obus []byte) (*AV1SequenceHeader, error) {
+	for len(obus) > 0 {
+		obuType := int(obus[0] >> 3 & 0x0F)
+		hasExtension := obus[0]&0x04 != 0
+		hasSize := obus[0]&0x02 != 0
+		pos := 1
+		if hasExtension {
+			pos++
+		}
+		size := len(obus) - pos
+		if hasSize {
+			v, n, err := readLEB128(obus[pos:])
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+			size = int(v)
+		}
+		if pos+size > len(obus) {
+			return nil, errShortData
+		}
+		if obuType == av1OBUSequenceHeader {
+			return parseAV1SequenceHeader(obus[pos : pos+size])
+		}
+		obus = obus[pos+size:]
+	}
+	return nil, errors.New("codec: no AV1 sequence header")
+}
+
+func readLEB128(data []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(data) {
+			return 0, 0, errShortData
+		}
+		v |= uint64(data[i]&0x7F) << uint(7*i)
+		if data[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("codec: invalid leb128")
+}
+
+// parseAV1SequenceHeader parses sequence_header_obu up to max frame size, AV1 spec 5.5.1
+func parseAV1SequenceHeader(data []byte) (*AV1SequenceHeader, error) {
+	r := newBitReader(data)
+	var err error
+	bits := func(n int) int {
+		var v uint64
+		if err == nil {
+			v, err = r.readBits(n)
+		}
+		return int(v)
+	}
+	uvlc := func() {
+		zeros := 0
+		for err == nil && bits(1) == 0 {
+			zeros++
+		}
+		if zeros < 32 {
+			bits(zeros)
+		}
+	}
+
+	h := &AV1SequenceHeader{}
+	h.Profile = bits(3)
+	bits(1)           // still_picture
+	if bits(1) == 1 { // reduced_still_picture_header
+		h.Level = bits(5)
+	} else {
+		decoderModelInfoPresent := false
+		bufferDelayLength := 0
+		if bits(1) == 1 { // timing_info_present_flag
+			bits(32)          // num_units_in_display_tick
+			bits(32)          // time_scale
+			if bits(1) == 1 { // equal_picture_interval
+				uvlc()
+			}
+			decoderModelInfoPresent = bits(1) == 1
+			if decoderModelInfoPresent {
+				bufferDelayLength = bits(5) + 1
+				bits(32) // num_units_in_decoding_tick
+				bits(5)  // buffer_removal_time_length_minus_1
+				bits(5)  // frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelayPresent := bits(1) == 1
+		operatingPoints := bits(5) + 1
+		for i := 0; i < operatingPoints; i++ {
+			bits(12) // operating_point_idc
+			level := bits(5)
+			tier := 0
+			if level > 7 {
+				tier = bits(1)
+			}
+			if i == 0 {
+				h.Level, h.Tier = level, tier
+			}
+			if decoderModelInfoPresent && bits(1) == 1 {
+				bits(bufferDelayLength) // decoder_buffer_delay
+				bits(bufferDelayLength) // encoder_buffer_delay
+				bits(1)                 // low_delay_mode_flag
+			}
+			if initialDisplayDelayPresent && bits(1) == 1 {
+				bits(4) // initial_display_delay_minus_1
+			}
+		}
+	}
+	widthBits := bits(4) + 1
+	heightBits := bits(4) + 1
+	h.Width = bits(widthBits) + 1
+	h.Height = bits(heightBits) + 1
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,226 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AVCDecoderConfig is an AVCDecoderConfigurationRecord, ISO/IEC 14496-15 5.3.3.1
+type AVCDecoderConfig struct {
+	Profile              byte
+	ProfileCompatibility byte
+	Level                byte
+	NALULengthSize       int
+	SPS                  [][]byte
+	PPS                  [][]byte
+}
+
+// ParseAVCDecoderConfig parses AVCDecoderConfigurationRecord, the payload of AVC sequence header
+func ParseAVCDecoderConfig(data []byte) (*AVCDecoderConfig, error) {
+	if len(data) < 7 {
+		return nil, errShortData
+	}
+	if data[0] != 1 {
+		return nil, fmt.Errorf("codec: unsupported AVCDecoderConfigurationRecord version %v", data[0])
+	}
+	c := &AVCDecoderConfig{
+		Profile:              data[1],
+		ProfileCompatibility: data[2],
+		Level:                data[3],
+		NALULengthSize:       int(data[4]&0x03) + 1,
+	}
+	var err error
+	var pos int
+	if c.SPS, pos, err = readParameterSets(data, 6, int(data[5]&0x1F)); err != nil {
+		return nil, err
+	}
+	if pos >= len(data) {
+		return nil, errShortData
+	}
+	if c.PPS, _, err = readParameterSets(data, pos+1, int(data[pos])); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readParameterSets reads count of 16-bit length prefixed NAL units starting at pos
+func readParameterSets(data []byte, pos int, count int) ([][]byte, int, error) {
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+2 > len(data) {
+			return nil, pos, errShortData
+		}
+		n := int(data[pos])<<8 | int(data[pos+1])
+		pos += 2
+		if pos+n > len(data) {
+			return nil, pos, errShortData
+		}
+		sets = append(sets, data[pos:pos+n])
+		pos += n
+	}
+	return sets, pos, nil
+}
+
+// Codec returns the RFC 6381 codec string, e.g. "avc1.64001F"
+func (c *AVCDecoderConfig) Codec() string {
+	return fmt.Sprintf("avc1.%02X%02X%02X", c.Profile, c.ProfileCompatibility, c.Level)
+}
+
+// AVCSPS is the information of an H.264 sequence parameter set
+type AVCSPS struct {
+	Profile        int
+	Constraints    int
+	Level          int
+	ID             int
+	ChromaFormat   int // 0 monochrome, 1 4:2:0, 2 4:2:2, 3 4:4:4
+	BitDepthLuma   int
+	BitDepthChroma int
+	Width          int // after cropping
+	Height         int
+}
+
+// profiles having chroma_format_idc in SPS
+var avcHighProfiles = map[int]bool{
+	100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true,
+	118: true, 128: true, 138: true, 139: true, 134: true, 135: true,
+}
+
+// ParseAVCSPS parses an H.264 sequence parameter set NAL unit, ITU-T H.264 7.3.2.1.1
+func ParseAVCSPS(nalu []byte) (*AVCSPS, error) {
+	if len(nalu) < 4 {
+		return nil, errShortData
+	}
+	if nalu[0]&0x1F != 7 {
+		return nil, fmt.Errorf("codec: NAL unit type %v is not SPS", nalu[0]&0x1F)
+	}
+	sps := &AVCSPS{
+		Profile:        int(nalu[1]),
+		Constraints:    int(nalu[2]),
+		Level:          int(nalu[3]),
+		ChromaFormat:   1,
+		BitDepthLuma:   8,
+		BitDepthChroma: 8,
+	}
+	r := newBitReader(unescapeRBSP(nalu[4:]))
+	var err error
+	// ue reads an Exp-Golomb value keeping the first error
+	ue := func() int {
+		var v uint64
+		if err == nil {
+			v, err = r.readUE()
+		}
+		return int(v)
+	}
+	bit := func() bool {
+		var v bool
+		if err == nil {
+			v, err = r.readBit()
+		}
+		return v
+	}
+
+	sps.ID = ue()
+	separateColourPlane := false
+	if avcHighProfiles[sps.Profile] {
+		sps.ChromaFormat = ue()
+		if sps.ChromaFormat == 3 {
+			separateColourPlane = bit()
+		}
+		sps.BitDepthLuma = ue() + 8
+		sps.BitDepthChroma = ue() + 8
+		bit() // qpprime_y_zero_transform_bypass_flag
+		if bit() {
+			n := 8
+			if sps.ChromaFormat == 3 {
+				n = 12
+			}
+			for i := 0; i < n && err == nil; i++ {
+				if !bit() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				err = skipScalingList(r, size)
+			}
+		}
+	}
+	ue() // log2_max_frame_num_minus4
+	switch ue() {
+	case 0:
+		ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		bit() // delta_pic_order_always_zero_flag
+		if err == nil {
+			_, err = r.readSE() // offset_for_non_ref_pic
+		}
+		if err == nil {
+			_, err = r.readSE() // offset_for_top_to_bottom_field
+		}
+		n := ue()
+		for i := 0; i < n && err == nil; i++ {
+			_, err = r.readSE()
+		}
+	}
+	ue()  // max_num_ref_frames
+	bit() // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := ue() + 1
+	heightInMapUnits := ue() + 1
+	frameMbsOnly := bit()
+	if !frameMbsOnly {
+		bit() // mb_adaptive_frame_field_flag
+	}
+	bit() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if bit() {
+		cropLeft, cropRight, cropTop, cropBottom = ue(), ue(), ue(), ue()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	frameHeightFactor := 2
+	if frameMbsOnly {
+		frameHeightFactor = 1
+	}
+	cropUnitX, cropUnitY := 1, frameHeightFactor
+	if !separateColourPlane && sps.ChromaFormat != 0 {
+		subWidth, subHeight := chromaSubsampling(sps.ChromaFormat)
+		cropUnitX, cropUnitY = subWidth, subHeight*frameHeightFactor
+	}
+	sps.Width = widthInMbs*16 - cropUnitX*(cropLeft+cropRight)
+	sps.Height = frameHeightFactor*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+	if sps.Width <= 0 || sps.Height <= 0 {
+		return nil, errors.New("codec: invalid SPS cropping")
+	}
+	return sps, nil
+}
+
+// chromaSubsampling returns SubWidthC and SubHeightC of chroma format
+func chromaSubsampling(chromaFormat int) (int, int) {
+	switch chromaFormat {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+func skipScalingList(r *bitReader, size int) error {
+	last, next := int64(8), int64(8)
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func Test_AVCDecoderConfig(t *testing.T) {
+	sps := mustHex("67640028acd940780227e540")
+	pps := mustHex("68ebe3cb22c0")
+	record := []byte{0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, byte(len(sps))}
+	record = append(record, sps...)
+	record = append(record, 0x01, 0x00, byte(len(pps)))
+	record = append(record, pps...)
+
+	c, err := ParseAVCDecoderConfig(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Codec() != "avc1.640028" || c.NALULengthSize != 4 || len(c.SPS) != 1 || len(c.PPS) != 1 {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	info, err := ParseAVCSPS(c.SPS[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Profile != 100 || info.Level != 40 || info.ChromaFormat != 1 || info.BitDepthLuma != 8 ||
+		info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("unexpected sps %+v", info)
+	}
+
+	if _, err = ParseAVCDecoderConfig(record[:len(record)-1]); err == nil {
+		t.Error("truncated record is expected to fail")
+	}
+}
+
+func Test_AVCBaselineSPS(t *testing.T) {
+	info, err := ParseAVCSPS(mustHex("6742c01ff402802dc8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Profile != 66 || info.Level != 31 || info.Width != 1280 || info.Height != 720 {
+		t.Errorf("unexpected sps %+v", info)
+	}
+}
+
+func Test_unescapeRBSP(t *testing.T) {
+	rbsp := unescapeRBSP([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03})
+	if hex.EncodeToString(rbsp) != "0000010000" {
+		t.Errorf("unexpected rbsp %x", rbsp)
+	}
+}
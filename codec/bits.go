@@ -0,0 +1,94 @@
+package codec
+
+import "errors"
+
+var errShortData = errors.New("codec: data too short")
+
+// bitReader reads bits of a big endian bitstream, MSB first
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) left() int {
+	return len(r.data)*8 - r.pos
+}
+
+// readBits reads n bits, n is at most 64
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if n > r.left() {
+		return 0, errShortData
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		b := r.data[r.pos/8] >> uint(7-r.pos%8) & 0x01
+		v = v<<1 | uint64(b)
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	v, err := r.readBits(1)
+	return v == 1, err
+}
+
+func (r *bitReader) skip(n int) error {
+	if n > r.left() {
+		return errShortData
+	}
+	r.pos += n
+	return nil
+}
+
+// readUE reads an unsigned Exp-Golomb code
+func (r *bitReader) readUE() (uint64, error) {
+	zeros := 0
+	for {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errors.New("codec: invalid exp-golomb code")
+		}
+	}
+	v, err := r.readBits(zeros)
+	return 1<<uint(zeros) - 1 + v, err
+}
+
+// readSE reads a signed Exp-Golomb code
+func (r *bitReader) readSE() (int64, error) {
+	v, err := r.readUE()
+	if v%2 == 1 {
+		return int64(v+1) / 2, err
+	}
+	return -int64(v / 2), err
+}
+
+// unescapeRBSP removes emulation prevention bytes, 0x000003 becomes 0x0000
+func unescapeRBSP(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return rbsp
+}
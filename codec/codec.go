@@ -0,0 +1,242 @@
+// Package codec parses flv audio and video tag headers and the codec configuration carried in
+// sequence headers: AVCDecoderConfigurationRecord, HEVCDecoderConfigurationRecord and AAC
+// AudioSpecificConfig.
+package codec
+
+// Video codec ids of flv video tags
+const (
+	VideoH263     = 2
+	VideoScreen   = 3
+	VideoVP6      = 4
+	VideoVP6Alpha = 5
+	VideoScreen2  = 6
+	VideoAVC      = 7
+	VideoHEVC     = 12 // not in flv spec, used by encoders before enhanced rtmp
+)
+
+// Sound formats of flv audio tags
+const (
+	AudioPCM           = 0
+	AudioADPCM         = 1
+	AudioMP3           = 2
+	AudioPCMLE         = 3
+	AudioNellymoser16k = 4
+	AudioNellymoser8k  = 5
+	AudioNellymoser    = 6
+	AudioG711A         = 7
+	AudioG711U         = 8
+	AudioAAC           = 10
+	AudioSpeex         = 11
+	AudioMP38k         = 14
+)
+
+// Frame types of flv video tags
+const (
+	FrameKey        = 1
+	FrameInter      = 2
+	FrameDisposable = 3
+	FrameGenerated  = 4
+	FrameCommand    = 5
+)
+
+// Packet types of AVC, HEVC and AAC tags
+const (
+	PacketSequenceHeader = 0
+	PacketNALU           = 1 // raw AAC frame for audio
+	PacketEndOfSequence  = 2
+)
+
+var videoCodecNames = map[int]string{
+	VideoH263:     "h263",
+	VideoScreen:   "screen",
+	VideoVP6:      "vp6",
+	VideoVP6Alpha: "vp6a",
+	VideoScreen2:  "screen2",
+	VideoAVC:      "avc1",
+	VideoHEVC:     "hvc1",
+}
+
+var audioCodecNames = map[int]string{
+	AudioPCM:           "pcm",
+	AudioADPCM:         "adpcm",
+	AudioMP3:           "mp3",
+	AudioPCMLE:         "pcm",
+	AudioNellymoser16k: "nellymoser",
+	AudioNellymoser8k:  "nellymoser",
+	AudioNellymoser:    "nellymoser",
+	AudioG711A:         "alaw",
+	AudioG711U:         "ulaw",
+	AudioAAC:           "mp4a",
+	AudioSpeex:         "speex",
+	AudioMP38k:         "mp3",
+}
+
+// VideoCodecName returns name of flv video codec id, e.g. "avc1" for 7, empty if unknown
+func VideoCodecName(id int) string {
+	return videoCodecNames[id]
+}
+
+// AudioCodecName returns name of flv sound format, e.g. "mp4a" for 10, empty if unknown
+func AudioCodecName(id int) string {
+	return audioCodecNames[id]
+}
+
+// VideoTagHeader is the header of flv video tag data, legacy or enhanced rtmp
+type VideoTagHeader struct {
+	FrameType       int
+	CodecID         int    // legacy tags only
+	ExHeader        bool   // enhanced rtmp tag with FourCC
+	FourCC          string // FourCC of enhanced rtmp, or of legacy AVC and HEVC
+	PacketType      int    // AVC, HEVC and enhanced rtmp only
+	CompositionTime int32  // AVC and HEVC only, pts - dts in milliseconds
+	Tracks          []Track
+}
+
+// ParseVideoTagHeader parses header of flv video tag data, it returns the header and the payload
+// following it, e.g. AVCDecoderConfigurationRecord of sequence header or NAL units. Header and payload
+// of multitrack enhanced rtmp tags are of the first track
+func ParseVideoTagHeader(data []byte) (*VideoTagHeader, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errShortData
+	}
+	if data[0]&0x80 != 0 {
+		return parseExVideoTagHeader(data)
+	}
+	h := &VideoTagHeader{
+		FrameType: int(data[0] >> 4),
+		CodecID:   int(data[0] & 0x0F),
+	}
+	if h.CodecID != VideoAVC && h.CodecID != VideoHEVC {
+		h.FourCC = VideoCodecName(h.CodecID)
+		return h, data[1:], nil
+	}
+	h.FourCC = FourCCAVC
+	if h.CodecID == VideoHEVC {
+		h.FourCC = FourCCHEVC
+	}
+	if len(data) < 5 {
+		return nil, nil, errShortData
+	}
+	h.PacketType = int(data[1])
+	h.CompositionTime = readSI24(data[2:])
+	return h, data[5:], nil
+}
+
+func readSI24(b []byte) int32 {
+	v := int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	return v << 8 >> 8 // sign extend
+}
+
+// IsKeyframe returns whether the tag is a keyframe
+func (h *VideoTagHeader) IsKeyframe() bool {
+	return h.FrameType == FrameKey
+}
+
+// IsSequenceHeader returns whether the tag carries decoder configuration record
+func (h *VideoTagHeader) IsSequenceHeader() bool {
+	if h.ExHeader {
+		return h.PacketType == ExPacketSequenceStart || h.PacketType == ExPacketMPEG2TSSequenceStart
+	}
+	return (h.CodecID == VideoAVC || h.CodecID == VideoHEVC) && h.PacketType == PacketSequenceHeader
+}
+
+// AudioTagHeader is the header of flv audio tag data, legacy or enhanced rtmp
+type AudioTagHeader struct {
+	SoundFormat int
+	SampleRate  int // in Hz, AAC is always 44100 here, the actual rate is in AudioSpecificConfig
+	SampleSize  int // in bits
+	Channels    int
+	ExHeader    bool   // enhanced rtmp tag with FourCC, sample rate, size and channels are not set
+	FourCC      string // FourCC of enhanced rtmp, or name of legacy sound format
+	PacketType  int    // AAC and enhanced rtmp only
+	Tracks      []Track
+}
+
+var soundRates = [4]int{5512, 11025, 22050, 44100}
+
+// ParseAudioTagHeader parses header of flv audio tag data, it returns the header and the payload
+// following it, e.g. AudioSpecificConfig of AAC sequence header or raw AAC frame. Header and payload
+// of multitrack enhanced rtmp tags are of the first track
+func ParseAudioTagHeader(data []byte) (*AudioTagHeader, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errShortData
+	}
+	if data[0]>>4 == AudioExHeader {
+		return parseExAudioTagHeader(data)
+	}
+	h := &AudioTagHeader{
+		SoundFormat: int(data[0] >> 4),
+		SampleRate:  soundRates[data[0]>>2&0x03],
+		SampleSize:  8,
+		Channels:    1,
+	}
+	h.FourCC = AudioCodecName(h.SoundFormat)
+	if data[0]&0x02 != 0 {
+		h.SampleSize = 16
+	}
+	if data[0]&0x01 != 0 {
+		h.Channels = 2
+	}
+	switch h.SoundFormat {
+	case AudioNellymoser16k:
+		h.SampleRate = 16000
+	case AudioNellymoser8k, AudioG711A, AudioG711U, AudioMP38k:
+		h.SampleRate = 8000
+	case AudioSpeex:
+		h.SampleRate = 16000
+	}
+	if h.SoundFormat != AudioAAC {
+		return h, data[1:], nil
+	}
+	if len(data) < 2 {
+		return nil, nil, errShortData
+	}
+	h.PacketType = int(data[1])
+	return h, data[2:], nil
+}
+
+// IsSequenceHeader returns whether the tag carries AudioSpecificConfig, or codec configuration of
+// enhanced rtmp
+func (h *AudioTagHeader) IsSequenceHeader() bool {
+	if h.ExHeader {
+		return h.PacketType == ExPacketSequenceStart
+	}
+	return h.SoundFormat == AudioAAC && h.PacketType == PacketSequenceHeader
+}
+
+// IsVideoSequenceHeader returns whether flv video tag data is a sequence header, it only looks at
+// the bytes needed to tell
+func IsVideoSequenceHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	if data[0]&0x80 == 0 {
+		codecID := data[0] & 0x0F
+		return (codecID == VideoAVC || codecID == VideoHEVC) && data[1] == PacketSequenceHeader
+	}
+	h, _, err := ParseVideoTagHeader(data)
+	return err == nil && h.IsSequenceHeader()
+}
+
+// IsVideoKeyframe returns whether flv video tag data is a keyframe
+func IsVideoKeyframe(data []byte) bool {
+	if len(data) < 1 {
+		return false
+	}
+	if data[0]&0x80 == 0 {
+		return data[0]>>4 == FrameKey
+	}
+	return data[0]>>4&0x07 == FrameKey
+}
+
+// IsAudioSequenceHeader returns whether flv audio tag data is a sequence header
+func IsAudioSequenceHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	if data[0]>>4 != AudioExHeader {
+		return data[0]>>4 == AudioAAC && data[1] == PacketSequenceHeader
+	}
+	h, _, err := ParseAudioTagHeader(data)
+	return err == nil && h.IsSequenceHeader()
+}
@@ -0,0 +1,27 @@
+package codec
+
+import (
+	"testing"
+)
+
+func Test_TagHeaders(t *testing.T) {
+	v, payload, err := ParseVideoTagHeader([]byte{0x17, 0x01, 0xFF, 0xFF, 0xD8, 0xAA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.IsKeyframe() || v.IsSequenceHeader() || v.CodecID != VideoAVC || v.CompositionTime != -40 || len(payload) != 1 {
+		t.Errorf("unexpected video header %+v", v)
+	}
+
+	a, payload, err := ParseAudioTagHeader([]byte{0xAF, 0x00, 0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsSequenceHeader() || a.SampleSize != 16 || a.Channels != 2 || len(payload) != 2 {
+		t.Errorf("unexpected audio header %+v", a)
+	}
+	a, _, _ = ParseAudioTagHeader([]byte{0x2E})
+	if a.SoundFormat != AudioMP3 || a.SampleRate != 44100 || a.Channels != 1 || AudioCodecName(a.SoundFormat) != "mp3" {
+		t.Errorf("unexpected audio header %+v", a)
+	}
+}
@@ -0,0 +1,212 @@
+package codec
+
+// FourCC of enhanced rtmp codecs
+const (
+	FourCCAVC  = "avc1"
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP8  = "vp08"
+	FourCCVP9  = "vp09"
+	FourCCAAC  = "mp4a"
+	FourCCOpus = "Opus"
+	FourCCFLAC = "fLaC"
+	FourCCAC3  = "ac-3"
+	FourCCEAC3 = "ec-3"
+	FourCCMP3  = ".mp3"
+)
+
+// VideoFourCCs are video FourCC of enhanced rtmp
+var VideoFourCCs = []string{FourCCAVC, FourCCHEVC, FourCCAV1, FourCCVP8, FourCCVP9}
+
+// AudioFourCCs are audio FourCC of enhanced rtmp
+var AudioFourCCs = []string{FourCCAAC, FourCCOpus, FourCCFLAC, FourCCAC3, FourCCEAC3, FourCCMP3}
+
+// AudioExHeader is the sound format of enhanced rtmp audio tags
+const AudioExHeader = 9
+
+// Packet types of enhanced rtmp tags
+const (
+	ExPacketSequenceStart        = 0
+	ExPacketCodedFrames          = 1
+	ExPacketSequenceEnd          = 2
+	ExPacketCodedFramesX         = 3 // video only, composition time is 0
+	ExPacketMetadata             = 4 // video only, colorInfo
+	ExPacketMultichannelConfig   = 4 // audio only
+	ExPacketMPEG2TSSequenceStart = 5 // video only
+	ExPacketAudioMultitrack      = 5
+	ExPacketVideoMultitrack      = 6
+	ExPacketModEx                = 7
+)
+
+// Multitrack types of enhanced rtmp tags
+const (
+	MultitrackOneTrack             = 0
+	MultitrackManyTracks           = 1
+	MultitrackManyTracksManyCodecs = 2
+)
+
+// Track is a track of enhanced rtmp multitrack tag
+type Track struct {
+	ID              int
+	FourCC          string
+	CompositionTime int32 // AVC and HEVC coded frames only
+	Data            []byte
+}
+
+func parseExVideoTagHeader(data []byte) (*VideoTagHeader, []byte, error) {
+	h := &VideoTagHeader{
+		ExHeader:   true,
+		FrameType:  int(data[0] >> 4 & 0x07),
+		PacketType: int(data[0] & 0x0F),
+	}
+	packetType, rest, err := skipModEx(h.PacketType, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	h.PacketType = packetType
+
+	if h.FrameType == FrameCommand && h.PacketType != ExPacketMetadata {
+		// video command frame has no FourCC but a command byte
+		return h, rest, nil
+	}
+
+	multitrack := h.PacketType == ExPacketVideoMultitrack
+	if multitrack {
+		if h.PacketType, h.Tracks, err = parseTracks(rest); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if len(rest) < 4 {
+			return nil, nil, errShortData
+		}
+		h.Tracks = []Track{{FourCC: string(rest[:4]), Data: rest[4:]}}
+	}
+
+	// composition time of AVC and HEVC coded frames
+	if h.PacketType == ExPacketCodedFrames {
+		for i := range h.Tracks {
+			t := &h.Tracks[i]
+			if t.FourCC != FourCCAVC && t.FourCC != FourCCHEVC {
+				continue
+			}
+			if len(t.Data) < 3 {
+				return nil, nil, errShortData
+			}
+			t.CompositionTime = readSI24(t.Data)
+			t.Data = t.Data[3:]
+		}
+	}
+	if len(h.Tracks) == 0 {
+		return nil, nil, errShortData
+	}
+	h.FourCC = h.Tracks[0].FourCC
+	h.CompositionTime = h.Tracks[0].CompositionTime
+	payload := h.Tracks[0].Data
+	if !multitrack {
+		h.Tracks = nil // only multitrack tags report tracks
+	}
+	return h, payload, nil
+}
+
+func parseExAudioTagHeader(data []byte) (*AudioTagHeader, []byte, error) {
+	h := &AudioTagHeader{
+		SoundFormat: AudioExHeader,
+		ExHeader:    true,
+		PacketType:  int(data[0] & 0x0F),
+	}
+	packetType, rest, err := skipModEx(h.PacketType, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	h.PacketType = packetType
+
+	if h.PacketType == ExPacketAudioMultitrack {
+		if h.PacketType, h.Tracks, err = parseTracks(rest); err != nil {
+			return nil, nil, err
+		}
+		if len(h.Tracks) == 0 {
+			return nil, nil, errShortData
+		}
+		h.FourCC = h.Tracks[0].FourCC
+		return h, h.Tracks[0].Data, nil
+	}
+	if len(rest) < 4 {
+		return nil, nil, errShortData
+	}
+	h.FourCC = string(rest[:4])
+	return h, rest[4:], nil
+}
+
+// skipModEx skips ModEx modifiers, it returns the packet type following them
+func skipModEx(packetType int, data []byte) (int, []byte, error) {
+	for packetType == ExPacketModEx {
+		if len(data) < 1 {
+			return 0, nil, errShortData
+		}
+		size := int(data[0]) + 1
+		data = data[1:]
+		if size == 256 {
+			if len(data) < 2 {
+				return 0, nil, errShortData
+			}
+			size = (int(data[0])<<8 | int(data[1])) + 1
+			data = data[2:]
+		}
+		if len(data) < size+1 {
+			return 0, nil, errShortData
+		}
+		packetType = int(data[size] & 0x0F)
+		data = data[size+1:]
+	}
+	return packetType, data, nil
+}
+
+// parseTracks parses body of multitrack tag, it returns packet type of the tracks
+func parseTracks(data []byte) (int, []Track, error) {
+	if len(data) < 1 {
+		return 0, nil, errShortData
+	}
+	multitrackType := int(data[0] >> 4)
+	packetType := int(data[0] & 0x0F)
+	data = data[1:]
+
+	fourCC := ""
+	if multitrackType != MultitrackManyTracksManyCodecs {
+		if len(data) < 4 {
+			return 0, nil, errShortData
+		}
+		fourCC = string(data[:4])
+		data = data[4:]
+	}
+
+	tracks := make([]Track, 0)
+	for len(data) > 0 {
+		if multitrackType == MultitrackManyTracksManyCodecs {
+			if len(data) < 4 {
+				return 0, nil, errShortData
+			}
+			fourCC = string(data[:4])
+			data = data[4:]
+		}
+		if len(data) < 1 {
+			return 0, nil, errShortData
+		}
+		t := Track{ID: int(data[0]), FourCC: fourCC}
+		data = data[1:]
+		size := len(data)
+		if multitrackType != MultitrackOneTrack {
+			if len(data) < 3 {
+				return 0, nil, errShortData
+			}
+			size = int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+			data = data[3:]
+			if size > len(data) {
+				return 0, nil, errShortData
+			}
+		}
+		t.Data = data[:size]
+		data = data[size:]
+		tracks = append(tracks, t)
+	}
+	return packetType, tracks, nil
+}
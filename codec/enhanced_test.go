@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_ExVideoTagHeader(t *testing.T) {
+	// sequence start
+	h, payload, err := ParseVideoTagHeader(append([]byte{0x90, 'h', 'v', 'c', '1'}, 0x01, 0x02))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.ExHeader || !h.IsKeyframe() || !h.IsSequenceHeader() || h.FourCC != FourCCHEVC || len(payload) != 2 {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	// coded frames with composition time
+	h, payload, err = ParseVideoTagHeader([]byte{0xA1, 'a', 'v', 'c', '1', 0x00, 0x00, 0x28, 0xAA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.IsKeyframe() || h.IsSequenceHeader() || h.CompositionTime != 40 || !bytes.Equal(payload, []byte{0xAA}) {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	// coded frames without composition time, after ModEx of 3 bytes
+	h, payload, err = ParseVideoTagHeader([]byte{0x97, 0x02, 0x01, 0x02, 0x03, 0x03, 'h', 'v', 'c', '1', 0xBB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.PacketType != ExPacketCodedFramesX || h.FourCC != FourCCHEVC || !bytes.Equal(payload, []byte{0xBB}) {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	// many tracks of one codec
+	data := []byte{0x96, MultitrackManyTracks<<4 | ExPacketCodedFramesX, 'a', 'v', '0', '1',
+		0x00, 0x00, 0x00, 0x02, 0x01, 0x02,
+		0x01, 0x00, 0x00, 0x01, 0x03,
+	}
+	h, payload, err = ParseVideoTagHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.FourCC != FourCCAV1 || len(h.Tracks) != 2 || h.Tracks[1].ID != 1 || !bytes.Equal(h.Tracks[1].Data, []byte{0x03}) ||
+		!bytes.Equal(payload, []byte{0x01, 0x02}) {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if !IsVideoKeyframe(data) || IsVideoSequenceHeader(data) {
+		t.Fail()
+	}
+
+	if _, _, err = ParseVideoTagHeader([]byte{0x90, 'h', 'v'}); err == nil {
+		t.Error("truncated FourCC is expected to fail")
+	}
+}
+
+func Test_ExAudioTagHeader(t *testing.T) {
+	opusHead := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 0x01, 0x02, 0x38, 0x01, 0x80, 0xBB, 0x00, 0x00, 0x00, 0x00, 0x00}
+	data := append([]byte{0x90, 'O', 'p', 'u', 's'}, opusHead...)
+	h, payload, err := ParseAudioTagHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.ExHeader || !h.IsSequenceHeader() || h.FourCC != FourCCOpus || !IsAudioSequenceHeader(data) {
+		t.Errorf("unexpected header %+v", h)
+	}
+	head, err := ParseOpusHead(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Channels != 2 || head.PreSkip != 312 || head.InputSampleRate != 48000 {
+		t.Errorf("unexpected OpusHead %+v", head)
+	}
+}
+
+func Test_AV1CodecConfig(t *testing.T) {
+	record := []byte{0x81, 0x08, 0x0C, 0x00, 0x0A, 0x08}
+	record = append(record, mustHex("00000042abbfc378")...)
+	c, err := ParseAV1CodecConfig(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Codec() != "av01.0.08M.08" || c.ChromaSubsamplingX != 1 || c.ChromaSubsamplingY != 1 {
+		t.Errorf("unexpected config %v %+v", c.Codec(), c)
+	}
+	seq, err := ParseAV1SequenceHeader(c.ConfigOBUs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq.Level != 8 || seq.Width != 1920 || seq.Height != 1080 {
+		t.Errorf("unexpected sequence header %+v", seq)
+	}
+}
+
+func Test_VPCodecConfig(t *testing.T) {
+	c, err := ParseVPCodecConfig([]byte{0x01, 0x00, 0x00, 0x00, 0x00, 31, 0x82, 0x01, 0x01, 0x01, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Codec(FourCCVP9) != "vp09.00.31.08" || c.ChromaSubsampling != 1 {
+		t.Errorf("unexpected config %+v", c)
+	}
+}
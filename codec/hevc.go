@@ -0,0 +1,211 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HEVC NAL unit types of parameter sets
+const (
+	HEVCNALVPS = 32
+	HEVCNALSPS = 33
+	HEVCNALPPS = 34
+)
+
+// HEVCDecoderConfig is an HEVCDecoderConfigurationRecord, ISO/IEC 14496-15 8.3.3.1
+type HEVCDecoderConfig struct {
+	ProfileSpace         int
+	Tier                 int
+	Profile              int
+	ProfileCompatibility uint32
+	ConstraintIndicator  uint64 // 48 bits
+	Level                int
+	ChromaFormat         int
+	BitDepthLuma         int
+	BitDepthChroma       int
+	NALULengthSize       int
+	VPS                  [][]byte
+	SPS                  [][]byte
+	PPS                  [][]byte
+}
+
+// ParseHEVCDecoderConfig parses HEVCDecoderConfigurationRecord, the payload of HEVC sequence header
+func ParseHEVCDecoderConfig(data []byte) (*HEVCDecoderConfig, error) {
+	if len(data) < 23 {
+		return nil, errShortData
+	}
+	if data[0] != 1 {
+		return nil, fmt.Errorf("codec: unsupported HEVCDecoderConfigurationRecord version %v", data[0])
+	}
+	c := &HEVCDecoderConfig{
+		ProfileSpace:         int(data[1] >> 6),
+		Tier:                 int(data[1] >> 5 & 0x01),
+		Profile:              int(data[1] & 0x1F),
+		ProfileCompatibility: uint32(data[2])<<24 | uint32(data[3])<<16 | uint32(data[4])<<8 | uint32(data[5]),
+		Level:                int(data[12]),
+		ChromaFormat:         int(data[16] & 0x03),
+		BitDepthLuma:         int(data[17]&0x07) + 8,
+		BitDepthChroma:       int(data[18]&0x07) + 8,
+		NALULengthSize:       int(data[21]&0x03) + 1,
+	}
+	for i := 6; i < 12; i++ {
+		c.ConstraintIndicator = c.ConstraintIndicator<<8 | uint64(data[i])
+	}
+
+	pos := 23
+	for i := 0; i < int(data[22]); i++ {
+		if pos+3 > len(data) {
+			return nil, errShortData
+		}
+		nalType := int(data[pos] & 0x3F)
+		count := int(data[pos+1])<<8 | int(data[pos+2])
+		sets, next, err := readParameterSets(data, pos+3, count)
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+		switch nalType {
+		case HEVCNALVPS:
+			c.VPS = append(c.VPS, sets...)
+		case HEVCNALSPS:
+			c.SPS = append(c.SPS, sets...)
+		case HEVCNALPPS:
+			c.PPS = append(c.PPS, sets...)
+		}
+	}
+	return c, nil
+}
+
+// Codec returns the codec string of ISO/IEC 14496-15 Annex E, e.g. "hvc1.1.6.L93.B0"
+func (c *HEVCDecoderConfig) Codec() string {
+	var b strings.Builder
+	b.WriteString("hvc1.")
+	if c.ProfileSpace > 0 {
+		b.WriteByte(byte('A' + c.ProfileSpace - 1))
+	}
+	fmt.Fprintf(&b, "%d.", c.Profile)
+
+	// compatibility flags in reverse bit order
+	var compat uint32
+	for i := uint(0); i < 32; i++ {
+		compat |= (c.ProfileCompatibility >> i & 0x01) << (31 - i)
+	}
+	fmt.Fprintf(&b, "%X.", compat)
+
+	if c.Tier == 0 {
+		b.WriteByte('L')
+	} else {
+		b.WriteByte('H')
+	}
+	fmt.Fprintf(&b, "%d", c.Level)
+
+	// constraint bytes, trailing zero bytes omitted
+	constraints := make([]byte, 6)
+	for i := range constraints {
+		constraints[i] = byte(c.ConstraintIndicator >> uint(40-8*i))
+	}
+	n := len(constraints)
+	for n > 0 && constraints[n-1] == 0 {
+		n--
+	}
+	for _, v := range constraints[:n] {
+		fmt.Fprintf(&b, ".%X", v)
+	}
+	return b.String()
+}
+
+// HEVCSPS is the information of an H.265 sequence parameter set
+type HEVCSPS struct {
+	ID             int
+	ChromaFormat   int
+	BitDepthLuma   int
+	BitDepthChroma int
+	Width          int // after conformance window cropping
+	Height         int
+}
+
+// ParseHEVCSPS parses an H.265 sequence parameter set NAL unit, ITU-T H.265 7.3.2.2.1
+func ParseHEVCSPS(nalu []byte) (*HEVCSPS, error) {
+	if len(nalu) < 3 {
+		return nil, errShortData
+	}
+	if int(nalu[0]>>1&0x3F) != HEVCNALSPS {
+		return nil, fmt.Errorf("codec: NAL unit type %v is not SPS", nalu[0]>>1&0x3F)
+	}
+	r := newBitReader(unescapeRBSP(nalu[2:]))
+	var err error
+	bits := func(n int) int {
+		var v uint64
+		if err == nil {
+			v, err = r.readBits(n)
+		}
+		return int(v)
+	}
+	skip := func(n int) {
+		if err == nil {
+			err = r.skip(n)
+		}
+	}
+	ue := func() int {
+		var v uint64
+		if err == nil {
+			v, err = r.readUE()
+		}
+		return int(v)
+	}
+
+	skip(4) // sps_video_parameter_set_id
+	maxSubLayersMinus1 := bits(3)
+	skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level
+	skip(96) // general profile, tier and level
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = bits(1) == 1
+		levelPresent[i] = bits(1) == 1
+	}
+	if maxSubLayersMinus1 > 0 {
+		skip(2 * (8 - maxSubLayersMinus1)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			skip(88)
+		}
+		if levelPresent[i] {
+			skip(8)
+		}
+	}
+
+	sps := &HEVCSPS{}
+	sps.ID = ue()
+	sps.ChromaFormat = ue()
+	separateColourPlane := false
+	if sps.ChromaFormat == 3 {
+		separateColourPlane = bits(1) == 1
+	}
+	width := ue()
+	height := ue()
+	var left, right, top, bottom int
+	if bits(1) == 1 {
+		left, right, top, bottom = ue(), ue(), ue(), ue()
+	}
+	sps.BitDepthLuma = ue() + 8
+	sps.BitDepthChroma = ue() + 8
+	if err != nil {
+		return nil, err
+	}
+
+	subWidth, subHeight := 1, 1
+	if !separateColourPlane {
+		subWidth, subHeight = chromaSubsampling(sps.ChromaFormat)
+	}
+	sps.Width = width - subWidth*(left+right)
+	sps.Height = height - subHeight*(top+bottom)
+	if sps.Width <= 0 || sps.Height <= 0 {
+		return nil, errors.New("codec: invalid SPS conformance window")
+	}
+	return sps, nil
+}
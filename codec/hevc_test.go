@@ -0,0 +1,41 @@
+package codec
+
+import (
+	"testing"
+)
+
+func Test_HEVCDecoderConfig(t *testing.T) {
+	sps := mustHex("420101016000000300b0000003000003005da003c0801107cbc0")
+	record := []byte{
+		0x01,
+		0x01,                   // profile space 0, tier 0, Main profile
+		0x60, 0x00, 0x00, 0x00, // compatibility flags
+		0xB0, 0x00, 0x00, 0x00, 0x00, 0x00, // constraint indicator flags
+		93,         // level 3.1
+		0xF0, 0x00, // min spatial segmentation
+		0xFC,       // parallelism type
+		0xFD,       // chroma format 4:2:0
+		0xF8, 0xF8, // bit depth 8
+		0x00, 0x00, // average frame rate
+		0x0F, // length size 4
+		0x01, // one array
+		0x80 | HEVCNALSPS, 0x00, 0x01, 0x00, byte(len(sps)),
+	}
+	record = append(record, sps...)
+
+	c, err := ParseHEVCDecoderConfig(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Codec() != "hvc1.1.6.L93.B0" || c.NALULengthSize != 4 || c.ChromaFormat != 1 || len(c.SPS) != 1 {
+		t.Errorf("unexpected config %v %+v", c.Codec(), c)
+	}
+
+	info, err := ParseHEVCSPS(c.SPS[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ChromaFormat != 1 || info.BitDepthLuma != 8 || info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("unexpected sps %+v", info)
+	}
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// OpusHead is the Opus identification header, RFC 7845 5.1
+type OpusHead struct {
+	Version         int
+	Channels        int
+	PreSkip         int
+	InputSampleRate int // informational, Opus is always decoded at 48000 Hz
+	OutputGain      int16
+	MappingFamily   int
+}
+
+// OpusSampleRate is the sample rate Opus is decoded at
+const OpusSampleRate = 48000
+
+// ParseOpusHead parses OpusHead, the payload of Opus sequence start
+func ParseOpusHead(data []byte) (*OpusHead, error) {
+	if len(data) < 19 {
+		return nil, errShortData
+	}
+	if !bytes.HasPrefix(data, []byte("OpusHead")) {
+		return nil, errors.New("codec: invalid OpusHead magic")
+	}
+	return &OpusHead{
+		Version:         int(data[8]),
+		Channels:        int(data[9]),
+		PreSkip:         int(binary.LittleEndian.Uint16(data[10:])),
+		InputSampleRate: int(binary.LittleEndian.Uint32(data[12:])),
+		OutputGain:      int16(binary.LittleEndian.Uint16(data[16:])),
+		MappingFamily:   int(data[18]),
+	}, nil
+}
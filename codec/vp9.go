@@ -0,0 +1,41 @@
+package codec
+
+import "fmt"
+
+// VPCodecConfig is a VPCodecConfigurationRecord (vpcC) of VP8 and VP9
+type VPCodecConfig struct {
+	Profile                 int
+	Level                   int
+	BitDepth                int
+	ChromaSubsampling       int
+	FullRange               bool
+	ColourPrimaries         int
+	TransferCharacteristics int
+	MatrixCoefficients      int
+}
+
+// ParseVPCodecConfig parses VPCodecConfigurationRecord, the payload of VP8 and VP9 sequence start.
+// It accepts the record with or without version and flags of the vpcC full box
+func ParseVPCodecConfig(data []byte) (*VPCodecConfig, error) {
+	if len(data) >= 12 && data[0] == 1 {
+		data = data[4:] // version 1 and flags
+	}
+	if len(data) < 8 {
+		return nil, errShortData
+	}
+	return &VPCodecConfig{
+		Profile:                 int(data[0]),
+		Level:                   int(data[1]),
+		BitDepth:                int(data[2] >> 4),
+		ChromaSubsampling:       int(data[2] >> 1 & 0x07),
+		FullRange:               data[2]&0x01 != 0,
+		ColourPrimaries:         int(data[3]),
+		TransferCharacteristics: int(data[4]),
+		MatrixCoefficients:      int(data[5]),
+	}, nil
+}
+
+// Codec returns the codec string of VP codec ISO media file format binding, e.g. "vp09.00.31.08"
+func (c *VPCodecConfig) Codec(fourCC string) string {
+	return fmt.Sprintf("%s.%02d.%02d.%02d", fourCC, c.Profile, c.Level, c.BitDepth)
+}
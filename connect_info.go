@@ -0,0 +1,36 @@
+package rtmp
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrBadName is returned by publish handler to reject a stream with NetStream.Publish.BadName
+var ErrBadName = errors.New("bad stream name")
+
+// ConnectInfo describes the connect command of a connection
+type ConnectInfo struct {
+	App        string
+	TcURL      string
+	SwfURL     string
+	PageURL    string
+	FlashVer   string
+	RemoteAddr net.Addr
+	Query      url.Values // query of app or tcUrl, e.g. "rtmp://host/live?token=xxx"
+	FourCCs    []string   // enhanced rtmp codecs announced by the client and supported by the server
+}
+
+// splitQuery splits "name?k=v" into name and parsed query
+func splitQuery(s string) (string, url.Values) {
+	i := strings.Index(s, "?")
+	if i < 0 {
+		return s, url.Values{}
+	}
+	query, err := url.ParseQuery(s[i+1:])
+	if err != nil {
+		query = url.Values{}
+	}
+	return s[:i], query
+}
@@ -0,0 +1,122 @@
+package rtmp
+
+import (
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/codec"
+)
+
+// capability flags of videoFourCcInfoMap and audioFourCcInfoMap in enhanced rtmp connect
+const (
+	fourCCCanDecode  = 0x01
+	fourCCCanEncode  = 0x02
+	fourCCCanForward = 0x04
+)
+
+// capsEx flags of enhanced rtmp connect
+const (
+	capsExReconnect  = 0x01
+	capsExMultitrack = 0x02
+	capsExModEx      = 0x04
+)
+
+// serverCapsEx is the capsEx answered by the server, it forwards multitrack tags and skips ModEx
+const serverCapsEx = capsExMultitrack | capsExModEx
+
+// enhancedCaps is the enhanced rtmp capabilities announced by a client in connect command
+type enhancedCaps struct {
+	fourCCs []string // requested FourCC supported by the server
+	video   amf.Object
+	audio   amf.Object
+	capsEx  bool
+}
+
+// isSupportedFourCC returns whether the server forwards the codec, it relays tags without decoding
+// so every codec of enhanced rtmp is supported
+func isSupportedFourCC(fourCC string) bool {
+	for _, list := range [][]string{codec.VideoFourCCs, codec.AudioFourCCs} {
+		for _, v := range list {
+			if v == fourCC {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fourCCInfo answers videoFourCcInfoMap or audioFourCcInfoMap of the client, "*" stands for any codec
+func fourCCInfo(requested map[string]interface{}, supported []string) amf.Object {
+	info := amf.Object{}
+	for _, fourCC := range supported {
+		if _, ok := requested[fourCC]; ok {
+			info = info.Set(fourCC, fourCCCanForward)
+		} else if _, ok := requested["*"]; ok {
+			info = info.Set(fourCC, fourCCCanForward)
+		}
+	}
+	return info
+}
+
+// parseEnhancedCaps reads fourCcList, videoFourCcInfoMap, audioFourCcInfoMap and capsEx of connect
+// command object, it returns nil if the client does not support enhanced rtmp
+func parseEnhancedCaps(kv map[string]interface{}) *enhancedCaps {
+	list, hasList := kv["fourCcList"].([]interface{})
+	videoInfo, hasVideo := kv["videoFourCcInfoMap"].(map[string]interface{})
+	audioInfo, hasAudio := kv["audioFourCcInfoMap"].(map[string]interface{})
+	_, hasCapsEx := kv["capsEx"].(float64)
+	if !hasList && !hasVideo && !hasAudio && !hasCapsEx {
+		return nil
+	}
+
+	caps := &enhancedCaps{capsEx: hasCapsEx}
+	for _, v := range list {
+		fourCC, _ := v.(string)
+		if fourCC == "*" {
+			caps.fourCCs = append(append(caps.fourCCs[:0], codec.VideoFourCCs...), codec.AudioFourCCs...)
+			break
+		}
+		if isSupportedFourCC(fourCC) {
+			caps.fourCCs = append(caps.fourCCs, fourCC)
+		}
+	}
+	if hasVideo {
+		caps.video = fourCCInfo(videoInfo, codec.VideoFourCCs)
+		for _, p := range caps.video {
+			caps.addFourCC(p.Name)
+		}
+	}
+	if hasAudio {
+		caps.audio = fourCCInfo(audioInfo, codec.AudioFourCCs)
+		for _, p := range caps.audio {
+			caps.addFourCC(p.Name)
+		}
+	}
+	return caps
+}
+
+func (caps *enhancedCaps) addFourCC(fourCC string) {
+	for _, v := range caps.fourCCs {
+		if v == fourCC {
+			return
+		}
+	}
+	caps.fourCCs = append(caps.fourCCs, fourCC)
+}
+
+// answer returns properties of connect _result command object announcing server capabilities
+func (caps *enhancedCaps) answer() []amf.Property {
+	list := make([]interface{}, 0, len(caps.fourCCs))
+	for _, fourCC := range caps.fourCCs {
+		list = append(list, fourCC)
+	}
+	props := []amf.Property{{Name: "fourCcList", Value: list}}
+	if caps.video != nil {
+		props = append(props, amf.Property{Name: "videoFourCcInfoMap", Value: caps.video})
+	}
+	if caps.audio != nil {
+		props = append(props, amf.Property{Name: "audioFourCcInfoMap", Value: caps.audio})
+	}
+	if caps.capsEx {
+		props = append(props, amf.Property{Name: "capsEx", Value: serverCapsEx})
+	}
+	return props
+}
@@ -0,0 +1,40 @@
+package rtmp
+
+import (
+	"encoding/binary"
+)
+
+// flv tag types
+const (
+	flvTagAudio  = 0x08
+	flvTagVideo  = 0x09
+	flvTagScript = 0x12
+)
+
+// flvTag returns a flv tag of the body followed by previous tag size, stream id is always 0
+func flvTag(tagType byte, timestamp uint32, body []byte) []byte {
+	tag := make([]byte, 11, 11+len(body)+4)
+	tag[0] = tagType
+	tag[1], tag[2], tag[3] = byte(len(body)>>16), byte(len(body)>>8), byte(len(body))
+	tag[4], tag[5], tag[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	tag[7] = byte(timestamp >> 24) // timestamp extended
+	tag = append(tag, body...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(tag)))
+	return append(tag, size...)
+}
+
+// flvHeader returns flv header followed by previous tag size 0
+func flvHeader(hasAudio bool, hasVideo bool) []byte {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+	return []byte{
+		'F', 'L', 'V', 0x01, flags, 0x00, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x00, // previous tag size
+	}
+}
@@ -0,0 +1,187 @@
+// Package fmp4 writes fragmented MP4 of single track CMAF, an init segment of the track
+// followed by fragments of samples
+package fmp4
+
+import (
+	"fmt"
+)
+
+// Sample entry types of supported codecs
+const (
+	CodecAVC  = "avc1"
+	CodecHEVC = "hvc1"
+	CodecAAC  = "mp4a"
+)
+
+// Track describes the track of an init segment
+type Track struct {
+	ID         uint32
+	Timescale  uint32
+	Codec      string // sample entry type, CodecAVC, CodecHEVC or CodecAAC
+	Config     []byte // AVCDecoderConfigurationRecord, HEVCDecoderConfigurationRecord or AudioSpecificConfig
+	Width      int    // video only
+	Height     int
+	SampleRate int // audio only
+	Channels   int
+}
+
+// IsVideo returns whether the track is a video track
+func (t *Track) IsVideo() bool {
+	return t.Codec != CodecAAC
+}
+
+// Sample is a media sample of a fragment
+type Sample struct {
+	Duration          uint32 // in timescale of the track
+	CompositionOffset int32  // PTS minus DTS in timescale of the track
+	Keyframe          bool
+	Data              []byte // length prefixed NAL units of video, raw frame of audio
+}
+
+// InitSegment returns ftyp and moov boxes of the track
+func InitSegment(track *Track) ([]byte, error) {
+	entry, err := sampleEntry(track)
+	if err != nil {
+		return nil, err
+	}
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6cmfcmp41"))
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation and modification time
+		u32(1000), u32(0), // timescale, duration
+		u32(0x00010000), u16(0x0100), zeros(10), // rate, volume, reserved
+		matrix, zeros(24),
+		u32(track.ID+1), // next track id
+	)
+
+	handler, name := "vide", "VideoHandler"
+	volume := uint16(0)
+	mediaHeader := fullBox("vmhd", 0, 1, zeros(8))
+	if !track.IsVideo() {
+		handler, name = "soun", "SoundHandler"
+		volume = 0x0100
+		mediaHeader = fullBox("smhd", 0, 0, zeros(4))
+	}
+	tkhd := fullBox("tkhd", 0, 0x03, // enabled, in movie
+		u32(0), u32(0), u32(track.ID), u32(0), u32(0), // times, track id, reserved, duration
+		zeros(8), u16(0), u16(0), u16(volume), u16(0), // reserved, layer, alternate group, volume, reserved
+		matrix,
+		u32(uint32(track.Width)<<16), u32(uint32(track.Height)<<16),
+	)
+	mdhd := fullBox("mdhd", 0, 0,
+		u32(0), u32(0), u32(track.Timescale), u32(0),
+		u16(0x55C4), u16(0), // language "und", pre defined
+	)
+	hdlr := fullBox("hdlr", 0, 0, u32(0), []byte(handler), zeros(12), []byte(name), []byte{0})
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), entry),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)),
+	)
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mediaHeader, dinf, stbl)))
+	mvex := box("mvex", fullBox("trex", 0, 0, u32(track.ID), u32(1), u32(0), u32(0), u32(0)))
+
+	moov := box("moov", mvhd, trak, mvex)
+	return append(ftyp, moov...), nil
+}
+
+// sampleEntry returns the sample entry box of stsd
+func sampleEntry(track *Track) ([]byte, error) {
+	switch track.Codec {
+	case CodecAVC, CodecHEVC:
+		configType := "avcC"
+		if track.Codec == CodecHEVC {
+			configType = "hvcC"
+		}
+		return box(track.Codec,
+			zeros(6), u16(1), // reserved, data reference index
+			zeros(16), // pre defined and reserved
+			u16(uint16(track.Width)), u16(uint16(track.Height)),
+			u32(0x00480000), u32(0x00480000), u32(0), // resolution 72 dpi, reserved
+			u16(1), zeros(32), // frame count, compressor name
+			u16(0x0018), u16(0xFFFF), // depth, pre defined
+			box(configType, track.Config),
+		), nil
+	case CodecAAC:
+		return box(track.Codec,
+			// reserved, data reference index, reserved
+			zeros(6), u16(1), zeros(8),
+			// channel count, sample size, pre defined and reserved, sample rate
+			u16(uint16(track.Channels)), u16(16), zeros(4),
+			u32(uint32(track.SampleRate)<<16),
+			esds(track),
+		), nil
+	}
+	return nil, fmt.Errorf("fmp4: codec %v is not supported", track.Codec)
+}
+
+// esds returns the elementary stream descriptor of AAC, ISO/IEC 14496-1 7.2.6.5
+func esds(track *Track) []byte {
+	decoderSpecificInfo := descriptor(0x05, track.Config)
+	decoderConfig := descriptor(0x04, append([]byte{
+		0x40,             // object type indication, MPEG-4 audio
+		0x15,             // stream type audio, reserved bit
+		0x00, 0x00, 0x00, // buffer size
+		0x00, 0x00, 0x00, 0x00, // max bitrate
+		0x00, 0x00, 0x00, 0x00, // average bitrate
+	}, decoderSpecificInfo...))
+	slConfig := descriptor(0x06, []byte{0x02})
+	es := descriptor(0x03, append(append([]byte{
+		byte(track.ID >> 8), byte(track.ID), // ES id
+		0x00, // flags
+	}, decoderConfig...), slConfig...))
+	return fullBox("esds", 0, 0, es)
+}
+
+// descriptor returns an MPEG-4 descriptor, size is written in 4 bytes
+func descriptor(tag byte, payload []byte) []byte {
+	size := len(payload)
+	header := []byte{tag, byte(size>>21) | 0x80, byte(size>>14) | 0x80, byte(size>>7) | 0x80, byte(size) & 0x7F}
+	return append(header, payload...)
+}
+
+// sample flags of trun, ISO/IEC 14496-12 8.8.3.1
+const (
+	sampleFlagsSync    = 0x02000000 // depends on no other sample
+	sampleFlagsNonSync = 0x01010000 // depends on others, non sync sample
+)
+
+// Fragment returns moof and mdat boxes of samples, baseDecodeTime is decode time of the
+// first sample in timescale of the track
+func Fragment(track *Track, sequence uint32, baseDecodeTime uint64, samples []Sample) []byte {
+	build := func(dataOffset uint32) []byte {
+		flags := uint32(0x000001 | 0x000100 | 0x000200 | 0x000400) // data offset, duration, size, flags
+		if track.IsVideo() {
+			flags |= 0x000800 // composition time offset
+		}
+		entries := make([][]byte, 0, len(samples)*4+2)
+		entries = append(entries, u32(uint32(len(samples))), u32(dataOffset))
+		for _, s := range samples {
+			sampleFlags := uint32(sampleFlagsNonSync)
+			if s.Keyframe || !track.IsVideo() {
+				sampleFlags = sampleFlagsSync
+			}
+			entries = append(entries, u32(s.Duration), u32(uint32(len(s.Data))), u32(sampleFlags))
+			if track.IsVideo() {
+				entries = append(entries, u32(uint32(s.CompositionOffset)))
+			}
+		}
+		traf := box("traf",
+			fullBox("tfhd", 0, 0x020000, u32(track.ID)), // default base is moof
+			fullBox("tfdt", 1, 0, u64(baseDecodeTime)),
+			fullBox("trun", 1, flags, entries...),
+		)
+		return box("moof", fullBox("mfhd", 0, 0, u32(sequence)), traf)
+	}
+	// moof size does not depend on the data offset
+	moof := build(0)
+	moof = build(uint32(len(moof) + 8))
+
+	data := make([][]byte, len(samples))
+	for i, s := range samples {
+		data[i] = s.Data
+	}
+	return append(moof, box("mdat", data...)...)
+}
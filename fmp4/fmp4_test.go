@@ -0,0 +1,116 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// boxes returns types and payloads of boxes one after another, it fails on inconsistent sizes
+func boxes(t *testing.T, data []byte) ([]string, [][]byte) {
+	types := make([]string, 0)
+	payloads := make([][]byte, 0)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box %x", data)
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("invalid box size %v of %s", size, data[4:8])
+		}
+		types = append(types, string(data[4:8]))
+		payloads = append(payloads, data[8:size])
+		data = data[size:]
+	}
+	return types, payloads
+}
+
+// find returns payload of the box at the path of container boxes
+func find(t *testing.T, data []byte, path ...string) []byte {
+	for _, name := range path {
+		types, payloads := boxes(t, data)
+		found := false
+		for i := range types {
+			if types[i] == name {
+				data = payloads[i]
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("box %v is not found in %v", name, types)
+		}
+	}
+	return data
+}
+
+func Test_InitSegment(t *testing.T) {
+	video := &Track{ID: 1, Timescale: 90000, Codec: CodecAVC, Config: []byte{0x01, 0x64, 0x00, 0x1F}, Width: 1280, Height: 720}
+	init, err := InitSegment(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types, _ := boxes(t, init); len(types) != 2 || types[0] != "ftyp" || types[1] != "moov" {
+		t.Fatalf("unexpected boxes %v", types)
+	}
+	mdhd := find(t, init, "moov", "trak", "mdia", "mdhd")
+	if binary.BigEndian.Uint32(mdhd[12:]) != 90000 {
+		t.Errorf("unexpected timescale %x", mdhd)
+	}
+	stsd := find(t, init, "moov", "trak", "mdia", "minf", "stbl", "stsd")
+	avc1 := find(t, stsd[8:], "avc1")
+	if binary.BigEndian.Uint16(avc1[24:]) != 1280 || binary.BigEndian.Uint16(avc1[26:]) != 720 ||
+		!bytes.Equal(find(t, avc1[78:], "avcC"), video.Config) {
+		t.Errorf("unexpected sample entry %x", avc1)
+	}
+	if trex := find(t, init, "moov", "mvex", "trex"); binary.BigEndian.Uint32(trex[4:]) != 1 {
+		t.Errorf("unexpected trex %x", trex)
+	}
+
+	audio := &Track{ID: 2, Timescale: 44100, Codec: CodecAAC, Config: []byte{0x12, 0x10}, SampleRate: 44100, Channels: 2}
+	if init, err = InitSegment(audio); err != nil {
+		t.Fatal(err)
+	}
+	stsd = find(t, init, "moov", "trak", "mdia", "minf", "stbl", "stsd")
+	mp4a := find(t, stsd[8:], "mp4a")
+	esds := find(t, mp4a[28:], "esds")
+	if binary.BigEndian.Uint16(mp4a[16:]) != 2 || binary.BigEndian.Uint32(mp4a[24:])>>16 != 44100 ||
+		!bytes.HasSuffix(esds, []byte{0x05, 0x80, 0x80, 0x80, 0x02, 0x12, 0x10, 0x06, 0x80, 0x80, 0x80, 0x01, 0x02}) {
+		t.Errorf("unexpected sample entry %x", mp4a)
+	}
+	find(t, init, "moov", "trak", "mdia", "minf", "smhd")
+
+	if _, err := InitSegment(&Track{Codec: "av01"}); err == nil {
+		t.Error("unsupported codec is expected to fail")
+	}
+}
+
+func Test_Fragment(t *testing.T) {
+	track := &Track{ID: 1, Timescale: 90000, Codec: CodecAVC}
+	samples := []Sample{
+		{Duration: 3600, CompositionOffset: 7200, Keyframe: true, Data: []byte{0x00, 0x00, 0x00, 0x01, 0x65}},
+		{Duration: 3600, CompositionOffset: -3600, Data: []byte{0x00, 0x00, 0x00, 0x01, 0x41}},
+	}
+	data := Fragment(track, 7, 0x100000000, samples)
+	types, payloads := boxes(t, data)
+	if len(types) != 2 || types[0] != "moof" || types[1] != "mdat" {
+		t.Fatalf("unexpected boxes %v", types)
+	}
+	if mfhd := find(t, data, "moof", "mfhd"); binary.BigEndian.Uint32(mfhd[4:]) != 7 {
+		t.Errorf("unexpected mfhd %x", mfhd)
+	}
+	if tfdt := find(t, data, "moof", "traf", "tfdt"); tfdt[0] != 1 || binary.BigEndian.Uint64(tfdt[4:]) != 0x100000000 {
+		t.Errorf("unexpected tfdt %x", tfdt)
+	}
+	trun := find(t, data, "moof", "traf", "trun")
+	offset := int(binary.BigEndian.Uint32(trun[8:]))
+	if binary.BigEndian.Uint32(trun[4:]) != 2 || !bytes.Equal(data[offset:], payloads[1]) ||
+		!bytes.Equal(payloads[1], []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x00, 0x00, 0x00, 0x01, 0x41}) {
+		t.Errorf("unexpected trun %x", trun)
+	}
+	// duration, size, flags and composition offset of the second sample
+	second := trun[12+16:]
+	if binary.BigEndian.Uint32(second[8:]) != sampleFlagsNonSync || int32(binary.BigEndian.Uint32(second[12:])) != -3600 {
+		t.Errorf("unexpected sample %x", second)
+	}
+}
@@ -0,0 +1,86 @@
+package rtmp
+
+// GopCacheSetting is the setting of GOP cache kept for each published stream
+type GopCacheSetting struct {
+	MaxGops  int // number of GOPs to keep, 0 disables the cache
+	MaxBytes int // byte budget of cached frames, 0 means no limit
+}
+
+var defaultGopCacheSetting = GopCacheSetting{
+	MaxGops:  1,
+	MaxBytes: 32 * 1024 * 1024,
+}
+
+// gopCache keeps frames since the last N video keyframes
+type gopCache struct {
+	setting GopCacheSetting
+	gops    [][]*StreamData
+	size    int
+}
+
+func newGopCache(setting GopCacheSetting) *gopCache {
+	return &gopCache{
+		setting: setting,
+		gops:    make([][]*StreamData, 0),
+	}
+}
+
+func (c *gopCache) reset() {
+	c.gops = c.gops[:0]
+	c.size = 0
+}
+
+func (c *gopCache) write(data *StreamData) {
+	if c.setting.MaxGops <= 0 {
+		return
+	}
+	if data.Type != FlvVideo && data.Type != FlvAudio {
+		return
+	}
+	if isSequenceHeader(data) {
+		return // sequence headers are cached separately
+	}
+
+	if data.Type == FlvVideo && isKeyframe(data) {
+		c.gops = append(c.gops, []*StreamData{data})
+		if len(c.gops) > c.setting.MaxGops {
+			c.size -= gopSize(c.gops[0])
+			c.gops = c.gops[1:]
+		}
+	} else if len(c.gops) > 0 {
+		last := len(c.gops) - 1
+		c.gops[last] = append(c.gops[last], data)
+	} else {
+		return // no keyframe yet
+	}
+	c.size += len(data.Data)
+
+	if c.setting.MaxBytes <= 0 {
+		return
+	}
+	for c.size > c.setting.MaxBytes && len(c.gops) > 1 {
+		c.size -= gopSize(c.gops[0])
+		c.gops = c.gops[1:]
+	}
+	if c.size > c.setting.MaxBytes {
+		// a single GOP is over budget, wait for next keyframe
+		c.reset()
+	}
+}
+
+// frames returns cached frames, starting from a keyframe
+func (c *gopCache) frames() []*StreamData {
+	frames := make([]*StreamData, 0)
+	for _, gop := range c.gops {
+		frames = append(frames, gop...)
+	}
+	return frames
+}
+
+func gopSize(gop []*StreamData) int {
+	size := 0
+	for _, data := range gop {
+		size += len(data.Data)
+	}
+	return size
+}
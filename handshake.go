@@ -1,93 +1,228 @@
 package rtmp
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 
 	l "github.com/junli1026/gortmp/logging"
 )
 
+const handshakeSize = 1536
+
+// HandshakeSetting is the setting for rtmp handshake
+type HandshakeSetting struct {
+	// StrictC2 rejects clients whose C2 does not echo S1 (simple handshake) or
+	// does not carry a valid signature (complex handshake); by default only a warning is logged
+	StrictC2 bool
+}
+
+var (
+	genuineFMSKey = []byte{
+		'G', 'e', 'n', 'u', 'i', 'n', 'e', ' ', 'A', 'd', 'o', 'b', 'e', ' ',
+		'F', 'l', 'a', 's', 'h', ' ', 'M', 'e', 'd', 'i', 'a', ' ',
+		'S', 'e', 'r', 'v', 'e', 'r', ' ', '0', '0', '1', // Genuine Adobe Flash Media Server 001
+		0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1,
+		0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB,
+		0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE,
+	}
+	genuineFPKey = []byte{
+		'G', 'e', 'n', 'u', 'i', 'n', 'e', ' ', 'A', 'd', 'o', 'b', 'e', ' ',
+		'F', 'l', 'a', 's', 'h', ' ', 'P', 'l', 'a', 'y', 'e', 'r', ' ',
+		'0', '0', '1', // Genuine Adobe Flash Player 001
+		0xF0, 0xEE, 0xC2, 0x4A, 0x80, 0x68, 0xBE, 0xE8, 0x2E, 0x00, 0xD0, 0xD1,
+		0x02, 0x9E, 0x7E, 0x57, 0x6E, 0xEC, 0x5D, 0x2D, 0x29, 0x80, 0x6F, 0xAB,
+		0x93, 0xB8, 0xE6, 0x36, 0xCF, 0xEB, 0x31, 0xAE,
+	}
+	serverVersion = []byte{0x04, 0x05, 0x00, 0x01}
+	clientVersion = []byte{0x09, 0x00, 0x7C, 0x02}
+)
+
 type handshakeState struct {
-	c0 bool
-	c1 bool
-	c2 bool
+	c1       bool // C0 and C1 are received
+	c2       bool
+	strictC2 bool
+	complex  bool
+	s1       []byte
+	s1Digest []byte
 }
 
 func newHandshakeState() *handshakeState {
 	return &handshakeState{
-		c0: false,
 		c1: false,
 		c2: false,
 	}
 }
 
-func (hs *handshakeState) generateS0S1S2(c1data []byte) (s0s1s2 [1 + 1536 + 1536]byte) {
-	s0s1s2[0] = 3
-	copy(s0s1s2[1:1536+1], c1data[:])
-	s0s1s2[1] = 1
-	s0s1s2[2] = 0
-	s0s1s2[3] = 2
-	s0s1s2[4] = 6
-	copy(s0s1s2[1+1536:], c1data[:])
-	return
+// digestOffset returns offset of the 32 bytes digest in C1/S1 for the given schema:
+// schema 0 puts the key block first and the digest block at 772, schema 1 puts the digest block at 8
+func digestOffset(data []byte, schema int) int {
+	base := 8
+	if schema == 0 {
+		base = 8 + 764
+	}
+	offset := int(data[base]) + int(data[base+1]) + int(data[base+2]) + int(data[base+3])
+	return offset%728 + base + 4
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// makeDigest computes digest of the handshake packet, excluding the digest itself
+func makeDigest(data []byte, offset int, key []byte) []byte {
+	return hmacSHA256(key, data[:offset], data[offset+32:])
 }
 
-func (hs *handshakeState) generateS0S1() (s0s1 [1537]byte) {
-	s0s1[0] = 3
-	s0s1[1] = 1
-	s0s1[2] = 0
-	s0s1[3] = 2
-	s0s1[4] = 6
-	return
+// findDigest validates C1 or S1 against both schemas, returns the schema and digest
+func findDigest(data []byte, key []byte) (int, []byte, bool) {
+	for _, schema := range []int{0, 1} {
+		offset := digestOffset(data, schema)
+		digest := makeDigest(data, offset, key)
+		if bytes.Equal(digest, data[offset:offset+32]) {
+			return schema, data[offset : offset+32], true
+		}
+	}
+	return 0, nil, false
 }
 
-func (hs *handshakeState) generateS2(c1data []byte) (s2 [1536]byte) {
-	if c1data[0] != 1 || c1data[1] != 0 || c1data[2] != 2 || c1data[3] != 6 {
-		l.Logger.Error("client does not hornor s1 timetamp")
+func randomBytes(data []byte) {
+	if _, err := rand.Read(data); err != nil {
+		l.Logger.Warnf("failed to generate random bytes: %v", err)
 	}
-	copy(s2[0:1536], c1data[:])
-	return
 }
 
-func (hs *handshakeState) checkC2(c2data []byte) error {
-	if c2data[0] != 1 || c2data[1] != 0 || c2data[2] != 2 || c2data[3] != 6 {
+func (hs *handshakeState) generateSimpleS1() []byte {
+	s1 := make([]byte, handshakeSize)
+	randomBytes(s1[8:])
+	hs.s1 = s1
+	return s1
+}
+
+func (hs *handshakeState) generateComplexS1(schema int) []byte {
+	s1 := make([]byte, handshakeSize)
+	randomBytes(s1[8:])
+	copy(s1[4:8], serverVersion)
+	offset := digestOffset(s1, schema)
+	hs.s1Digest = makeDigest(s1, offset, genuineFMSKey[:36])
+	copy(s1[offset:], hs.s1Digest)
+	hs.s1 = s1
+	return s1
+}
+
+func generateComplexS2(c1Digest []byte) []byte {
+	s2 := make([]byte, handshakeSize)
+	randomBytes(s2)
+	key := hmacSHA256(genuineFMSKey, c1Digest)
+	copy(s2[handshakeSize-32:], hmacSHA256(key, s2[:handshakeSize-32]))
+	return s2
+}
+
+// generateS1S2 generates S1 and S2, using complex handshake if C1 carries a valid digest
+func (hs *handshakeState) generateS1S2(c1 []byte) []byte {
+	reply := make([]byte, 0, 2*handshakeSize)
+	if c1[4] != 0 || c1[5] != 0 || c1[6] != 0 || c1[7] != 0 {
+		if schema, digest, ok := findDigest(c1, genuineFPKey[:30]); ok {
+			l.Logger.Debugf("complex handshake, schema %v", schema)
+			hs.complex = true
+			reply = append(reply, hs.generateComplexS1(schema)...)
+			return append(reply, generateComplexS2(digest)...)
+		}
+		l.Logger.Debug("failed to validate C1 digest, fallback to simple handshake")
+	}
+	reply = append(reply, hs.generateSimpleS1()...)
+	return append(reply, hs.generateS2(c1)...)
+}
+
+func (hs *handshakeState) generateS2(c1 []byte) []byte {
+	s2 := make([]byte, handshakeSize)
+	copy(s2, c1)
+	return s2
+}
+
+func (hs *handshakeState) checkC2(c2 []byte) error {
+	if hs.complex {
+		key := hmacSHA256(genuineFPKey, hs.s1Digest)
+		signature := hmacSHA256(key, c2[:handshakeSize-32])
+		if bytes.Equal(signature, c2[handshakeSize-32:]) {
+			return nil
+		}
+		// some clients simply echo S1 even in complex handshake
+		if bytes.Equal(c2, hs.s1) {
+			return nil
+		}
+		return errors.New("client C2 signature mismatch")
+	}
+	if !bytes.Equal(c2[0:4], hs.s1[0:4]) || !bytes.Equal(c2[8:], hs.s1[8:]) {
 		return errors.New("client does not hornor s1 timetamp")
 	}
 	return nil
 }
 
+// generateC0C1 generates C0 and C1 of a client, C1 carries a schema 1 digest so that servers
+// may answer with complex handshake
+func generateC0C1() []byte {
+	c1 := make([]byte, handshakeSize)
+	randomBytes(c1[8:])
+	copy(c1[4:8], clientVersion)
+	offset := digestOffset(c1, 1)
+	copy(c1[offset:], makeDigest(c1, offset, genuineFPKey[:30]))
+	return append([]byte{3}, c1...)
+}
+
+// generateC2 answers S1 of the server, C2 is signed if S1 carries a valid digest and echoes S1
+// otherwise. Like most clients, S2 is not verified
+func generateC2(s1 []byte) []byte {
+	c2 := make([]byte, handshakeSize)
+	if s1[4] != 0 || s1[5] != 0 || s1[6] != 0 || s1[7] != 0 {
+		if schema, digest, ok := findDigest(s1, genuineFMSKey[:36]); ok {
+			l.Logger.Debugf("complex handshake, schema %v", schema)
+			randomBytes(c2)
+			key := hmacSHA256(genuineFPKey, digest)
+			copy(c2[handshakeSize-32:], hmacSHA256(key, c2[:handshakeSize-32]))
+			return c2
+		}
+	}
+	copy(c2, s1)
+	return c2
+}
+
 func (hs *handshakeState) done() bool {
 	return hs.c2
 }
 
 func (hs *handshakeState) handshake(data []byte) (int, []byte, error) {
-	if !hs.c0 {
-		hs.c0 = true
-		if len(data) >= 1536+1 {
-			hs.c1 = true
-			reply := hs.generateS0S1S2(data[1 : 1+1536])
-			return 1537, reply[:], nil
-		}
-		reply := hs.generateS0S1()
-		return 1, reply[:], nil
-	}
 	if !hs.c1 {
-		if len(data) < 1536 {
+		// S1 depends on whether C1 carries a digest, so nothing is sent until C0 and C1 are received
+		if len(data) < 1+handshakeSize {
 			return 0, nil, nil
 		}
+		if data[0] != 3 {
+			l.Logger.Warnf("unexpected rtmp version %v", data[0])
+		}
 		hs.c1 = true
-		reply := hs.generateS2(data[0:1536])
-		return 1536, reply[:], nil
+		reply := append([]byte{3}, hs.generateS1S2(data[1:1+handshakeSize])...)
+		return 1 + handshakeSize, reply, nil
 	}
 	if !hs.c2 {
-		if len(data) < 1536 {
+		if len(data) < handshakeSize {
 			return 0, nil, nil
 		}
-		if err := hs.checkC2(data); err != nil {
-			return 0, nil, err
+		if err := hs.checkC2(data[0:handshakeSize]); err != nil {
+			if hs.strictC2 {
+				return 0, nil, err
+			}
+			l.Logger.Warn(err)
 		}
 		hs.c2 = true
 		l.Logger.Info("handshake done")
-		return 1536, nil, nil
+		return handshakeSize, nil, nil
 	}
 	return 0, nil, nil
 }
@@ -0,0 +1,119 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newComplexC1(schema int) []byte {
+	c1 := make([]byte, handshakeSize)
+	randomBytes(c1[8:])
+	copy(c1[4:8], []byte{0x80, 0x00, 0x07, 0x02})
+	offset := digestOffset(c1, schema)
+	copy(c1[offset:], makeDigest(c1, offset, genuineFPKey[:30]))
+	return c1
+}
+
+func Test_ComplexHandshake(t *testing.T) {
+	for _, schema := range []int{0, 1} {
+		hs := newHandshakeState()
+		hs.strictC2 = true
+		c1 := newComplexC1(schema)
+		consumed, reply, err := hs.handshake(append([]byte{3}, c1...))
+		if err != nil || consumed != 1+handshakeSize || len(reply) != 1+2*handshakeSize {
+			t.Fatal("unexpected S0S1S2")
+		}
+		if !hs.complex {
+			t.Fatalf("schema %v: complex handshake expected", schema)
+		}
+		s1 := reply[1 : 1+handshakeSize]
+		s2 := reply[1+handshakeSize:]
+
+		// client validates S1 digest with server key
+		offset := digestOffset(s1, schema)
+		s1Digest := s1[offset : offset+32]
+		if !bytes.Equal(makeDigest(s1, offset, genuineFMSKey[:36]), s1Digest) {
+			t.Fatal("S1 digest mismatch")
+		}
+
+		// client validates S2 signature
+		c1Digest := c1[digestOffset(c1, schema) : digestOffset(c1, schema)+32]
+		key := hmacSHA256(genuineFMSKey, c1Digest)
+		if !bytes.Equal(hmacSHA256(key, s2[:handshakeSize-32]), s2[handshakeSize-32:]) {
+			t.Fatal("S2 signature mismatch")
+		}
+
+		// client signs C2
+		c2 := make([]byte, handshakeSize)
+		randomBytes(c2)
+		key = hmacSHA256(genuineFPKey, s1Digest)
+		copy(c2[handshakeSize-32:], hmacSHA256(key, c2[:handshakeSize-32]))
+		if _, _, err := hs.handshake(c2); err != nil || !hs.done() {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_SimpleHandshakeFallback(t *testing.T) {
+	// C1 with version but without valid digest
+	c1 := make([]byte, handshakeSize)
+	c1[4] = 9
+	hs := newHandshakeState()
+	_, reply, err := hs.handshake(append([]byte{3}, c1...))
+	if err != nil || hs.complex {
+		t.Fatal("simple handshake expected")
+	}
+	if !bytes.Equal(reply[1+handshakeSize:], c1) {
+		t.Fatal("S2 does not echo C1")
+	}
+
+	// C2 not echoing S1 is tolerated by default
+	if _, _, err := hs.handshake(make([]byte, handshakeSize)); err != nil || !hs.done() {
+		t.Fail()
+	}
+
+	hs = newHandshakeState()
+	hs.strictC2 = true
+	hs.handshake(append([]byte{3}, c1...))
+	if _, _, err := hs.handshake(make([]byte, handshakeSize)); err == nil {
+		t.Fail()
+	}
+	hs = newHandshakeState()
+	hs.strictC2 = true
+	_, reply, _ = hs.handshake(append([]byte{3}, c1...))
+	if _, _, err := hs.handshake(reply[1 : 1+handshakeSize]); err != nil || !hs.done() {
+		t.Fail()
+	}
+}
+
+func Test_ClientHandshake(t *testing.T) {
+	hs := newHandshakeState()
+	hs.strictC2 = true
+	consumed, reply, err := hs.handshake(generateC0C1())
+	if err != nil || consumed != 1+handshakeSize || !hs.complex {
+		t.Fatal("complex handshake expected")
+	}
+	if _, _, err := hs.handshake(generateC2(reply[1 : 1+handshakeSize])); err != nil || !hs.done() {
+		t.Fatal(err)
+	}
+
+	// C2 echoes S1 of simple handshake
+	s1 := make([]byte, handshakeSize)
+	randomBytes(s1[8:])
+	if !bytes.Equal(generateC2(s1), s1) {
+		t.Error("simple C2 mismatch")
+	}
+}
+
+func Test_HandshakeC0BeforeC1(t *testing.T) {
+	hs := newHandshakeState()
+	c0c1 := generateC0C1()
+	// C0 and part of C1 are not answered, the reply depends on the digest of C1
+	if consumed, reply, err := hs.handshake(c0c1[:100]); consumed != 0 || reply != nil || err != nil {
+		t.Fatal("nothing is expected before C1 is received")
+	}
+	consumed, reply, err := hs.handshake(c0c1)
+	if err != nil || consumed != 1+handshakeSize || len(reply) != 1+2*handshakeSize || !hs.complex {
+		t.Fatal("complex handshake expected")
+	}
+}
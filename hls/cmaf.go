@@ -0,0 +1,454 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/codec"
+	"github.com/junli1026/gortmp/fmp4"
+	"github.com/junli1026/gortmp/logging"
+)
+
+const (
+	videoTimescale = 90000
+
+	// version of media playlists with EXT-X-MAP
+	cmafVersion = 6
+)
+
+// cmafSegmenter muxes streams into CMAF, video and audio are renditions of fragmented MP4 with
+// their own init segment and media playlist, listed by the master playlist. A segment is a
+// fragment per LL-HLS partial segment, or a single fragment without LL-HLS
+type cmafSegmenter struct {
+	st            *stream
+	video         *rendition
+	audio         *rendition
+	partStart     int64 // DTS of the pending part
+	frameDuration int64 // of recent video frames in milliseconds, to predict the end of parts
+	start         time.Time
+	resumed       map[string]*rendition // renditions of the previous publishing by name
+}
+
+// rendition is a single track of fragmented MP4 with its own segments and media playlist
+type rendition struct {
+	name           string // "video" or "audio", which is also the directory of its files
+	track          *fmp4.Track
+	codecs         string // RFC 6381 codec string
+	initURI        string
+	inits          int
+	sampleDuration uint32 // fixed duration of audio samples, 0 for video
+	sequence       int
+	samples        []*rtmp.StreamData // samples of the pending part
+	fragments      uint32
+	decodeTime     uint64 // decode time of the next sample in timescale of the track
+	hasDecodeTime  bool
+	lastDuration   uint32
+	data           []byte // fragments of the current segment
+	discontinuity  bool   // the next segment starts a discontinuity
+	playlist       playlist
+	expired        []*segment
+}
+
+func newCMAFSegmenter(st *stream) *cmafSegmenter {
+	return &cmafSegmenter{st: st}
+}
+
+func (c *cmafSegmenter) newRendition(name string) *rendition {
+	setting := c.st.setting
+	r := &rendition{
+		name: name,
+		playlist: playlist{
+			version:    cmafVersion,
+			window:     setting.WindowSize,
+			event:      setting.Event,
+			partTarget: setting.PartDuration,
+		},
+	}
+	if prev, ok := c.resumed[name]; ok {
+		r.sequence, r.inits, r.expired = prev.sequence, prev.inits, prev.expired
+		r.playlist.resume(&prev.playlist)
+		r.discontinuity = true
+	}
+	return r
+}
+
+func (c *cmafSegmenter) resume(prev segmenter) {
+	p, ok := prev.(*cmafSegmenter)
+	if !ok {
+		return
+	}
+	c.resumed = make(map[string]*rendition)
+	for _, r := range p.renditions() {
+		c.resumed[r.name] = r
+	}
+}
+
+func (c *cmafSegmenter) renditions() []*rendition {
+	renditions := make([]*rendition, 0, 2)
+	if c.video != nil {
+		renditions = append(renditions, c.video)
+	}
+	if c.audio != nil {
+		renditions = append(renditions, c.audio)
+	}
+	return renditions
+}
+
+// open starts segments of the renditions, init segments are written for new decoder configurations
+func (c *cmafSegmenter) open(seg *segment) error {
+	st := c.st
+	if st.videoType != 0 {
+		if c.video == nil {
+			c.video = c.newRendition("video")
+		}
+		track, codecs := c.videoTrack()
+		if err := c.setTrack(c.video, track, codecs); err != nil {
+			return err
+		}
+	}
+	if st.audioType != 0 {
+		if c.audio == nil {
+			c.audio = c.newRendition("audio")
+		}
+		track, codecs := c.audioTrack()
+		if err := c.setTrack(c.audio, track, codecs); err != nil {
+			return err
+		}
+		// an AAC frame is 1024 samples of the core, the output rate of HE-AAC is twice as high
+		c.audio.sampleDuration = 1024
+		if aac := st.aac; aac.CoreSampleRate > 0 {
+			c.audio.sampleDuration = uint32(1024 * aac.SampleRate / aac.CoreSampleRate)
+		}
+	}
+	for _, r := range c.renditions() {
+		uri := fmt.Sprintf("%s/%d.m4s", r.name, r.sequence)
+		r.playlist.current = &segment{
+			sequence:      r.sequence,
+			uri:           uri,
+			name:          st.name + "/" + uri,
+			start:         seg.start,
+			time:          seg.time,
+			discontinuity: seg.discontinuity || r.discontinuity,
+			mapURI:        r.initURI,
+		}
+		if st.setting.PartDuration > 0 {
+			r.playlist.preloadHint = r.partURI(r.playlist.current)
+		}
+		r.discontinuity = false
+		r.data = nil
+	}
+	c.partStart = seg.start
+	return nil
+}
+
+func (c *cmafSegmenter) videoTrack() (*fmp4.Track, string) {
+	st := c.st
+	track := &fmp4.Track{ID: 1, Timescale: videoTimescale, Codec: fmp4.CodecAVC, Config: st.videoConfig}
+	if st.videoType == streamTypeHEVC {
+		track.Codec = fmp4.CodecHEVC
+		if len(st.hevc.SPS) > 0 {
+			if sps, err := codec.ParseHEVCSPS(st.hevc.SPS[0]); err == nil {
+				track.Width, track.Height = sps.Width, sps.Height
+			}
+		}
+		return track, st.hevc.Codec()
+	}
+	if len(st.avc.SPS) > 0 {
+		if sps, err := codec.ParseAVCSPS(st.avc.SPS[0]); err == nil {
+			track.Width, track.Height = sps.Width, sps.Height
+		}
+	}
+	return track, st.avc.Codec()
+}
+
+func (c *cmafSegmenter) audioTrack() (*fmp4.Track, string) {
+	aac := c.st.aac
+	channels := aac.Channels
+	if channels == 0 {
+		channels = 2 // defined by program config element
+	}
+	return &fmp4.Track{
+		ID:         1,
+		Timescale:  uint32(aac.SampleRate),
+		Codec:      fmp4.CodecAAC,
+		Config:     c.st.audioConfig,
+		SampleRate: aac.SampleRate,
+		Channels:   channels,
+	}, aac.Codec()
+}
+
+// setTrack writes a new init segment of the rendition when its decoder configuration changed
+func (c *cmafSegmenter) setTrack(r *rendition, track *fmp4.Track, codecs string) error {
+	if r.track != nil && bytes.Equal(r.track.Config, track.Config) {
+		return nil
+	}
+	data, err := fmp4.InitSegment(track)
+	if err != nil {
+		return err
+	}
+	uri := fmt.Sprintf("%s/init%d.mp4", r.name, r.inits)
+	if err := c.st.setting.Storage.Write(c.st.name+"/"+uri, data); err != nil {
+		return err
+	}
+	r.inits++
+	r.track, r.codecs, r.initURI = track, codecs, uri
+	r.hasDecodeTime = false
+	return nil
+}
+
+func (c *cmafSegmenter) writeVideo(data *rtmp.StreamData) error {
+	r := c.video
+	if r == nil {
+		return nil // video started in the middle of a segment
+	}
+	if n := len(r.samples); n > 0 && data.DTS > r.samples[n-1].DTS {
+		c.frameDuration = data.DTS - r.samples[n-1].DTS
+	}
+	if err := c.splitPart(data.DTS, c.frameDuration); err != nil {
+		return err
+	}
+	r.samples = append(r.samples, data)
+	return nil
+}
+
+func (c *cmafSegmenter) writeAudio(data *rtmp.StreamData) error {
+	r := c.audio
+	if r == nil {
+		return nil
+	}
+	// parts of streams with video are split on video frames
+	if c.video == nil {
+		duration := int64(r.sampleDuration) * 1000 / int64(r.track.Timescale)
+		if err := c.splitPart(data.DTS, duration); err != nil {
+			return err
+		}
+	}
+	r.samples = append(r.samples, data)
+	return nil
+}
+
+// splitPart finishes the pending part before a frame at dts, when the frame lasting for
+// duration would make the part longer than PartDuration
+func (c *cmafSegmenter) splitPart(dts, duration int64) error {
+	partDuration := c.st.setting.PartDuration
+	if partDuration <= 0 || time.Duration(dts+duration-c.partStart)*time.Millisecond <= partDuration {
+		return nil
+	}
+	for _, r := range c.renditions() {
+		if len(r.samples) > 0 {
+			return c.flushPart(dts, false)
+		}
+	}
+	return nil
+}
+
+// flushPart writes pending samples as fragments ending at dts, which are partial segments with
+// LL-HLS. Playlists are written for the new parts unless the segment is finishing
+func (c *cmafSegmenter) flushPart(dts int64, finishing bool) error {
+	st := c.st
+	duration := time.Duration(dts-c.partStart) * time.Millisecond
+	c.partStart = dts
+	for _, r := range c.renditions() {
+		seg := r.playlist.current
+		if seg == nil || len(r.samples) == 0 {
+			continue
+		}
+		independent := !r.track.IsVideo() || r.samples[0].Keyframe
+		fragment := r.fragment(dts)
+		r.data = append(r.data, fragment...)
+		if st.setting.PartDuration <= 0 {
+			continue
+		}
+		uri := r.partURI(seg)
+		p := &part{uri: uri, name: st.name + "/" + uri, duration: duration, independent: independent}
+		if err := st.setting.Storage.Write(p.name, fragment); err != nil {
+			return err
+		}
+		seg.parts = append(seg.parts, p)
+		r.playlist.preloadHint = r.partURI(seg)
+		if finishing {
+			continue
+		}
+		if err := st.writePlaylist(r.name+".m3u8", &r.playlist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partURI returns uri of the next part of the segment, "{rendition}/{sequence}.{part}.m4s"
+func (r *rendition) partURI(seg *segment) string {
+	return fmt.Sprintf("%s/%d.%d.m4s", r.name, seg.sequence, len(seg.parts))
+}
+
+// fragment returns a fragment of the pending samples, the last video sample lasts until end
+func (r *rendition) fragment(end int64) []byte {
+	timescale := int64(r.track.Timescale)
+	toTimescale := func(ms int64) int64 {
+		return ms * timescale / 1000
+	}
+	samples := make([]fmp4.Sample, len(r.samples))
+	total := uint64(0)
+	for i, data := range r.samples {
+		duration := r.sampleDuration
+		if duration == 0 {
+			next := end
+			if i+1 < len(r.samples) {
+				next = r.samples[i+1].DTS
+			}
+			// frames without a valid duration last as long as the previous one
+			if d := toTimescale(next) - toTimescale(data.DTS); d > 0 {
+				r.lastDuration = uint32(d)
+			} else if r.lastDuration == 0 {
+				r.lastDuration = uint32(timescale / 25)
+			}
+			duration = r.lastDuration
+		}
+		samples[i] = fmp4.Sample{
+			Duration:          duration,
+			CompositionOffset: int32(toTimescale(data.PTS - data.DTS)),
+			Keyframe:          data.Keyframe,
+			Data:              data.Payload,
+		}
+		total += uint64(duration)
+	}
+
+	// decode time stays continuous unless it drifts away from timestamps
+	decodeTime := uint64(toTimescale(r.samples[0].DTS))
+	if drift := int64(r.decodeTime) - int64(decodeTime); r.hasDecodeTime && drift < timescale/10 && drift > -timescale/10 {
+		decodeTime = r.decodeTime
+	}
+	r.decodeTime, r.hasDecodeTime = decodeTime+total, true
+	r.samples = nil
+	r.fragments++
+	return fmp4.Fragment(r.track, r.fragments, decodeTime, samples)
+}
+
+// finish writes segments of the renditions with their playlists, then the master playlist and
+// the DASH manifest
+func (c *cmafSegmenter) finish(seg *segment, dts int64) error {
+	if err := c.flushPart(dts, true); err != nil {
+		return err
+	}
+	st := c.st
+	storage := st.setting.Storage
+	for _, r := range c.renditions() {
+		current := r.playlist.current
+		r.playlist.current, r.playlist.preloadHint = nil, ""
+		if current == nil {
+			continue
+		}
+		if len(r.data) == 0 {
+			// the rendition has no frames in the segment, the next segment takes its sequence
+			r.discontinuity = r.discontinuity || current.discontinuity
+			continue
+		}
+		current.duration, current.size = seg.duration, len(r.data)
+		if err := storage.Write(current.name, r.data); err != nil {
+			return err
+		}
+		r.data = nil
+		r.sequence++
+		r.expired = append(r.expired, r.playlist.add(current)...)
+		for len(r.expired) > keepExpired {
+			names := []string{r.expired[0].name}
+			for _, p := range r.expired[0].parts {
+				names = append(names, p.name)
+			}
+			for _, name := range names {
+				if err := storage.Remove(name); err != nil {
+					logging.Logger.Warnf("failed to remove %v: %v", name, err)
+				}
+			}
+			r.expired = r.expired[1:]
+		}
+		if err := st.writePlaylist(r.name+".m3u8", &r.playlist); err != nil {
+			return err
+		}
+	}
+	return c.writeManifests()
+}
+
+func (c *cmafSegmenter) end() error {
+	for _, r := range c.renditions() {
+		if len(r.playlist.segments) == 0 {
+			continue
+		}
+		r.playlist.ended = true
+		if err := c.st.writePlaylist(r.name+".m3u8", &r.playlist); err != nil {
+			return err
+		}
+	}
+	return c.writeManifests()
+}
+
+// writeManifests writes the master playlist, and the DASH manifest if enabled
+func (c *cmafSegmenter) writeManifests() error {
+	st := c.st
+	master := c.masterPlaylist()
+	if master == nil {
+		return nil
+	}
+	if err := st.setting.Storage.Write(st.name+"/"+playlistName, master); err != nil {
+		return err
+	}
+	if !st.setting.DASH {
+		return nil
+	}
+	return st.setting.Storage.Write(st.name+"/"+manifestName, c.manifest())
+}
+
+// masterPlaylist returns the master playlist of renditions with segments, audio is an
+// alternative rendition of video streams
+func (c *cmafSegmenter) masterPlaylist() []byte {
+	video, audio := c.video, c.audio
+	if video != nil && len(video.playlist.segments) == 0 {
+		video = nil
+	}
+	if audio != nil && len(audio.playlist.segments) == 0 {
+		audio = nil
+	}
+	if video == nil && audio == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", cmafVersion)
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if video == nil {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\"\n", audio.bandwidth(), audio.codecs)
+		b.WriteString(audio.name + ".m3u8\n")
+		return b.Bytes()
+	}
+	bandwidth, codecs := video.bandwidth(), video.codecs
+	if audio != nil {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio\",DEFAULT=YES,AUTOSELECT=YES,URI=\"%s.m3u8\"\n", audio.name)
+		bandwidth += audio.bandwidth()
+		codecs += "," + audio.codecs
+	}
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\"", bandwidth, codecs)
+	if video.track.Width > 0 && video.track.Height > 0 {
+		fmt.Fprintf(&b, ",RESOLUTION=%dx%d", video.track.Width, video.track.Height)
+	}
+	if audio != nil {
+		b.WriteString(",AUDIO=\"audio\"")
+	}
+	b.WriteString("\n" + video.name + ".m3u8\n")
+	return b.Bytes()
+}
+
+// bandwidth returns the peak bit rate of segments in the playlist
+func (r *rendition) bandwidth() int {
+	peak := 0
+	for _, seg := range r.playlist.segments {
+		if seconds := seg.duration.Seconds(); seconds > 0 {
+			if b := int(float64(seg.size*8) / seconds); b > peak {
+				peak = b
+			}
+		}
+	}
+	return peak
+}
@@ -0,0 +1,156 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+)
+
+// cmafInput returns sequence headers followed by frames of 100ms from 0 to end, with a keyframe
+// every 2 seconds
+func cmafInput(from, end int64) []*rtmp.StreamData {
+	input := make([]*rtmp.StreamData, 0)
+	if from == 0 {
+		input = append(input,
+			&rtmp.StreamData{Type: rtmp.FlvVideo, Codec: "avc1", SequenceHeader: true, Keyframe: true, Payload: avcConfig},
+			&rtmp.StreamData{Type: rtmp.FlvAudio, Codec: "mp4a", SequenceHeader: true, Payload: aacConfig})
+	}
+	for dts := from; dts < end; dts += 100 {
+		input = append(input, videoData(dts, dts%2000 == 0), audioData(dts))
+	}
+	return input
+}
+
+// decodeTime returns base media decode time of the first fragment
+func decodeTime(t *testing.T, fragment []byte) uint64 {
+	i := bytes.Index(fragment, []byte("tfdt"))
+	if i < 0 || len(fragment) < i+16 {
+		t.Fatalf("tfdt is not found in %x", fragment)
+	}
+	return binary.BigEndian.Uint64(fragment[i+8:])
+}
+
+func Test_CMAF(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	st := newStream("live/test", Setting{
+		Storage:        storage,
+		TargetDuration: 2 * time.Second,
+		WindowSize:     3,
+		Format:         FormatCMAF,
+		PartDuration:   500 * time.Millisecond,
+		DASH:           true,
+	})
+	start := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	st.now = func() time.Time { return start }
+
+	for _, data := range cmafInput(0, 2700) {
+		if err := st.write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live := string(storage.files["live/test/video.m3u8"])
+	for _, line := range []string{
+		"#EXT-X-VERSION:6\n",
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500\n",
+		"#EXT-X-PART-INF:PART-TARGET=0.500\n",
+		"#EXT-X-MAP:URI=\"video/init0.mp4\"\n",
+		"#EXT-X-PART:DURATION=0.500,URI=\"video/0.0.m4s\",INDEPENDENT=YES\n",
+		"#EXT-X-PART:DURATION=0.500,URI=\"video/0.1.m4s\"\n",
+		"#EXTINF:2.000,\nvideo/0.m4s\n",
+		"#EXT-X-PROGRAM-DATE-TIME:2026-10-17T08:00:02.000Z\n#EXT-X-PART:DURATION=0.500,URI=\"video/1.0.m4s\",INDEPENDENT=YES\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"video/1.1.m4s\"\n",
+	} {
+		if !strings.Contains(live, line) {
+			t.Errorf("%q is expected in playlist:\n%v", line, live)
+		}
+	}
+	master := string(storage.files["live/test/index.m3u8"])
+	if !strings.Contains(master, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio.m3u8\"\n") ||
+		!strings.Contains(master, ",CODECS=\"avc1.64001F,mp4a.40.2\",AUDIO=\"audio\"\nvideo.m3u8\n") {
+		t.Errorf("unexpected master playlist:\n%v", master)
+	}
+	manifest := string(storage.files["live/test/manifest.mpd"])
+	for _, s := range []string{
+		`type="dynamic"`,
+		`availabilityStartTime="2026-10-17T08:00:00.000Z"`,
+		`<SegmentTemplate timescale="90000" initialization="video/init0.mp4" media="video/$Number$.m4s" startNumber="0">`,
+		`<S t="0" d="180000"></S>`,
+		`<S t="0" d="88200"></S>`,
+		`mimeType="audio/mp4" codecs="mp4a.40.2"`,
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("%q is expected in manifest:\n%v", s, manifest)
+		}
+	}
+
+	for _, data := range cmafInput(2700, 5000) {
+		if err := st.write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.close(); err != nil {
+		t.Fatal(err)
+	}
+	ended := string(storage.files["live/test/video.m3u8"])
+	if !strings.HasSuffix(ended, "#EXTINF:0.900,\nvideo/2.m4s\n#EXT-X-ENDLIST\n") || strings.Contains(ended, "PRELOAD-HINT") {
+		t.Errorf("unexpected playlist:\n%v", ended)
+	}
+	if manifest := string(storage.files["live/test/manifest.mpd"]); !strings.Contains(manifest, `type="static"`) ||
+		!strings.Contains(manifest, `mediaPresentationDuration="PT4.900S"`) {
+		t.Errorf("unexpected manifest:\n%v", manifest)
+	}
+
+	// segments are the fragments of their parts, with continuous decode time
+	var parts []byte
+	for _, name := range []string{"1.0", "1.1", "1.2", "1.3"} {
+		parts = append(parts, storage.files["live/test/video/"+name+".m4s"]...)
+	}
+	segment := storage.files["live/test/video/1.m4s"]
+	if !bytes.Equal(segment, parts) || decodeTime(t, segment) != 2000*90 {
+		t.Errorf("unexpected segment %x", segment)
+	}
+	if decodeTime(t, storage.files["live/test/video/1.1.m4s"]) != 2500*90 {
+		t.Error("unexpected decode time of part")
+	}
+	if audio := storage.files["live/test/audio/1.m4s"]; decodeTime(t, audio) != 2000*44100/1000 {
+		t.Errorf("unexpected audio segment %x", audio)
+	}
+	if _, ok := storage.files["live/test/audio/init0.mp4"]; !ok {
+		t.Error("audio init segment is expected")
+	}
+}
+
+func Test_CMAFResume(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	setting := Setting{Storage: storage, TargetDuration: 2 * time.Second, WindowSize: 3, Format: FormatCMAF}
+	var prev *stream
+	for i := 0; i < 2; i++ {
+		st := newStream("live/test", setting)
+		if prev != nil {
+			st.resume(prev)
+		}
+		for _, data := range cmafInput(0, 4000) {
+			if err := st.write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := st.close(); err != nil {
+			t.Fatal(err)
+		}
+		prev = st
+	}
+
+	// init segments and segments of the second publishing follow those of the first one
+	video := string(storage.files["live/test/video.m3u8"])
+	if !strings.Contains(video, "#EXT-X-MEDIA-SEQUENCE:2\n") || !strings.Contains(video, "#EXT-X-DISCONTINUITY\n") ||
+		!strings.Contains(video, "#EXT-X-MAP:URI=\"video/init1.mp4\"\n") || !strings.Contains(video, "\nvideo/3.m4s\n") {
+		t.Errorf("unexpected playlist:\n%v", video)
+	}
+	if _, ok := storage.files["live/test/video/init0.mp4"]; !ok {
+		t.Error("init segment of the first publishing is expected to be kept")
+	}
+}
@@ -0,0 +1,149 @@
+package hls
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// MPD of MPEG-DASH, ISO/IEC 23009-1
+type mpd struct {
+	XMLName                   xml.Name  `xml:"MPD"`
+	Xmlns                     string    `xml:"xmlns,attr"`
+	Profiles                  string    `xml:"profiles,attr"`
+	Type                      string    `xml:"type,attr"`
+	AvailabilityStartTime     string    `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime               string    `xml:"publishTime,attr,omitempty"`
+	MinimumUpdatePeriod       string    `xml:"minimumUpdatePeriod,attr,omitempty"`
+	TimeShiftBufferDepth      string    `xml:"timeShiftBufferDepth,attr,omitempty"`
+	MediaPresentationDuration string    `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime             string    `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ContentType      string             `xml:"contentType,attr"`
+	SegmentAlignment bool               `xml:"segmentAlignment,attr"`
+	SegmentTemplate  mpdSegmentTemplate `xml:"SegmentTemplate"`
+	Representation   mpdRepresentation  `xml:"Representation"`
+}
+
+type mpdSegmentTemplate struct {
+	Timescale      uint32        `xml:"timescale,attr"`
+	Initialization string        `xml:"initialization,attr"`
+	Media          string        `xml:"media,attr"`
+	StartNumber    int           `xml:"startNumber,attr"`
+	Timeline       []mpdTimeline `xml:"SegmentTimeline>S"`
+}
+
+type mpdTimeline struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+type mpdRepresentation struct {
+	ID                        string         `xml:"id,attr"`
+	MimeType                  string         `xml:"mimeType,attr"`
+	Codecs                    string         `xml:"codecs,attr"`
+	Bandwidth                 int            `xml:"bandwidth,attr"`
+	Width                     int            `xml:"width,attr,omitempty"`
+	Height                    int            `xml:"height,attr,omitempty"`
+	AudioSamplingRate         int            `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *mpdDescriptor `xml:"AudioChannelConfiguration,omitempty"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+// manifest returns the DASH manifest of renditions with segments, which is dynamic while the
+// stream is live and static after it ended. A rendition is an adaptation set with a
+// SegmentTemplate of its segment numbers and a SegmentTimeline of the playlist window, the
+// template refers to the init segment of the latest decoder configuration
+func (c *cmafSegmenter) manifest() []byte {
+	st := c.st
+	if c.start.IsZero() {
+		// wall clock time of DTS 0
+		c.start = st.clock.Add(-time.Duration(st.clockDTS) * time.Millisecond)
+	}
+	m := mpd{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:          "dynamic",
+		MinBufferTime: isoDuration(st.setting.TargetDuration),
+		Period:        mpdPeriod{ID: "0", Start: "PT0S"},
+	}
+
+	var first, last int64 = -1, 0 // presentation range of the window in milliseconds
+	for _, r := range c.renditions() {
+		segments := r.playlist.segments
+		if len(segments) == 0 {
+			continue
+		}
+		timescale := int64(r.track.Timescale)
+		template := mpdSegmentTemplate{
+			Timescale:      r.track.Timescale,
+			Initialization: r.initURI,
+			Media:          r.name + "/$Number$.m4s",
+			StartNumber:    segments[0].sequence,
+		}
+		for _, seg := range segments {
+			end := seg.start + int64(seg.duration/time.Millisecond)
+			t := seg.start * timescale / 1000
+			template.Timeline = append(template.Timeline, mpdTimeline{T: t, D: end*timescale/1000 - t})
+			if first < 0 || seg.start < first {
+				first = seg.start
+			}
+			if end > last {
+				last = end
+			}
+		}
+		representation := mpdRepresentation{
+			ID:        r.name,
+			MimeType:  r.name + "/mp4",
+			Codecs:    r.codecs,
+			Bandwidth: r.bandwidth(),
+		}
+		if r.track.IsVideo() {
+			representation.Width, representation.Height = r.track.Width, r.track.Height
+		} else {
+			representation.AudioSamplingRate = r.track.SampleRate
+			representation.AudioChannelConfiguration = &mpdDescriptor{
+				SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+				Value:       fmt.Sprint(r.track.Channels),
+			}
+		}
+		m.Period.AdaptationSets = append(m.Period.AdaptationSets, mpdAdaptationSet{
+			ContentType:      r.name,
+			SegmentAlignment: true,
+			SegmentTemplate:  template,
+			Representation:   representation,
+		})
+	}
+
+	window := time.Duration(last-first) * time.Millisecond
+	if st.ended {
+		m.Type = "static"
+		m.MediaPresentationDuration = isoDuration(time.Duration(last) * time.Millisecond)
+	} else {
+		m.AvailabilityStartTime = c.start.UTC().Format("2006-01-02T15:04:05.000Z")
+		m.PublishTime = st.now().UTC().Format("2006-01-02T15:04:05.000Z")
+		m.MinimumUpdatePeriod = isoDuration(st.setting.TargetDuration)
+		if !st.setting.Event {
+			m.TimeShiftBufferDepth = isoDuration(window)
+		}
+	}
+	data, _ := xml.MarshalIndent(&m, "", "  ")
+	return append([]byte(xml.Header), append(data, '\n')...)
+}
+
+func isoDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler returns an http.Handler serving media playlists of live streams with blocking playlist
+// reload of LL-HLS, a request with _HLS_msn, and optionally _HLS_part, waits until the playlist
+// contains the segment or part. So does a request of the part in the preload hint. Every other
+// request, and playlists of ended streams, are served by files, which is usually a file server of
+// the storage directory, e.g.
+//
+//	http.Handle("/hls/", http.StripPrefix("/hls", packager.Handler(http.FileServer(http.Dir("hls")))))
+func (p *Packager) Handler(files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// "{app}/{stream}/{file}"
+		elements := strings.SplitN(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"), "/", 3)
+		var st *stream
+		if len(elements) == 3 {
+			p.mux.Lock()
+			st = p.paths[elements[0]+"/"+elements[1]]
+			p.mux.Unlock()
+		}
+		if st == nil {
+			files.ServeHTTP(w, r)
+			return
+		}
+		st.serve(w, r, elements[2], files)
+	})
+}
+
+// serve serves a file of the stream, it blocks until the requested segment or part is available
+func (st *stream) serve(w http.ResponseWriter, r *http.Request, file string, files http.Handler) {
+	msn, part := -1, -1
+	query := r.URL.Query()
+	if v := query.Get("_HLS_msn"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		msn = n
+	}
+	if v := query.Get("_HLS_part"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || msn < 0 {
+			http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+			return
+		}
+		part = n
+	}
+
+	timeout := time.NewTimer(3 * st.setting.TargetDuration)
+	defer timeout.Stop()
+	for {
+		var data []byte
+		ready := true
+		st.mux.Lock()
+		if pl, ok := st.playlists[file]; ok {
+			if msn > pl.lastSequence()+2 && !pl.ended {
+				st.mux.Unlock()
+				http.Error(w, "_HLS_msn is too far in the future", http.StatusBadRequest)
+				return
+			}
+			if ready = msn < 0 || pl.has(msn, part); ready {
+				data = pl.encode()
+			}
+		} else {
+			ready = !st.hinted(file)
+		}
+		updated := st.updated
+		st.mux.Unlock()
+
+		if ready && data != nil {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Write(data)
+			return
+		}
+		if ready {
+			files.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case <-updated:
+		case <-timeout.C:
+			http.Error(w, "timeout", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// hinted returns whether the file is the preload hint of a playlist, which is not written yet
+func (st *stream) hinted(file string) bool {
+	if st.ended {
+		return false
+	}
+	for _, pl := range st.playlists {
+		if pl.preloadHint == file {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package hls
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_BlockingReload(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	p := NewPackager(&Setting{
+		Storage:        storage,
+		TargetDuration: 2 * time.Second,
+		Format:         FormatCMAF,
+		PartDuration:   500 * time.Millisecond,
+	})
+	st := newStream("live/test", p.setting)
+	p.paths[st.name] = st
+	files := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := storage.files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+	server := httptest.NewServer(p.Handler(files))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Error(err)
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, data := range cmafInput(0, 700) {
+		st.write(data)
+	}
+	if code, body := get("/live/test/video.m3u8"); code != http.StatusOK || !strings.Contains(body, "video/0.0.m4s") {
+		t.Errorf("unexpected response %v:\n%v", code, body)
+	}
+	if code, _ := get("/live/test/video.m3u8?_HLS_msn=3"); code != http.StatusBadRequest {
+		t.Errorf("unexpected status %v of a far sequence", code)
+	}
+
+	type response struct {
+		code int
+		body string
+	}
+	playlist := make(chan response, 1)
+	go func() {
+		code, body := get("/live/test/video.m3u8?_HLS_msn=0&_HLS_part=1")
+		playlist <- response{code, body}
+	}()
+	part := make(chan response, 1)
+	go func() {
+		code, body := get("/live/test/video/0.1.m4s")
+		part <- response{code, body}
+	}()
+	select {
+	case <-playlist:
+		t.Fatal("playlist is expected to block until the part is written")
+	case <-part:
+		t.Fatal("preload hint is expected to block until the part is written")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for _, data := range cmafInput(700, 1100) {
+		st.write(data)
+	}
+	if r := <-playlist; r.code != http.StatusOK || !strings.Contains(r.body, "URI=\"video/0.1.m4s\"") {
+		t.Errorf("unexpected response %v:\n%v", r.code, r.body)
+	}
+	if r := <-part; r.code != http.StatusOK || r.body != string(storage.files["live/test/video/0.1.m4s"]) {
+		t.Errorf("unexpected part of status %v", r.code)
+	}
+}
@@ -0,0 +1,128 @@
+// Package hls packages published rtmp streams into HTTP Live Streaming, MPEG-TS or CMAF segments
+// with playlists written to a pluggable storage. CMAF output supports Low-Latency HLS partial
+// segments and MPEG-DASH manifests
+package hls
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// Format is the segment format of Packager
+type Format int
+
+// Segment formats
+const (
+	FormatTS   Format = iota // MPEG-TS segments with a media playlist
+	FormatCMAF               // fragmented MP4 renditions of video and audio with a master playlist
+)
+
+// Setting is the setting of Packager
+type Setting struct {
+	Storage        Storage       // default DirStorage of directory "hls"
+	TargetDuration time.Duration // segments are cut on the first keyframe after the duration, default 6 seconds
+	WindowSize     int           // segments of sliding window playlists, default 6
+	Event          bool          // event playlists keep every segment instead of a sliding window
+	Format         Format        // default FormatTS
+
+	// options of FormatCMAF
+	PartDuration time.Duration // duration of LL-HLS partial segments, 0 disables LL-HLS
+	DASH         bool          // writes an MPEG-DASH manifest besides playlists
+}
+
+const (
+	defaultTargetDuration = 6 * time.Second
+	defaultWindowSize     = 6
+
+	// segments removed from the window are kept for players which loaded the playlist earlier
+	keepExpired = 2
+
+	playlistName = "index.m3u8"
+	manifestName = "manifest.mpd"
+)
+
+// Packager packages published streams into HLS, Write and Close are meant to be registered by
+// OnStreamData and OnStreamClose. H.264, HEVC and AAC are muxed into MPEG-TS segments, which
+// are written with the playlist of the stream as "{app}/{stream}/index.m3u8". With FormatCMAF,
+// "{app}/{stream}/index.m3u8" is a master playlist of "video.m3u8" and "audio.m3u8"
+type Packager struct {
+	setting Setting
+	mux     sync.Mutex
+	streams map[*rtmp.StreamMeta]*stream
+	paths   map[string]*stream // live streams by storage path, for Handler
+	ended   map[string]*stream // ended streams by storage path, continued by the next publishing
+}
+
+// NewPackager returns a packager with the setting
+func NewPackager(setting *Setting) *Packager {
+	p := &Packager{
+		setting: *setting,
+		streams: make(map[*rtmp.StreamMeta]*stream),
+		paths:   make(map[string]*stream),
+		ended:   make(map[string]*stream),
+	}
+	if p.setting.Storage == nil {
+		p.setting.Storage = NewDirStorage("hls")
+	}
+	if p.setting.TargetDuration <= 0 {
+		p.setting.TargetDuration = defaultTargetDuration
+	}
+	if p.setting.WindowSize <= 0 {
+		p.setting.WindowSize = defaultWindowSize
+	}
+	if p.setting.Format != FormatCMAF {
+		p.setting.PartDuration = 0
+		p.setting.DASH = false
+	}
+	return p
+}
+
+// Write packages data of the stream, it is a StreamDataHandler
+func (p *Packager) Write(meta *rtmp.StreamMeta, data *rtmp.StreamData) error {
+	p.mux.Lock()
+	st, ok := p.streams[meta]
+	if !ok {
+		st = newStream(StreamPath(meta), p.setting)
+		if prev, ok := p.ended[st.name]; ok {
+			st.resume(prev)
+			delete(p.ended, st.name)
+		}
+		p.streams[meta] = st
+		p.paths[st.name] = st
+	}
+	p.mux.Unlock()
+	return st.write(data)
+}
+
+// Close writes the last segment and ends the playlist of the stream, it is a StreamCloseHandler
+func (p *Packager) Close(meta *rtmp.StreamMeta, err error) {
+	p.mux.Lock()
+	st, ok := p.streams[meta]
+	delete(p.streams, meta)
+	if ok && p.paths[st.name] == st {
+		delete(p.paths, st.name)
+	}
+	if !ok {
+		p.mux.Unlock()
+		return
+	}
+	p.ended[st.name] = st
+	// the stream is locked before the packager is released, so that a new publishing of the path
+	// resumes once the stream has ended
+	st.mux.Lock()
+	p.mux.Unlock()
+	defer st.mux.Unlock()
+	if err := st.end(); err != nil {
+		logging.Logger.Warnf("failed to end hls of %v: %v", st.name, err)
+	}
+}
+
+// StreamPath returns the storage path of a stream, "{app}/{stream}"
+func StreamPath(meta *rtmp.StreamMeta) string {
+	sanitize := strings.NewReplacer("/", "_", "\\", "_", "..", "_")
+	return sanitize.Replace(meta.App()) + "/" + sanitize.Replace(meta.StreamName())
+}
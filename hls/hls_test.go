@@ -0,0 +1,178 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+)
+
+type memoryStorage struct {
+	files map[string][]byte
+}
+
+func (s *memoryStorage) Write(name string, data []byte) error {
+	s.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryStorage) Remove(name string) error {
+	delete(s.files, name)
+	return nil
+}
+
+var (
+	avcConfig = []byte{
+		0x01, 0x64, 0x00, 0x1F, 0xFF,
+		0xE1, 0x00, 0x04, 0x67, 0x64, 0x00, 0x1F, // SPS
+		0x01, 0x00, 0x02, 0x68, 0xEE, // PPS
+	}
+	aacConfig = []byte{0x12, 0x10}
+)
+
+func videoData(dts int64, keyframe bool) *rtmp.StreamData {
+	nalu := []byte{0x41, 0x9A}
+	if keyframe {
+		nalu = []byte{0x65, 0x88}
+	}
+	return &rtmp.StreamData{
+		Type:     rtmp.FlvVideo,
+		Codec:    "avc1",
+		DTS:      dts,
+		PTS:      dts + 40,
+		Keyframe: keyframe,
+		Payload:  append([]byte{0x00, 0x00, 0x00, 0x02}, nalu...),
+	}
+}
+
+func audioData(dts int64) *rtmp.StreamData {
+	return &rtmp.StreamData{Type: rtmp.FlvAudio, Codec: "mp4a", DTS: dts, PTS: dts, Payload: []byte{0x21, 0x00}}
+}
+
+func Test_Packager(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	st := newStream("live/test", Setting{Storage: storage, TargetDuration: 4 * time.Second, WindowSize: 2})
+	start := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	st.now = func() time.Time { return start }
+
+	input := []*rtmp.StreamData{
+		{Type: rtmp.FlvVideo, Codec: "avc1", SequenceHeader: true, Keyframe: true, Payload: avcConfig},
+		{Type: rtmp.FlvAudio, Codec: "mp4a", SequenceHeader: true, Payload: aacConfig},
+	}
+	for dts := int64(0); dts < 12000; dts += 1000 {
+		video := videoData(dts, dts%2000 == 0)
+		video.Discontinuity = dts == 10000
+		input = append(input, video, audioData(dts))
+	}
+	for _, data := range input {
+		if err := st.write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-TARGETDURATION:4",
+		"#EXT-X-MEDIA-SEQUENCE:2",
+		"#EXT-X-PROGRAM-DATE-TIME:2026-10-17T08:00:08.000Z",
+		"#EXTINF:2.000,",
+		"2.ts",
+		"#EXT-X-DISCONTINUITY",
+		"#EXT-X-PROGRAM-DATE-TIME:2026-10-17T08:00:00.000Z",
+		"#EXTINF:1.000,",
+		"3.ts",
+		"#EXT-X-ENDLIST",
+	}, "\n") + "\n"
+	if playlist := string(storage.files["live/test/index.m3u8"]); playlist != expected {
+		t.Errorf("unexpected playlist:\n%v", playlist)
+	}
+	for i := 0; i < 4; i++ {
+		if _, ok := storage.files[fmt.Sprintf("live/test/%d.ts", i)]; !ok {
+			t.Errorf("segment %v is expected to be kept", i)
+		}
+	}
+
+	segment := storage.files["live/test/0.ts"]
+	video := demuxPES(t, segment, pidVideo)
+	audio := demuxPES(t, segment, pidAudio)
+	if len(video) != 4 || len(audio) != 4 {
+		t.Fatalf("unexpected PES count %v %v", len(video), len(audio))
+	}
+	keyframe := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x09, 0xF0,
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x1F,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xEE,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88,
+	}
+	if !bytes.Equal(video[0][19:], keyframe) || decodeTimestamp(video[0][9:]) != 40*90 {
+		t.Errorf("unexpected keyframe %x", video[0])
+	}
+	if !bytes.Equal(video[1][19:], []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0, 0x00, 0x00, 0x00, 0x01, 0x41, 0x9A}) {
+		t.Errorf("unexpected frame %x", video[1])
+	}
+	if !bytes.Equal(audio[1][14:], []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x3F, 0xFC, 0x21, 0x00}) ||
+		decodeTimestamp(audio[1][9:]) != 1000*90 {
+		t.Errorf("unexpected audio %x", audio[1])
+	}
+}
+
+func Test_Republish(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	p := NewPackager(&Setting{Storage: storage, TargetDuration: 2 * time.Second, WindowSize: 6})
+	publish := func() {
+		meta := rtmp.NewStreamMeta("live", "rtmp://localhost/live", "test?key=1", 1)
+		p.Write(meta, &rtmp.StreamData{Type: rtmp.FlvVideo, Codec: "avc1", SequenceHeader: true, Keyframe: true, Payload: avcConfig})
+		for dts := int64(0); dts < 6000; dts += 1000 {
+			if err := p.Write(meta, videoData(dts, dts%2000 == 0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.Close(meta, nil)
+	}
+
+	// segments 0 to 2 of the first publishing are kept for players loading them
+	publish()
+	first := storage.files["live/test/0.ts"]
+	publish()
+	if !bytes.Equal(storage.files["live/test/0.ts"], first) {
+		t.Error("segment of the first publishing is overwritten")
+	}
+	for i := 0; i < 6; i++ {
+		if _, ok := storage.files[fmt.Sprintf("live/test/%d.ts", i)]; !ok {
+			t.Errorf("segment %v is expected", i)
+		}
+	}
+	// the playlist continues from segment 3, which starts a discontinuity
+	playlist := string(storage.files["live/test/index.m3u8"])
+	if !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY\n") || !strings.Contains(playlist, "\n5.ts\n") {
+		t.Errorf("unexpected playlist:\n%v", playlist)
+	}
+}
+
+func Test_SlidingWindow(t *testing.T) {
+	storage := &memoryStorage{files: make(map[string][]byte)}
+	st := newStream("live/test", Setting{Storage: storage, TargetDuration: time.Second, WindowSize: 2})
+	st.write(&rtmp.StreamData{Type: rtmp.FlvAudio, Codec: "mp4a", SequenceHeader: true, Payload: aacConfig})
+	for dts := int64(0); dts <= 6000; dts += 1000 {
+		st.write(audioData(dts))
+	}
+
+	// audio only streams are cut on any frame, segments 0 to 5 are written
+	playlist := string(storage.files["live/test/index.m3u8"])
+	if !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:4\n") || strings.Contains(playlist, "#EXT-X-ENDLIST") {
+		t.Errorf("unexpected playlist:\n%v", playlist)
+	}
+	for i := 0; i < 6; i++ {
+		_, ok := storage.files[fmt.Sprintf("live/test/%d.ts", i)]
+		if ok != (i >= 2) {
+			t.Errorf("unexpected existence of segment %v", i)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// segment is a media segment of a playlist
+type segment struct {
+	sequence      int
+	uri           string // relative to the playlist
+	name          string // name in storage
+	start         int64  // DTS of the first frame
+	duration      time.Duration
+	time          time.Time // wall clock time of the first frame
+	discontinuity bool
+	mapURI        string // init segment of fragmented MP4
+	size          int
+	parts         []*part
+}
+
+// part is a partial segment of LL-HLS
+type part struct {
+	uri         string
+	name        string
+	duration    time.Duration
+	independent bool // starts with a keyframe
+}
+
+// playlist is a media playlist, either a sliding window of the latest segments or an event
+// playlist keeping every segment
+type playlist struct {
+	version               int
+	segments              []*segment
+	window                int // 0 keeps every segment
+	event                 bool
+	ended                 bool
+	targetDuration        int // seconds, never decreases so that it stays valid for players
+	discontinuitySequence int
+
+	// LL-HLS, parts of recent segments and the in-progress segment are listed when partTarget is set
+	partTarget  time.Duration
+	current     *segment
+	preloadHint string // uri of the next part
+}
+
+// add appends a segment, it returns segments removed from the window
+func (pl *playlist) add(seg *segment) []*segment {
+	pl.segments = append(pl.segments, seg)
+	if d := int(math.Ceil(seg.duration.Seconds())); d > pl.targetDuration {
+		pl.targetDuration = d
+	}
+	if pl.event || pl.window <= 0 || len(pl.segments) <= pl.window {
+		return nil
+	}
+	removed := pl.segments[:len(pl.segments)-pl.window]
+	for _, seg := range removed {
+		if seg.discontinuity {
+			pl.discontinuitySequence++
+		}
+	}
+	pl.segments = append([]*segment(nil), pl.segments[len(removed):]...)
+	return removed
+}
+
+// resume continues the discontinuity sequence of a playlist of the previous publishing, whose
+// segments are not listed
+func (pl *playlist) resume(prev *playlist) {
+	pl.discontinuitySequence = prev.discontinuitySequence
+	for _, seg := range prev.segments {
+		if seg.discontinuity {
+			pl.discontinuitySequence++
+		}
+	}
+}
+
+// has returns whether the playlist contains the segment of sequence msn, or its part when
+// part is not negative
+func (pl *playlist) has(msn, part int) bool {
+	if pl.ended {
+		return true
+	}
+	if n := len(pl.segments); n > 0 && msn <= pl.segments[n-1].sequence {
+		return true
+	}
+	return part >= 0 && pl.current != nil && msn == pl.current.sequence && part < len(pl.current.parts)
+}
+
+// lastSequence returns sequence of the last complete segment, -1 without segments
+func (pl *playlist) lastSequence() int {
+	if len(pl.segments) == 0 {
+		return -1
+	}
+	return pl.segments[len(pl.segments)-1].sequence
+}
+
+func (pl *playlist) encode() []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", pl.version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", pl.targetDuration)
+	if pl.partTarget > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*pl.partTarget.Seconds())
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", pl.partTarget.Seconds())
+	}
+	sequence := 0
+	if len(pl.segments) > 0 {
+		sequence = pl.segments[0].sequence
+	} else if pl.current != nil {
+		sequence = pl.current.sequence
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if pl.discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", pl.discontinuitySequence)
+	}
+	if pl.event {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+
+	// parts are listed for segments within three target durations of the end, as LL-HLS requires
+	partsFrom := len(pl.segments)
+	for d := time.Duration(0); pl.partTarget > 0 && partsFrom > 0 && d < 3*time.Duration(pl.targetDuration)*time.Second; {
+		partsFrom--
+		d += pl.segments[partsFrom].duration
+	}
+	mapURI := ""
+	writeHeader := func(seg *segment) {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.mapURI != mapURI {
+			mapURI = seg.mapURI
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", mapURI)
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.time.UTC().Format("2006-01-02T15:04:05.000Z"))
+	}
+	writeParts := func(seg *segment) {
+		for _, p := range seg.parts {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.duration.Seconds(), p.uri)
+			if p.independent {
+				b.WriteString(",INDEPENDENT=YES")
+			}
+			b.WriteString("\n")
+		}
+	}
+	for i, seg := range pl.segments {
+		writeHeader(seg)
+		if i >= partsFrom {
+			writeParts(seg)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		b.WriteString(seg.uri + "\n")
+	}
+	if pl.partTarget > 0 && pl.current != nil {
+		writeHeader(pl.current)
+		writeParts(pl.current)
+		if pl.preloadHint != "" {
+			fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", pl.preloadHint)
+		}
+	}
+	if pl.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.Bytes()
+}
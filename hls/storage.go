@@ -0,0 +1,58 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Storage stores playlists and segments, names are slash separated paths such as
+// "live/stream/index.m3u8"
+type Storage interface {
+	// Write creates or replaces the file with its complete content
+	Write(name string, data []byte) error
+	// Remove removes the file
+	Remove(name string) error
+}
+
+// DirStorage stores files in a local directory, which can be served by any http server
+type DirStorage struct {
+	Dir string
+}
+
+// NewDirStorage returns storage of the directory
+func NewDirStorage(dir string) *DirStorage {
+	return &DirStorage{Dir: dir}
+}
+
+// Write writes the file through a temporary file, so that readers never see partial content
+func (s *DirStorage) Write(name string, data []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Remove removes the file, it is not an error if the file does not exist
+func (s *DirStorage) Remove(name string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
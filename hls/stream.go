@@ -0,0 +1,275 @@
+package hls
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/codec"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// segmenter muxes frames of a stream into segments of a format, it writes segments and
+// playlists to the storage
+type segmenter interface {
+	// open starts a segment, which begins with a keyframe or, without video, any audio frame
+	open(seg *segment) error
+	writeVideo(data *rtmp.StreamData) error
+	writeAudio(data *rtmp.StreamData) error
+	// finish writes the current segment, which ends at dts
+	finish(seg *segment, dts int64) error
+	// end writes playlists of the ended stream
+	end() error
+	// resume continues counters of the segmenter of an ended publishing of the same path
+	resume(prev segmenter)
+}
+
+// stream is the packaging state of a stream, it cuts segments and leaves muxing to the segmenter
+type stream struct {
+	name    string
+	setting Setting
+	now     func() time.Time
+	out     segmenter
+
+	videoType   byte
+	audioType   byte
+	videoConfig []byte // payload of the last sequence header
+	audioConfig []byte
+	avc         *codec.AVCDecoderConfig
+	hevc        *codec.HEVCDecoderConfig
+	aac         *codec.AudioSpecificConfig
+
+	seg           *segment
+	lastDTS       int64
+	discontinuity bool // the next segment starts a discontinuity
+	sequence      int
+	clock         time.Time // wall clock time of clockDTS
+	clockDTS      int64
+
+	// mux guards the stream against Handler, which waits for updated to serve blocking
+	// playlist reloads
+	mux       sync.Mutex
+	updated   chan struct{}
+	playlists map[string]*playlist // media playlists by name
+	ended     bool
+}
+
+func newStream(name string, setting Setting) *stream {
+	st := &stream{
+		name:      name,
+		setting:   setting,
+		now:       time.Now,
+		updated:   make(chan struct{}),
+		playlists: make(map[string]*playlist),
+	}
+	if setting.Format == FormatCMAF {
+		st.out = newCMAFSegmenter(st)
+	} else {
+		st.out = newTSSegmenter(st)
+	}
+	return st
+}
+
+// resume continues the sequence of an ended stream of the same path, so that segments and
+// playlists of the previous publishing are not overwritten while players may still load them.
+// The first segment starts a discontinuity
+func (st *stream) resume(prev *stream) {
+	prev.mux.Lock()
+	defer prev.mux.Unlock()
+	st.sequence = prev.sequence
+	st.discontinuity = prev.sequence > 0
+	st.out.resume(prev.out)
+}
+
+func (st *stream) write(data *rtmp.StreamData) error {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if data.Discontinuity {
+		st.discontinuity = true
+	}
+	switch data.Type {
+	case rtmp.FlvVideo:
+		return st.writeVideo(data)
+	case rtmp.FlvAudio:
+		return st.writeAudio(data)
+	}
+	return nil
+}
+
+func (st *stream) writeVideo(data *rtmp.StreamData) error {
+	if data.SequenceHeader {
+		return st.setVideoConfig(data)
+	}
+	if st.videoType == 0 || len(data.Payload) == 0 {
+		return nil
+	}
+	if data.Keyframe && st.shouldCut(data.DTS) {
+		if err := st.cut(data.DTS); err != nil {
+			return err
+		}
+	}
+	if st.seg == nil {
+		if !data.Keyframe {
+			return nil // segments start with keyframes
+		}
+		if err := st.open(data.DTS); err != nil {
+			return err
+		}
+	}
+	if err := st.out.writeVideo(data); err != nil {
+		return err
+	}
+	st.setLastDTS(data.DTS)
+	return nil
+}
+
+func (st *stream) writeAudio(data *rtmp.StreamData) error {
+	if data.SequenceHeader {
+		return st.setAudioConfig(data)
+	}
+	if st.audioType == 0 || len(data.Payload) == 0 {
+		return nil
+	}
+	// streams without video are cut on any audio frame
+	if st.videoType == 0 {
+		if st.shouldCut(data.DTS) {
+			if err := st.cut(data.DTS); err != nil {
+				return err
+			}
+		}
+		if st.seg == nil {
+			if err := st.open(data.DTS); err != nil {
+				return err
+			}
+		}
+	}
+	if st.seg == nil {
+		return nil // waiting for the first keyframe
+	}
+	if err := st.out.writeAudio(data); err != nil {
+		return err
+	}
+	st.setLastDTS(data.DTS)
+	return nil
+}
+
+func (st *stream) setLastDTS(dts int64) {
+	if dts > st.lastDTS {
+		st.lastDTS = dts
+	}
+}
+
+// setVideoConfig parses decoder configuration, a changed configuration starts a discontinuity
+func (st *stream) setVideoConfig(data *rtmp.StreamData) error {
+	if bytes.Equal(data.Payload, st.videoConfig) {
+		return nil
+	}
+	switch {
+	case data.Codec == codec.FourCCAVC || data.CodecID == codec.VideoAVC:
+		c, err := codec.ParseAVCDecoderConfig(data.Payload)
+		if err != nil {
+			logging.Logger.Warnf("invalid AVC sequence header of %v: %v", st.name, err)
+			return nil
+		}
+		st.avc, st.hevc, st.videoType = c, nil, streamTypeAVC
+	case data.Codec == codec.FourCCHEVC || data.CodecID == codec.VideoHEVC:
+		c, err := codec.ParseHEVCDecoderConfig(data.Payload)
+		if err != nil {
+			logging.Logger.Warnf("invalid HEVC sequence header of %v: %v", st.name, err)
+			return nil
+		}
+		st.avc, st.hevc, st.videoType = nil, c, streamTypeHEVC
+	default:
+		logging.Logger.Warnf("video codec %v of %v is not supported by hls", data.Codec, st.name)
+		return nil
+	}
+	if st.videoConfig != nil {
+		st.discontinuity = true
+	}
+	st.videoConfig = append([]byte(nil), data.Payload...)
+	return nil
+}
+
+func (st *stream) setAudioConfig(data *rtmp.StreamData) error {
+	if bytes.Equal(data.Payload, st.audioConfig) {
+		return nil
+	}
+	if data.Codec != codec.FourCCAAC && data.CodecID != codec.AudioAAC {
+		logging.Logger.Warnf("audio codec %v of %v is not supported by hls", data.Codec, st.name)
+		return nil
+	}
+	c, err := codec.ParseAudioSpecificConfig(data.Payload)
+	if err != nil {
+		logging.Logger.Warnf("invalid AAC sequence header of %v: %v", st.name, err)
+		return nil
+	}
+	if st.audioConfig != nil {
+		st.discontinuity = true
+	}
+	st.aac, st.audioType = c, streamTypeAAC
+	st.audioConfig = append([]byte(nil), data.Payload...)
+	return nil
+}
+
+func (st *stream) shouldCut(dts int64) bool {
+	if st.seg == nil {
+		return false
+	}
+	return st.discontinuity || time.Duration(dts-st.seg.start)*time.Millisecond >= st.setting.TargetDuration
+}
+
+// open starts a segment at dts
+func (st *stream) open(dts int64) error {
+	if st.sequence == 0 || st.discontinuity {
+		st.clock, st.clockDTS = st.now(), dts
+	}
+	st.seg = &segment{
+		sequence:      st.sequence,
+		start:         dts,
+		time:          st.clock.Add(time.Duration(dts-st.clockDTS) * time.Millisecond),
+		discontinuity: st.discontinuity && st.sequence > 0,
+	}
+	st.sequence++
+	st.discontinuity = false
+	return st.out.open(st.seg)
+}
+
+// cut finishes the current segment at dts, which is the start of the next segment
+func (st *stream) cut(dts int64) error {
+	seg := st.seg
+	seg.duration = time.Duration(dts-seg.start) * time.Millisecond
+	st.seg = nil
+	return st.out.finish(seg, dts)
+}
+
+// close writes the last segment and ends the playlists
+func (st *stream) close() error {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	return st.end()
+}
+
+// end is close of a locked stream
+func (st *stream) end() error {
+	if st.seg != nil {
+		if err := st.cut(st.lastDTS); err != nil {
+			return err
+		}
+	}
+	st.ended = true
+	defer st.notify()
+	return st.out.end()
+}
+
+// writePlaylist writes a media playlist to the storage and wakes up blocking reloads
+func (st *stream) writePlaylist(name string, pl *playlist) error {
+	st.playlists[name] = pl
+	defer st.notify()
+	return st.setting.Storage.Write(st.name+"/"+name, pl.encode())
+}
+
+func (st *stream) notify() {
+	close(st.updated)
+	st.updated = make(chan struct{})
+}
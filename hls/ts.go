@@ -0,0 +1,238 @@
+package hls
+
+import (
+	"bytes"
+)
+
+const tsPacketSize = 188
+
+// PIDs of the single program transport stream
+const (
+	pidPAT   = 0x0000
+	pidPMT   = 0x1000
+	pidVideo = 0x0100
+	pidAudio = 0x0101
+)
+
+// stream types of PMT, ISO/IEC 13818-1 Table 2-34
+const (
+	streamTypeAAC  = 0x0F
+	streamTypeAVC  = 0x1B
+	streamTypeHEVC = 0x24
+)
+
+// PES stream ids
+const (
+	streamIDVideo = 0xE0
+	streamIDAudio = 0xC0
+)
+
+// tsMuxer writes MPEG-TS packets of one program with up to one video and one audio stream
+// into a buffer, a muxer is used for a single segment
+type tsMuxer struct {
+	buf       bytes.Buffer
+	cc        map[uint16]byte // continuity counters
+	videoType byte            // 0 if there is no video
+	audioType byte            // 0 if there is no audio
+}
+
+func newTSMuxer(videoType byte, audioType byte, cc map[uint16]byte) *tsMuxer {
+	if cc == nil {
+		cc = make(map[uint16]byte)
+	}
+	return &tsMuxer{
+		cc:        cc,
+		videoType: videoType,
+		audioType: audioType,
+	}
+}
+
+// pcrPID returns PID carrying PCR, which is video if any
+func (m *tsMuxer) pcrPID() uint16 {
+	if m.videoType != 0 {
+		return pidVideo
+	}
+	return pidAudio
+}
+
+// writeTables writes PAT and PMT, they start every segment so that segments decode on their own
+func (m *tsMuxer) writeTables() {
+	pat := []byte{
+		0x00,       // table id
+		0xB0, 0x0D, // section syntax, section length
+		0x00, 0x01, // transport stream id
+		0xC1,       // version 0, current
+		0x00, 0x00, // section number, last section number
+		0x00, 0x01, // program number
+		0xE0 | byte(pidPMT>>8), byte(pidPMT & 0xFF),
+	}
+	m.writeSection(pidPAT, pat)
+
+	pcr := m.pcrPID()
+	pmt := []byte{
+		0x02,       // table id
+		0xB0, 0x00, // section syntax, section length set below
+		0x00, 0x01, // program number
+		0xC1,       // version 0, current
+		0x00, 0x00, // section number, last section number
+		0xE0 | byte(pcr>>8), byte(pcr),
+		0xF0, 0x00, // program info length
+	}
+	if m.videoType != 0 {
+		pmt = append(pmt, m.videoType, 0xE0|byte(pidVideo>>8), byte(pidVideo&0xFF), 0xF0, 0x00)
+	}
+	if m.audioType != 0 {
+		pmt = append(pmt, m.audioType, 0xE0|byte(pidAudio>>8), byte(pidAudio&0xFF), 0xF0, 0x00)
+	}
+	length := len(pmt) - 3 + 4 // after section length, including CRC
+	pmt[1] |= byte(length >> 8)
+	pmt[2] = byte(length)
+	m.writeSection(pidPMT, pmt)
+}
+
+// writeSection writes a PSI section with CRC in a single packet
+func (m *tsMuxer) writeSection(pid uint16, section []byte) {
+	crc := crc32MPEG2(section)
+	pkt := make([]byte, 0, tsPacketSize)
+	pkt = append(pkt, 0x47, 0x40|byte(pid>>8)&0x1F, byte(pid), 0x10|m.nextCC(pid))
+	pkt = append(pkt, 0x00) // pointer field
+	pkt = append(pkt, section...)
+	pkt = append(pkt, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xFF)
+	}
+	m.buf.Write(pkt)
+}
+
+// writePES writes an access unit as PES, timestamps are in 90kHz units. PCR is written on
+// the first packet of PCR PID, random access is signaled for keyframes
+func (m *tsMuxer) writePES(pid uint16, streamID byte, pts int64, dts int64, keyframe bool, payload []byte) {
+	header := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80}
+	if pts != dts {
+		header = append(header, 0xC0, 10)
+		header = append(header, encodeTimestamp(0x03, pts)...)
+		header = append(header, encodeTimestamp(0x01, dts)...)
+	} else {
+		header = append(header, 0x80, 5)
+		header = append(header, encodeTimestamp(0x02, pts)...)
+	}
+	// packet length is left 0 for video exceeding 16 bits
+	if length := len(header) - 6 + len(payload); length <= 0xFFFF {
+		header[4], header[5] = byte(length>>8), byte(length)
+	}
+
+	pcr := int64(-1)
+	if pid == m.pcrPID() {
+		pcr = dts
+	}
+	data := append(header, payload...)
+	for first := true; first || len(data) > 0; first = false {
+		// adaptation field content after its length byte
+		var af []byte
+		hasAF := false
+		if first && (keyframe || pcr >= 0) {
+			hasAF = true
+			flags := byte(0)
+			if keyframe {
+				flags |= 0x40 // random access indicator
+			}
+			if pcr >= 0 {
+				flags |= 0x10
+			}
+			af = append(af, flags)
+			if pcr >= 0 {
+				af = append(af, encodePCR(pcr)...)
+			}
+		}
+		space := tsPacketSize - 4
+		if hasAF {
+			space -= 1 + len(af)
+		}
+		if stuffing := space - len(data); stuffing > 0 {
+			if !hasAF {
+				hasAF = true
+				stuffing-- // length byte
+				if stuffing > 0 {
+					af = append(af, 0x00)
+					stuffing--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				af = append(af, 0xFF)
+			}
+		}
+
+		pkt := make([]byte, 0, tsPacketSize)
+		start := byte(0)
+		if first {
+			start = 0x40 // payload unit start indicator
+		}
+		control := byte(0x10)
+		if hasAF {
+			control = 0x30
+		}
+		pkt = append(pkt, 0x47, start|byte(pid>>8)&0x1F, byte(pid), control|m.nextCC(pid))
+		if hasAF {
+			pkt = append(pkt, byte(len(af)))
+			pkt = append(pkt, af...)
+		}
+		n := tsPacketSize - len(pkt)
+		pkt = append(pkt, data[:n]...)
+		data = data[n:]
+		m.buf.Write(pkt)
+	}
+}
+
+func (m *tsMuxer) nextCC(pid uint16) byte {
+	cc := m.cc[pid]
+	m.cc[pid] = (cc + 1) & 0x0F
+	return cc
+}
+
+// encodeTimestamp encodes 33 bits PTS or DTS with the 4 bits prefix
+func encodeTimestamp(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 0x01,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 0x01,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 0x01,
+	}
+}
+
+// encodePCR encodes PCR with base in 90kHz and extension 0
+func encodePCR(base int64) []byte {
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base&0x01)<<7 | 0x7E,
+		0x00,
+	}
+}
+
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc32MPEG2 is the CRC of PSI sections, ISO/IEC 13818-1 Annex A
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
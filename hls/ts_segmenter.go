@@ -0,0 +1,140 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// tsSegmenter muxes streams into MPEG-TS segments of a single media playlist
+type tsSegmenter struct {
+	st       *stream
+	muxer    *tsMuxer
+	cc       map[uint16]byte
+	playlist playlist
+	expired  []*segment
+}
+
+func newTSSegmenter(st *stream) *tsSegmenter {
+	return &tsSegmenter{
+		st:       st,
+		cc:       make(map[uint16]byte),
+		playlist: playlist{version: 3, window: st.setting.WindowSize, event: st.setting.Event},
+	}
+}
+
+// open starts a segment with PAT and PMT
+func (t *tsSegmenter) open(seg *segment) error {
+	t.muxer = newTSMuxer(t.st.videoType, t.st.audioType, t.cc)
+	t.muxer.writeTables()
+	seg.uri = fmt.Sprintf("%d.ts", seg.sequence)
+	seg.name = t.st.name + "/" + seg.uri
+	return nil
+}
+
+func (t *tsSegmenter) writeVideo(data *rtmp.StreamData) error {
+	au, err := t.accessUnit(data)
+	if err != nil {
+		logging.Logger.Warnf("invalid video of %v: %v", t.st.name, err)
+		return nil
+	}
+	t.muxer.writePES(pidVideo, streamIDVideo, data.PTS*90, data.DTS*90, data.Keyframe, au)
+	return nil
+}
+
+func (t *tsSegmenter) writeAudio(data *rtmp.StreamData) error {
+	header, err := t.st.aac.ADTSHeader(len(data.Payload))
+	if err != nil {
+		logging.Logger.Warnf("invalid audio of %v: %v", t.st.name, err)
+		return nil
+	}
+	frame := append(header, data.Payload...)
+	t.muxer.writePES(pidAudio, streamIDAudio, data.DTS*90, data.DTS*90, t.st.videoType == 0, frame)
+	return nil
+}
+
+func (t *tsSegmenter) finish(seg *segment, dts int64) error {
+	data := t.muxer.buf.Bytes()
+	t.muxer = nil
+
+	storage := t.st.setting.Storage
+	if err := storage.Write(seg.name, data); err != nil {
+		return err
+	}
+	t.expired = append(t.expired, t.playlist.add(seg)...)
+	for len(t.expired) > keepExpired {
+		if err := storage.Remove(t.expired[0].name); err != nil {
+			logging.Logger.Warnf("failed to remove %v: %v", t.expired[0].name, err)
+		}
+		t.expired = t.expired[1:]
+	}
+	return t.st.writePlaylist(playlistName, &t.playlist)
+}
+
+func (t *tsSegmenter) resume(prev segmenter) {
+	if p, ok := prev.(*tsSegmenter); ok {
+		t.playlist.resume(&p.playlist)
+		t.expired = p.expired
+	}
+}
+
+func (t *tsSegmenter) end() error {
+	if len(t.playlist.segments) == 0 {
+		return nil
+	}
+	t.playlist.ended = true
+	return t.st.writePlaylist(playlistName, &t.playlist)
+}
+
+var errInvalidNALU = errors.New("invalid NAL unit length")
+
+// accessUnit converts length prefixed NAL units of a frame to Annex B, with access unit
+// delimiter and parameter sets before keyframes as HLS requires
+func (t *tsSegmenter) accessUnit(data *rtmp.StreamData) ([]byte, error) {
+	st := t.st
+	startCode := []byte{0x00, 0x00, 0x00, 0x01}
+	var lengthSize int
+	var params [][]byte
+	var aud []byte
+	var isAUD func(nalu []byte) bool
+	if st.videoType == streamTypeHEVC {
+		lengthSize = st.hevc.NALULengthSize
+		params = append(append(append(params, st.hevc.VPS...), st.hevc.SPS...), st.hevc.PPS...)
+		aud = []byte{0x46, 0x01, 0x50}
+		isAUD = func(nalu []byte) bool { return nalu[0]>>1&0x3F == 35 }
+	} else {
+		lengthSize = st.avc.NALULengthSize
+		params = append(append(params, st.avc.SPS...), st.avc.PPS...)
+		aud = []byte{0x09, 0xF0}
+		isAUD = func(nalu []byte) bool { return nalu[0]&0x1F == 9 }
+	}
+
+	au := make([]byte, 0, len(data.Payload)+64)
+	au = append(append(au, startCode...), aud...)
+	if data.Keyframe {
+		for _, p := range params {
+			au = append(append(au, startCode...), p...)
+		}
+	}
+	payload := data.Payload
+	for len(payload) > 0 {
+		if len(payload) < lengthSize {
+			return nil, errInvalidNALU
+		}
+		size := 0
+		for _, b := range payload[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+		payload = payload[lengthSize:]
+		if size == 0 || size > len(payload) {
+			return nil, errInvalidNALU
+		}
+		if nalu := payload[:size]; !isAUD(nalu) {
+			au = append(append(au, startCode...), nalu...)
+		}
+		payload = payload[size:]
+	}
+	return au, nil
+}
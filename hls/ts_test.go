@@ -0,0 +1,90 @@
+package hls
+
+import (
+	"bytes"
+	"testing"
+)
+
+// demuxPES returns PES packets of the PID, it checks sync bytes and continuity counters
+func demuxPES(t *testing.T, data []byte, pid uint16) [][]byte {
+	if len(data)%tsPacketSize != 0 {
+		t.Fatalf("unexpected size %v", len(data))
+	}
+	packets := make([][]byte, 0)
+	cc := -1
+	for ; len(data) > 0; data = data[tsPacketSize:] {
+		pkt := data[:tsPacketSize]
+		if pkt[0] != 0x47 {
+			t.Fatal("invalid sync byte")
+		}
+		if uint16(pkt[1]&0x1F)<<8|uint16(pkt[2]) != pid {
+			continue
+		}
+		if cc >= 0 && int(pkt[3]&0x0F) != (cc+1)&0x0F {
+			t.Errorf("discontinuous counter %v after %v", pkt[3]&0x0F, cc)
+		}
+		cc = int(pkt[3] & 0x0F)
+		payload := pkt[4:]
+		if pkt[3]&0x20 != 0 {
+			payload = payload[1+int(payload[0]):]
+		}
+		if pkt[1]&0x40 != 0 {
+			packets = append(packets, nil)
+		}
+		if len(packets) > 0 {
+			packets[len(packets)-1] = append(packets[len(packets)-1], payload...)
+		}
+	}
+	return packets
+}
+
+func decodeTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func Test_TSMuxer(t *testing.T) {
+	m := newTSMuxer(streamTypeAVC, streamTypeAAC, nil)
+	m.writeTables()
+	video := bytes.Repeat([]byte{0xAB}, 1000)
+	m.writePES(pidVideo, streamIDVideo, 0x1FFFFFFFF, 0x100000000, true, video)
+	m.writePES(pidVideo, streamIDVideo, 3600, 3600, false, video[:183])
+	m.writePES(pidAudio, streamIDAudio, 1800, 1800, false, []byte{0xFF, 0xF1, 0xCC})
+	data := m.buf.Bytes()
+
+	for _, pid := range []uint16{pidPAT, pidPMT} {
+		sections := demuxPES(t, data, pid)
+		if len(sections) != 1 {
+			t.Fatalf("expect one section of pid %v", pid)
+		}
+		section := sections[0][1:] // pointer field
+		length := int(section[1]&0x0F)<<8 | int(section[2])
+		if crc32MPEG2(section[:3+length]) != 0 {
+			t.Errorf("invalid CRC of pid %v", pid)
+		}
+		if pid == pidPMT && (section[12] != streamTypeAVC || section[17] != streamTypeAAC) {
+			t.Errorf("unexpected PMT %x", section[:3+length])
+		}
+	}
+
+	pes := demuxPES(t, data, pidVideo)
+	if len(pes) != 2 {
+		t.Fatalf("expect 2 video PES, got %v", len(pes))
+	}
+	if !bytes.Equal(pes[0][:4], []byte{0x00, 0x00, 0x01, 0xE0}) || pes[0][7] != 0xC0 ||
+		decodeTimestamp(pes[0][9:]) != 0x1FFFFFFFF || decodeTimestamp(pes[0][14:]) != 0x100000000 ||
+		!bytes.Equal(pes[0][19:], video) {
+		t.Errorf("unexpected video PES %x", pes[0][:19])
+	}
+	if pes[1][7] != 0x80 || decodeTimestamp(pes[1][9:]) != 3600 || !bytes.Equal(pes[1][14:], video[:183]) {
+		t.Errorf("unexpected video PES %x", pes[1][:14])
+	}
+	// PCR and random access of the keyframe
+	if data[2*tsPacketSize+5] != 0x50 {
+		t.Errorf("unexpected adaptation field %x", data[2*tsPacketSize:2*tsPacketSize+12])
+	}
+
+	audio := demuxPES(t, data, pidAudio)
+	if len(audio) != 1 || int(audio[0][4])<<8|int(audio[0][5]) != 3+5+3 || !bytes.Equal(audio[0][14:], []byte{0xFF, 0xF1, 0xCC}) {
+		t.Errorf("unexpected audio PES %x", audio)
+	}
+}
@@ -0,0 +1,177 @@
+package rtmp
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/junli1026/gortmp/logging"
+)
+
+// FlvSetting is the setting of FlvHandler
+type FlvSetting struct {
+	WriteTimeout time.Duration // clients not accepting data within the timeout are disconnected, default 10 seconds
+	AllowOrigin  string        // Access-Control-Allow-Origin of responses for players on other origins, empty omits it
+}
+
+const defaultFlvWriteTimeout = 10 * time.Second
+
+// FlvHandler is an http.Handler playing live streams of the hub as FLV, for web players such as
+// flv.js and mpegts.js. A request of "/{app}/{stream}.flv" gets the FLV header, metadata, sequence
+// headers and cached GOP, followed by the same FLV tags StreamData.Data carries, over HTTP chunked
+// transfer, or over WebSocket binary messages for upgrade requests. Like other hub subscribers,
+// slow clients skip frames until the next keyframe instead of blocking the publisher, a client
+// which does not accept data within WriteTimeout is disconnected. The response ends when the
+// stream is unpublished
+type FlvHandler struct {
+	hub     *StreamHub
+	setting FlvSetting
+}
+
+// NewFlvHandler returns a handler of live streams of the hub, usually the hub of RtmpServer
+func NewFlvHandler(hub *StreamHub, setting *FlvSetting) *FlvHandler {
+	h := &FlvHandler{
+		hub:     hub,
+		setting: *setting,
+	}
+	if h.setting.WriteTimeout <= 0 {
+		h.setting.WriteTimeout = defaultFlvWriteTimeout
+	}
+	return h
+}
+
+// flvOutput is the transport of FlvHandler
+type flvOutput interface {
+	write(data []byte) error
+	flush() error
+	done() <-chan struct{} // closed when the client is gone
+}
+
+func (h *FlvHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app, name, ok := flvStreamName(r.URL.Path)
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	sub := h.hub.Subscribe(app, name)
+	defer sub.Close()
+	if !sub.Live() {
+		http.NotFound(w, r)
+		return
+	}
+
+	var out flvOutput
+	if isWebSocketUpgrade(r) {
+		ws, err := upgradeWebSocket(w, r, h.setting.WriteTimeout)
+		if err != nil {
+			logging.Logger.Warnf("failed to upgrade websocket of %v: %v", sub.Key(), err)
+			return
+		}
+		defer ws.close()
+		out = ws
+	} else {
+		if h.setting.AllowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", h.setting.AllowOrigin)
+		}
+		w.Header().Set("Content-Type", "video/x-flv")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		out = &httpFlvOutput{w: w, timeout: h.setting.WriteTimeout, ctxDone: r.Context().Done()}
+	}
+	if err := h.play(sub, out); err != nil {
+		logging.Logger.Infof("flv player of %v stopped: %v", sub.Key(), err)
+	}
+}
+
+// flvStreamName returns app and stream name of "/{app}/{stream}.flv", app may contain "/"
+func flvStreamName(urlPath string) (string, string, bool) {
+	dir, file := path.Split(path.Clean("/" + urlPath))
+	app := strings.Trim(dir, "/")
+	name := strings.TrimSuffix(file, ".flv")
+	if app == "" || name == "" || name == file {
+		return "", "", false
+	}
+	return app, name, true
+}
+
+// play writes events of the subscriber until the stream is unpublished or the client is gone
+func (h *FlvHandler) play(sub *Subscriber, out flvOutput) error {
+	headerWritten := false
+	for {
+		var event *StreamEvent
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			event = e
+		case <-out.done():
+			return nil
+		}
+		if event.Type == StreamUnpublished {
+			return nil
+		}
+		if event.Type != StreamPacket {
+			continue
+		}
+
+		data := event.Data
+		if data.Type == FlvHeader {
+			if headerWritten {
+				continue
+			}
+		} else if !headerWritten {
+			// the header was not cached, e.g. subscribing right after publishing
+			if err := out.write(flvHeader(true, true)); err != nil {
+				return err
+			}
+		}
+		headerWritten = true
+		if err := out.write(data.Data); err != nil {
+			return err
+		}
+		// flush once the queue is drained, so that a backlog goes out in fewer writes
+		if len(sub.C) == 0 {
+			if err := out.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// httpFlvOutput writes flv over HTTP chunked transfer
+type httpFlvOutput struct {
+	w       http.ResponseWriter
+	timeout time.Duration
+	ctxDone <-chan struct{}
+}
+
+// writeDeadliner is implemented by http.ResponseWriter of Go 1.20 and later
+type writeDeadliner interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+func (o *httpFlvOutput) setDeadline() {
+	if d, ok := o.w.(writeDeadliner); ok {
+		d.SetWriteDeadline(time.Now().Add(o.timeout))
+	}
+}
+
+func (o *httpFlvOutput) write(data []byte) error {
+	o.setDeadline()
+	_, err := o.w.Write(data)
+	return err
+}
+
+func (o *httpFlvOutput) flush() error {
+	if f, ok := o.w.(http.Flusher); ok {
+		o.setDeadline()
+		f.Flush()
+	}
+	return nil
+}
+
+func (o *httpFlvOutput) done() <-chan struct{} {
+	return o.ctxDone
+}
@@ -0,0 +1,120 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func publishFlvTest(t *testing.T, hub *StreamHub) (*hubStream, []byte) {
+	st, err := hub.publish("live", &StreamMeta{streamName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []*StreamData{
+		{Type: FlvHeader, Data: flvHeader(false, true)},
+		newTagData(FlvVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		newTagData(FlvVideo, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA}),
+		newTagData(FlvVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB}),
+	}
+	var expected []byte
+	for _, data := range input {
+		st.write(data)
+		expected = append(expected, data.Data...)
+	}
+	return st, expected
+}
+
+func Test_HttpFlv(t *testing.T) {
+	hub := newStreamHub()
+	server := httptest.NewServer(NewFlvHandler(hub, &FlvSetting{AllowOrigin: "*"}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/live/test.flv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status %v of an unpublished stream", resp.StatusCode)
+	}
+
+	st, expected := publishFlvTest(t, hub)
+	if resp, err = http.Get(server.URL + "/live/test.flv"); err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "video/x-flv" ||
+		resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("unexpected response %v %v", resp.StatusCode, resp.Header)
+	}
+	received := make([]byte, len(expected))
+	if _, err := io.ReadFull(resp.Body, received); err != nil || !bytes.Equal(received, expected) {
+		t.Fatalf("unexpected flv %x, %v", received, err)
+	}
+
+	// live tags follow the cache, the response ends when the stream is unpublished
+	live := newTagData(FlvVideo, 80, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xCC})
+	st.write(live)
+	st.unpublish()
+	rest, err := ioutil.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(rest, live.Data) {
+		t.Errorf("unexpected flv %x, %v", rest, err)
+	}
+}
+
+func Test_WebSocketFlv(t *testing.T) {
+	hub := newStreamHub()
+	server := httptest.NewServer(NewFlvHandler(hub, &FlvSetting{}))
+	defer server.Close()
+	st, expected := publishFlvTest(t, hub)
+	defer st.unpublish()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /live/test.flv HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the example of RFC 6455 1.3
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake %v %v", resp.StatusCode, resp.Header)
+	}
+
+	var received []byte
+	for len(received) < len(expected) {
+		opcode, payload, err := readWebSocketFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opcode != wsOpBinary {
+			t.Fatalf("unexpected opcode %v", opcode)
+		}
+		received = append(received, payload...)
+	}
+	if !bytes.Equal(received, expected) {
+		t.Errorf("unexpected flv %x", received)
+	}
+
+	// masked ping and close of the client
+	conn.Write([]byte{0x89, 0x82, 0x01, 0x02, 0x03, 0x04, 'h' ^ 0x01, 'i' ^ 0x02})
+	if opcode, payload, err := readWebSocketFrame(r); err != nil || opcode != wsOpPong || string(payload) != "hi" {
+		t.Errorf("unexpected pong %v %q %v", opcode, payload, err)
+	}
+	conn.Write([]byte{0x88, 0x82, 0x00, 0x00, 0x00, 0x00, 0x03, 0xE8})
+	if opcode, payload, err := readWebSocketFrame(r); err != nil || opcode != wsOpClose || !bytes.Equal(payload, []byte{0x03, 0xE8}) {
+		t.Errorf("unexpected close %v %x %v", opcode, payload, err)
+	}
+}
@@ -0,0 +1,42 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+
+	utils "github.com/junli1026/gortmp/utils"
+)
+
+// AbortMessage tells the peer to discard the partially received message of a chunk stream
+type AbortMessage struct {
+	messageHeader
+	AbortChunkStreamID uint32
+}
+
+func NewAbortMessage(chunkStreamID uint32) *AbortMessage {
+	m := &AbortMessage{}
+	m.StreamID = 0
+	m.ChunkStreamID = 2
+	m.MsgType = 2
+	m.AbortChunkStreamID = chunkStreamID
+	return m
+}
+
+func (msg AbortMessage) toRaw() (*RawMessage, error) {
+	raw := &RawMessage{
+		messageHeader: msg.messageHeader,
+		Raw:           make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(raw.Raw, msg.AbortChunkStreamID)
+	return raw, nil
+}
+
+func deserializeAbort(msg *RawMessage) (Message, error) {
+	if len(msg.Raw) < 4 {
+		return nil, errors.New("abort message too short")
+	}
+	m := &AbortMessage{}
+	m.messageHeader = msg.messageHeader
+	m.AbortChunkStreamID = utils.ReadUint32(msg.Raw[0:4])
+	return m, nil
+}
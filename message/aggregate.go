@@ -0,0 +1,118 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	utils "github.com/junli1026/gortmp/utils"
+)
+
+const flvTagHeaderSize = 11
+
+// AggregateMessage bundles FLV tags of a stream. Timestamps of the embedded tags are rebased,
+// so that the first one has the timestamp of the aggregate message itself
+type AggregateMessage struct {
+	messageHeader
+	Messages []Message
+}
+
+// NewAggregateMessage bundles audio, video and data messages, they should belong to the same stream
+func NewAggregateMessage(streamID int, timestamp uint32, msgs ...Message) *AggregateMessage {
+	m := &AggregateMessage{}
+	m.MsgType = 22
+	m.StreamID = streamID
+	m.ChunkStreamID = 6
+	m.Timestamp = timestamp
+	m.Messages = msgs
+	return m
+}
+
+func (msg AggregateMessage) toRaw() (*RawMessage, error) {
+	raw := &RawMessage{
+		messageHeader: msg.messageHeader,
+	}
+	data := make([]byte, 0)
+	for _, sub := range msg.Messages {
+		subRaw, err := sub.toRaw()
+		if err != nil {
+			return nil, err
+		}
+		switch subRaw.MsgType {
+		case 8, 9, 18:
+		default:
+			return nil, fmt.Errorf("msg type %v can not be aggregated", subRaw.MsgType)
+		}
+		l := len(subRaw.Raw)
+		ts := subRaw.Timestamp
+		data = append(data,
+			subRaw.MsgType,
+			byte(l>>16), byte(l>>8), byte(l),
+			byte(ts>>16), byte(ts>>8), byte(ts), byte(ts>>24),
+			0x00, 0x00, 0x00,
+		)
+		data = append(data, subRaw.Raw...)
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], uint32(flvTagHeaderSize+l))
+	}
+	raw.Raw = data
+	return raw, nil
+}
+
+func deserializeAggregate(msg *RawMessage) (Message, error) {
+	m := &AggregateMessage{}
+	m.messageHeader = msg.messageHeader
+	m.Messages = make([]Message, 0)
+
+	var base uint32
+	data := msg.Raw
+	for len(data) > 0 {
+		if len(data) < flvTagHeaderSize {
+			return nil, fmt.Errorf("truncated tag header in aggregate message")
+		}
+		tagType := data[0]
+		size := int(utils.ReadUint32(data[1:4]))
+		ts := utils.ReadUint32(data[4:7]) | uint32(data[7])<<24
+		if len(data) < flvTagHeaderSize+size {
+			return nil, fmt.Errorf("truncated tag body in aggregate message")
+		}
+		if len(m.Messages) == 0 {
+			base = ts
+		}
+
+		sub := &RawMessage{
+			Raw: data[flvTagHeaderSize : flvTagHeaderSize+size],
+		}
+		sub.MsgType = tagType
+		sub.StreamID = msg.StreamID
+		sub.ChunkStreamID = msg.ChunkStreamID
+		sub.Timestamp = msg.Timestamp + ts - base
+
+		var (
+			s   Message
+			err error
+		)
+		switch tagType {
+		case 8:
+			s, err = deserializeAudioMessage(sub)
+		case 9:
+			s, err = deserializeVideoMessage(sub)
+		case 18:
+			s, err = deserializeDataMessage(sub)
+		default:
+			return nil, fmt.Errorf("unexpected tag type %v in aggregate message", tagType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.Messages = append(m.Messages, s)
+
+		// skip back pointer, some muxers omit the last one
+		data = data[flvTagHeaderSize+size:]
+		if len(data) >= 4 {
+			data = data[4:]
+		} else {
+			data = data[len(data):]
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,47 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Aggregate(t *testing.T) {
+	msgs := []Message{
+		NewVideoMessage(1, 1000, []byte{0x17, 0x01, 0x00, 0x00, 0x00}),
+		NewAudioMessage(1, 1010, []byte{0xAF, 0x01, 0x21}),
+		NewVideoMessage(1, 1040, []byte{0x27, 0x01, 0x00, 0x00, 0x00}),
+	}
+	raw, err := NewAggregateMessage(1, 1000, msgs...).toRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// timestamps are rebased on the timestamp of the aggregate message
+	raw.Timestamp = 5000
+	m, err := Deserialize(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.(*AggregateMessage).Messages
+	if len(got) != len(msgs) {
+		t.Fatalf("expect %v messages, got %v", len(msgs), len(got))
+	}
+	for i, msg := range got {
+		want, _ := msgs[i].toRaw()
+		have, _ := msg.toRaw()
+		if have.MsgType != want.MsgType || have.StreamID != 1 ||
+			have.Timestamp != want.Timestamp+4000 || !bytes.Equal(have.Raw, want.Raw) {
+			t.Errorf("message %v mismatch", i)
+		}
+	}
+
+	if _, ok := got[1].(*AudioMessage); !ok {
+		t.Fail()
+	}
+
+	// truncated aggregate is rejected
+	raw.Raw = raw.Raw[:25]
+	if _, err := Deserialize(raw); err == nil {
+		t.Fail()
+	}
+}
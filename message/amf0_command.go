@@ -6,6 +6,8 @@ import (
 	"reflect"
 )
 
+// Amf0CommandMessage is a command message. AMF3 command message (type 17) shares the same
+// structure, its payload is AMF0 switching to AMF3 by avmplus-object marker for complex values
 type Amf0CommandMessage struct {
 	messageHeader
 	Name          string
@@ -50,6 +52,9 @@ func (msg Amf0CommandMessage) toRaw() (raw *RawMessage, err error) {
 	if raw.Raw, err = serializeAMF0(objects); err != nil {
 		return nil, err
 	}
+	if msg.MsgType == 17 {
+		raw.Raw = append([]byte{0x00}, raw.Raw...) // format selector
+	}
 	return raw, nil
 }
 
@@ -91,7 +96,11 @@ func validateCommand(arr []interface{}) (bool, error) {
 }
 
 func deserializeAmf0CommandMessage(raw *RawMessage) (Message, error) {
-	arr, err := deserializeAMF0(raw.Raw)
+	data := raw.Raw
+	if raw.MsgType == 17 && len(data) > 0 {
+		data = data[1:] // skip format selector
+	}
+	arr, err := deserializeAMF0(data)
 	if valid, err := validateCommand(arr); !valid {
 		return nil, err
 	}
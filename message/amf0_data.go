@@ -1,5 +1,7 @@
 package message
 
+// Amf0DataMessage is a data message. AMF3 data message (type 15) shares the same structure,
+// Raw holds the payload without its format selector
 type Amf0DataMessage struct {
 	messageHeader
 	CommandName  string
@@ -8,29 +10,47 @@ type Amf0DataMessage struct {
 	Raw          []byte
 }
 
+// NewAmf0DataMessage creates a data message from an already encoded AMF0 payload
+func NewAmf0DataMessage(streamID int, timestamp uint32, data []byte) *Amf0DataMessage {
+	m := &Amf0DataMessage{}
+	m.MsgType = 18
+	m.StreamID = streamID
+	m.ChunkStreamID = 5
+	m.Timestamp = timestamp
+	m.Raw = data
+	return m
+}
+
 func (msg Amf0DataMessage) toRaw() (*RawMessage, error) {
 	raw := &RawMessage{}
 	raw.messageHeader = msg.messageHeader
 	raw.Raw = msg.Raw
+	if msg.MsgType == 15 {
+		raw.Raw = append([]byte{0x00}, msg.Raw...) // format selector
+	}
 	return raw, nil
 }
 
 func deserializeDataMessage(msg *RawMessage) (Message, error) {
-	arr, err := deserializeAMF0(msg.Raw)
+	data := msg.Raw
+	if msg.MsgType == 15 && len(data) > 0 {
+		data = data[1:] // skip format selector
+	}
+	arr, err := deserializeAMF0(data)
 	if err != nil {
 		return nil, err
 	}
 	m := &Amf0DataMessage{}
 	m.messageHeader = msg.messageHeader
 	if len(arr) >= 1 {
-		m.CommandName = arr[0].(string)
+		m.CommandName, _ = arr[0].(string)
 	}
 	if len(arr) >= 2 {
-		m.CallbackName = arr[1].(string)
+		m.CallbackName, _ = arr[1].(string)
 	}
 	if len(arr) >= 3 {
-		m.Parameters = arr[2].(map[string]interface{})
+		m.Parameters, _ = arr[2].(map[string]interface{})
 	}
-	m.Raw = msg.Raw
+	m.Raw = data
 	return m, nil
 }
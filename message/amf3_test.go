@@ -0,0 +1,32 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_amf3Command(t *testing.T) {
+	cmd := NewAmf0CommandMessage("connect", 1)
+	cmd.MsgType = 17
+	cmd.SetCommandObject(map[string]interface{}{"app": "live", "objectEncoding": float64(3)})
+	cmd.AddOther([]byte{1, 2}) // AMF3 only value, written after avmplus-object marker
+
+	raw, err := cmd.toRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Raw[0] != 0x00 {
+		t.Fail()
+	}
+	m, err := Deserialize(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.(*Amf0CommandMessage)
+	if got.Name != "connect" || got.GetType() != 17 || !bytes.Equal(got.Others[0].([]byte), []byte{1, 2}) {
+		t.Fail()
+	}
+	if got.CommandObject.(map[string]interface{})["objectEncoding"] != float64(3) {
+		t.Fail()
+	}
+}
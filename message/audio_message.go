@@ -4,6 +4,17 @@ type AudioMessage struct {
 	RawMessage
 }
 
+// NewAudioMessage creates an audio message carrying a FLV audio tag body
+func NewAudioMessage(streamID int, timestamp uint32, data []byte) *AudioMessage {
+	m := &AudioMessage{}
+	m.MsgType = 8
+	m.StreamID = streamID
+	m.ChunkStreamID = 4
+	m.Timestamp = timestamp
+	m.Raw = data
+	return m
+}
+
 func (msg AudioMessage) toRaw() (*RawMessage, error) {
 	return &msg.RawMessage, nil
 }
@@ -51,13 +51,18 @@ var deserializerList [23]deserializer
 
 func init() {
 	deserializerList[1] = deserializeSetChunkSize
+	deserializerList[2] = deserializeAbort
 	deserializerList[3] = deserializeAcknowledgement
+	deserializerList[4] = deserializeUserControl
 	deserializerList[5] = deserializeAckWindowSize
 	deserializerList[6] = deserializeSetPeerBandwidth
 	deserializerList[8] = deserializeAudioMessage
 	deserializerList[9] = deserializeVideoMessage
+	deserializerList[15] = deserializeDataMessage
+	deserializerList[17] = deserializeAmf0CommandMessage
 	deserializerList[18] = deserializeDataMessage
 	deserializerList[20] = deserializeAmf0CommandMessage
+	deserializerList[22] = deserializeAggregate
 }
 
 // Deserialize function deserialize message
@@ -85,7 +90,13 @@ func (msg nonRecognizedMessage) toRaw() (*RawMessage, error) {
 	return raw, nil
 }
 
-// Serialize serialize message to byte slice
+// ToRaw converts message to raw message
+func ToRaw(msg Message) (*RawMessage, error) {
+	return msg.toRaw()
+}
+
+// Serialize serialize message to byte slice, each message is written with a full fmt 0 header
+// as no state is kept between messages
 func Serialize(chunkSize int, msg Message) ([]byte, error) {
 	raw, err := msg.toRaw()
 	if err != nil {
@@ -95,24 +106,38 @@ func Serialize(chunkSize int, msg Message) ([]byte, error) {
 		return nil, fmt.Errorf("chunk stream id %v not supported", raw.ChunkStreamID)
 	}
 
+	var extended []byte
+	if raw.Timestamp >= 0xFFFFFF {
+		extended = make([]byte, 4)
+		binary.BigEndian.PutUint32(extended, raw.Timestamp)
+	}
+
 	body := make([]byte, 0)
 	index := 0
 	for len(raw.Raw[index:]) > chunkSize {
 		logging.Logger.Debug("do chunking")
 		body = append(body, raw.Raw[index:index+chunkSize]...)
 		body = append(body, 0xC0|byte(raw.ChunkStreamID))
+		body = append(body, extended...)
 		index += chunkSize
 	}
 	if len(raw.Raw[index:]) > 0 {
 		body = append(body, raw.Raw[index:]...)
-	} else {
-		body = body[0 : len(body)-1] //truncate trailing 0xC0|csid
+	} else if len(body) > 0 {
+		body = body[0 : len(body)-1-len(extended)] //truncate trailing 0xC0|csid
 	}
 
 	header := make([]byte, 12)
 	header[0] = byte(raw.ChunkStreamID)
 
-	// TODO :timestamp
+	// timestamp
+	ts := raw.Timestamp
+	if extended != nil {
+		ts = 0xFFFFFF
+	}
+	header[1] = byte(ts >> 16)
+	header[2] = byte(ts >> 8)
+	header[3] = byte(ts)
 
 	// message length
 	l := len(raw.Raw)
@@ -125,6 +150,7 @@ func Serialize(chunkSize int, msg Message) ([]byte, error) {
 
 	// stream id
 	binary.LittleEndian.PutUint32(header[8:12], uint32(raw.StreamID))
+	header = append(header, extended...)
 
 	return append(header, body...), nil
 }
@@ -0,0 +1,154 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+
+	utils "github.com/junli1026/gortmp/utils"
+)
+
+// user control event types
+const (
+	EventStreamBegin      uint16 = 0
+	EventStreamEOF        uint16 = 1
+	EventStreamDry        uint16 = 2
+	EventSetBufferLength  uint16 = 3
+	EventStreamIsRecorded uint16 = 4
+	EventPingRequest      uint16 = 6
+	EventPingResponse     uint16 = 7
+)
+
+// UserControlMessage is the user control message, its fields are set according to event type
+type UserControlMessage struct {
+	messageHeader
+	EventType uint16
+
+	// TargetStreamID is the stream id of StreamBegin, StreamEOF, StreamDry,
+	// SetBufferLength and StreamIsRecorded events
+	TargetStreamID uint32
+
+	// BufferLength is the buffer length in milliseconds of SetBufferLength event
+	BufferLength uint32
+
+	// PingTimestamp is the timestamp of PingRequest and PingResponse events
+	PingTimestamp uint32
+
+	// EventData is the raw event data of events not recognized
+	EventData []byte
+}
+
+func newUserControlMessage(eventType uint16) *UserControlMessage {
+	m := &UserControlMessage{}
+	m.StreamID = 0
+	m.ChunkStreamID = 2
+	m.MsgType = 4
+	m.EventType = eventType
+	return m
+}
+
+// NewStreamBeginMessage notifies that the stream becomes functional
+func NewStreamBeginMessage(streamID uint32) *UserControlMessage {
+	m := newUserControlMessage(EventStreamBegin)
+	m.TargetStreamID = streamID
+	return m
+}
+
+// NewStreamEOFMessage notifies that playback of the stream is over
+func NewStreamEOFMessage(streamID uint32) *UserControlMessage {
+	m := newUserControlMessage(EventStreamEOF)
+	m.TargetStreamID = streamID
+	return m
+}
+
+// NewStreamDryMessage notifies that there is no more data on the stream
+func NewStreamDryMessage(streamID uint32) *UserControlMessage {
+	m := newUserControlMessage(EventStreamDry)
+	m.TargetStreamID = streamID
+	return m
+}
+
+// NewSetBufferLengthMessage informs the buffer length in milliseconds used to buffer the stream
+func NewSetBufferLengthMessage(streamID uint32, bufferLength uint32) *UserControlMessage {
+	m := newUserControlMessage(EventSetBufferLength)
+	m.TargetStreamID = streamID
+	m.BufferLength = bufferLength
+	return m
+}
+
+// NewStreamIsRecordedMessage notifies that the stream is a recorded stream
+func NewStreamIsRecordedMessage(streamID uint32) *UserControlMessage {
+	m := newUserControlMessage(EventStreamIsRecorded)
+	m.TargetStreamID = streamID
+	return m
+}
+
+// NewPingRequestMessage tests whether the peer is reachable
+func NewPingRequestMessage(timestamp uint32) *UserControlMessage {
+	m := newUserControlMessage(EventPingRequest)
+	m.PingTimestamp = timestamp
+	return m
+}
+
+// NewPingResponseMessage answers a ping request with its timestamp
+func NewPingResponseMessage(timestamp uint32) *UserControlMessage {
+	m := newUserControlMessage(EventPingResponse)
+	m.PingTimestamp = timestamp
+	return m
+}
+
+func (msg UserControlMessage) toRaw() (*RawMessage, error) {
+	raw := &RawMessage{
+		messageHeader: msg.messageHeader,
+	}
+	var data []byte
+	switch msg.EventType {
+	case EventStreamBegin, EventStreamEOF, EventStreamDry, EventStreamIsRecorded:
+		data = make([]byte, 6)
+		binary.BigEndian.PutUint32(data[2:6], msg.TargetStreamID)
+	case EventSetBufferLength:
+		data = make([]byte, 10)
+		binary.BigEndian.PutUint32(data[2:6], msg.TargetStreamID)
+		binary.BigEndian.PutUint32(data[6:10], msg.BufferLength)
+	case EventPingRequest, EventPingResponse:
+		data = make([]byte, 6)
+		binary.BigEndian.PutUint32(data[2:6], msg.PingTimestamp)
+	default:
+		data = make([]byte, 2)
+		data = append(data, msg.EventData...)
+	}
+	binary.BigEndian.PutUint16(data[0:2], msg.EventType)
+	raw.Raw = data
+	return raw, nil
+}
+
+func deserializeUserControl(msg *RawMessage) (Message, error) {
+	if len(msg.Raw) < 2 {
+		return nil, errors.New("user control message too short")
+	}
+	m := &UserControlMessage{}
+	m.messageHeader = msg.messageHeader
+	m.EventType = uint16(utils.ReadUint32(msg.Raw[0:2]))
+	data := msg.Raw[2:]
+
+	switch m.EventType {
+	case EventStreamBegin, EventStreamEOF, EventStreamDry, EventStreamIsRecorded:
+		if len(data) < 4 {
+			return nil, errors.New("invalid stream event")
+		}
+		m.TargetStreamID = utils.ReadUint32(data[0:4])
+	case EventSetBufferLength:
+		if len(data) < 8 {
+			return nil, errors.New("invalid set buffer length event")
+		}
+		m.TargetStreamID = utils.ReadUint32(data[0:4])
+		m.BufferLength = utils.ReadUint32(data[4:8])
+	case EventPingRequest, EventPingResponse:
+		if len(data) < 4 {
+			return nil, errors.New("invalid ping event")
+		}
+		m.PingTimestamp = utils.ReadUint32(data[0:4])
+	default:
+		m.EventData = data
+	}
+	return m, nil
+}
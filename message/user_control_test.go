@@ -0,0 +1,42 @@
+package message
+
+import (
+	"testing"
+)
+
+func Test_UserControl(t *testing.T) {
+	msgs := []*UserControlMessage{
+		NewStreamBeginMessage(1),
+		NewStreamEOFMessage(2),
+		NewStreamDryMessage(3),
+		NewSetBufferLengthMessage(4, 3000),
+		NewStreamIsRecordedMessage(5),
+		NewPingRequestMessage(12345),
+		NewPingResponseMessage(12345),
+	}
+	for _, msg := range msgs {
+		raw, err := msg.toRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Deserialize(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := m.(*UserControlMessage)
+		if got.EventType != msg.EventType ||
+			got.TargetStreamID != msg.TargetStreamID ||
+			got.BufferLength != msg.BufferLength ||
+			got.PingTimestamp != msg.PingTimestamp {
+			t.Errorf("event %v mismatch", msg.EventType)
+		}
+	}
+
+	// unknown events are kept as raw data
+	raw := &RawMessage{Raw: []byte{0x00, 0x1F, 0x00, 0x00, 0x00, 0x01}}
+	raw.MsgType = 4
+	m, err := Deserialize(raw)
+	if err != nil || m.(*UserControlMessage).EventType != 31 || len(m.(*UserControlMessage).EventData) != 4 {
+		t.Fail()
+	}
+}
@@ -4,6 +4,17 @@ type VideoMessage struct {
 	RawMessage
 }
 
+// NewVideoMessage creates a video message carrying a FLV video tag body
+func NewVideoMessage(streamID int, timestamp uint32, data []byte) *VideoMessage {
+	m := &VideoMessage{}
+	m.MsgType = 9
+	m.StreamID = streamID
+	m.ChunkStreamID = 6
+	m.Timestamp = timestamp
+	m.Raw = data
+	return m
+}
+
 func (msg VideoMessage) toRaw() (*RawMessage, error) {
 	return &msg.RawMessage, nil
 }
@@ -0,0 +1,109 @@
+package rtmp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/junli1026/gortmp/logging"
+	"github.com/junli1026/gortmp/message"
+)
+
+// PingSetting is the setting of server side pings
+type PingSetting struct {
+	Interval time.Duration // interval of ping requests, 0 disables pings
+	Timeout  time.Duration // peer is closed if neither ping response nor data is received in time, 0 never closes
+}
+
+var defaultPingSetting = PingSetting{
+	Interval: 30 * time.Second,
+	Timeout:  90 * time.Second,
+}
+
+// pingState keeps ping statistics of a connection, fields are accessed atomically
+type pingState struct {
+	start        time.Time
+	lastRequest  int64 // milliseconds since start of the oldest unanswered ping request, -1 if none
+	lastReceived int64 // milliseconds since start of last received data
+	rtt          int64 // round trip time in nanoseconds
+}
+
+func newPingState() *pingState {
+	return &pingState{
+		start:       time.Now(),
+		lastRequest: -1,
+	}
+}
+
+func (p *pingState) now() int64 {
+	return int64(time.Since(p.start) / time.Millisecond)
+}
+
+func (p *pingState) onReceived() {
+	atomic.StoreInt64(&p.lastReceived, p.now())
+}
+
+func (p *pingState) onResponse(timestamp uint32) {
+	sent := time.Duration(timestamp) * time.Millisecond
+	rtt := time.Since(p.start) - sent
+	if rtt < 0 {
+		return
+	}
+	atomic.StoreInt64(&p.rtt, int64(rtt))
+	atomic.StoreInt64(&p.lastRequest, -1)
+}
+
+// RTT returns the round trip time measured by the last ping
+func (p *pingState) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
+}
+
+// startPing sends ping requests periodically until the context is released
+func (ctx *rtmpContext) startPing(setting PingSetting) {
+	if setting.Interval <= 0 || ctx.pinging {
+		return
+	}
+	ctx.pinging = true
+	go func() {
+		ticker := time.NewTicker(setting.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.done:
+				return
+			case <-ticker.C:
+			}
+
+			now := ctx.ping.now()
+			requested := atomic.LoadInt64(&ctx.ping.lastRequest)
+			received := atomic.LoadInt64(&ctx.ping.lastReceived)
+			timeout := int64(setting.Timeout / time.Millisecond)
+			if timeout > 0 && requested >= 0 && now-requested > timeout && now-received > timeout {
+				logging.Logger.Warnf("peer %v does not respond to ping, close it", ctx.remoteAddr())
+				ctx.conn.Close()
+				return
+			}
+			if requested < 0 {
+				atomic.StoreInt64(&ctx.ping.lastRequest, now)
+			}
+			if err := ctx.send(message.NewPingRequestMessage(uint32(now))); err != nil {
+				logging.Logger.Warnf("failed to ping %v: %v", ctx.remoteAddr(), err)
+				return
+			}
+		}
+	}()
+}
+
+func (ctx *rtmpContext) onUserControl(msg *message.UserControlMessage) ([]message.Message, error) {
+	switch msg.EventType {
+	case message.EventPingRequest:
+		return []message.Message{message.NewPingResponseMessage(msg.PingTimestamp)}, nil
+	case message.EventPingResponse:
+		ctx.ping.onResponse(msg.PingTimestamp)
+		logging.Logger.Debugf("rtt of %v: %v", ctx.remoteAddr(), ctx.ping.RTT())
+	case message.EventSetBufferLength:
+		logging.Logger.Debugf("stream %v buffer length %vms", msg.TargetStreamID, msg.BufferLength)
+	default:
+		logging.Logger.Debugf("user control event %v", msg.EventType)
+	}
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/junli1026/gortmp/logging"
+	"github.com/junli1026/gortmp/message"
+)
+
+// player is a playing NetStream subscribed to a live stream through the hub
+type player struct {
+	ctx          *rtmpContext
+	streamID     int
+	streamName   string
+	sub          *Subscriber
+	mux          sync.Mutex
+	receiveAudio bool
+	receiveVideo bool
+	waitKeyframe bool
+}
+
+func newPlayer(ctx *rtmpContext, streamID int, streamName string) *player {
+	return &player{
+		ctx:          ctx,
+		streamID:     streamID,
+		streamName:   streamName,
+		receiveAudio: true,
+		receiveVideo: true,
+	}
+}
+
+func (p *player) setReceive(video bool, flag bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if video {
+		p.receiveVideo = flag
+		p.waitKeyframe = flag
+	} else {
+		p.receiveAudio = flag
+	}
+}
+
+// run writes stream events to the player connection until the subscriber is closed
+func (p *player) run() {
+	first := true
+	failed := false
+	for event := range p.sub.C {
+		if failed {
+			continue // drain until closed
+		}
+		msg := p.toMessage(event, first)
+		first = false
+		if msg == nil {
+			continue
+		}
+		if err := p.ctx.sendCommands(msg); err != nil {
+			logging.Logger.Warnf("failed to play %v: %v", p.sub.Key(), err)
+			failed = true
+			p.ctx.conn.Close()
+		}
+	}
+}
+
+func (p *player) toMessage(event *StreamEvent, first bool) message.Message {
+	switch event.Type {
+	case StreamPublished:
+		if first && p.sub.Live() {
+			return nil
+		}
+		return newStatus(p.streamID, "status", "NetStream.Play.PublishNotify", p.streamName+" is now published")
+	case StreamUnpublished:
+		return newStatus(p.streamID, "status", "NetStream.Play.UnpublishNotify", p.streamName+" is now unpublished")
+	}
+
+	data := event.Data
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	switch data.Type {
+	case FlvScript:
+		return message.NewAmf0DataMessage(p.streamID, data.Timestamp, data.body())
+	case FlvVideo:
+		if !p.receiveVideo {
+			return nil
+		}
+		if p.waitKeyframe && !isSequenceHeader(data) {
+			if !isKeyframe(data) {
+				return nil
+			}
+			p.waitKeyframe = false
+		}
+		return message.NewVideoMessage(p.streamID, data.Timestamp, data.body())
+	case FlvAudio:
+		if !p.receiveAudio {
+			return nil
+		}
+		return message.NewAudioMessage(p.streamID, data.Timestamp, data.body())
+	}
+	return nil
+}
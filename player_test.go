@@ -0,0 +1,123 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/junli1026/gortmp/message"
+)
+
+// readMessages reads n messages sent to the player through conn
+func readMessages(conn net.Conn, n int) ([]*message.RawMessage, error) {
+	r := newChunkReader()
+	r.setChunkSize(4096)
+	buf := make([]byte, 0)
+	tmp := make([]byte, 4096)
+	msgs := make([]*message.RawMessage, 0)
+	for len(msgs) < n {
+		msg, consumed, err := r.read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if consumed > 0 {
+			buf = buf[consumed:]
+			if msg != nil {
+				msgs = append(msgs, msg)
+			}
+			continue
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return nil, err
+		}
+		l, err := conn.Read(tmp)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, tmp[:l]...)
+	}
+	return msgs, nil
+}
+
+func Test_Play(t *testing.T) {
+	s := newRtmpServer()
+	publisher := newRtmpContext(s, nil)
+	publisher.app = "live"
+	stream := &StreamMeta{streamID: 1, streamName: "test"}
+	publisher.streams = append(publisher.streams, stream)
+	st, err := s.hub.publish("live", stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.published[1] = st
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	playerCtx := newRtmpContext(s, serverConn)
+	playerCtx.app = "live"
+
+	// sequence header is cached before any player joins
+	publisher.onMediaData(message.NewVideoMessage(1, 0, []byte{0x17, 0x00, 0x01}).RawMessage, 0x09)
+
+	done := make(chan []*message.RawMessage)
+	go func() {
+		msgs, err := readMessages(clientConn, 3)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- msgs
+	}()
+	playerCtx.startPlaying(1, "test", newStatus(1, "status", "NetStream.Play.Start", "start"))
+	publisher.onMediaData(message.NewVideoMessage(1, 40, []byte{0x27, 0x01, 0x02}).RawMessage, 0x09)
+	publisher.onMediaData(message.NewVideoMessage(1, 80, []byte{0x17, 0x01, 0x03}).RawMessage, 0x09)
+
+	msgs := <-done
+	if len(msgs) != 3 {
+		t.Fatalf("expect 3 messages, got %v", len(msgs))
+	}
+	// status, sequence header, inter frame before keyframe is dropped
+	if msgs[0].MsgType != 20 {
+		t.Fail()
+	}
+	if msgs[1].Timestamp != 0 || msgs[1].Raw[1] != 0x00 {
+		t.Fail()
+	}
+	if msgs[2].Timestamp != 80 || msgs[2].Raw[0] != 0x17 {
+		t.Fail()
+	}
+	playerCtx.release()
+	publisher.release()
+	if len(s.hub.Streams()) != 0 {
+		t.Fail()
+	}
+}
+
+func Test_PlayAmf3(t *testing.T) {
+	s := newRtmpServer()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	ctx := newRtmpContext(s, serverConn)
+	ctx.app = "live"
+	ctx.amf3Commands = true
+
+	done := make(chan []*message.RawMessage)
+	go func() {
+		msgs, err := readMessages(clientConn, 2)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- msgs
+	}()
+	ctx.startPlaying(1, "test",
+		newStatus(1, "status", "NetStream.Play.Reset", "reset"),
+		newStatus(1, "status", "NetStream.Play.Start", "start"))
+
+	// statuses follow the AMF3 commands of the client
+	msgs := <-done
+	if len(msgs) != 2 || msgs[0].MsgType != 17 || msgs[1].MsgType != 17 {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+	ctx.release()
+}
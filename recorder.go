@@ -0,0 +1,451 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/codec"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// RecordSetting is the setting of FlvRecorder
+type RecordSetting struct {
+	Path        string        // path template, {app}, {stream} and {time} are replaced, default "{app}/{stream}-{time}.flv"
+	MaxDuration time.Duration // a new file is started on the next keyframe after the duration, 0 never splits
+	MaxSize     int64         // a new file is started on the next keyframe after the size in bytes, 0 never splits
+
+	// OnFinalize is called once a file is finalized in background, with path of the final file or
+	// the error of finalizing, which is logged as well. It may be called concurrently
+	OnFinalize func(path string, err error)
+}
+
+const defaultRecordPath = "{app}/{stream}-{time}.flv"
+
+// partSuffix is the suffix of files being recorded, they are valid flv files without index
+// and are finalized by FinalizeFlv
+const partSuffix = ".part"
+
+// FlvRecorder records published streams to flv files. Write and Close are meant to be
+// registered by OnStreamData and OnStreamClose. Files are written with suffix ".part" and
+// finalized on close with duration, filesize and keyframes index in onMetaData, so that they
+// are seekable. Finalizing copies the whole file, so it runs in background instead of stalling the
+// publisher, Wait waits for it. A ".part" file left by a crash is still playable and can be
+// finalized by FinalizeFlv
+type FlvRecorder struct {
+	setting    RecordSetting
+	mux        sync.Mutex
+	recordings map[*StreamMeta]*flvRecording
+	finalizing sync.WaitGroup
+}
+
+// flvRecording is the recording state of a stream
+type flvRecording struct {
+	file     *os.File
+	path     string // final path of the file
+	size     int64
+	start    int64 // DTS of the first media tag of the file
+	hasVideo bool
+	header   []byte
+	metaData *StreamData
+	videoSeq *StreamData
+	audioSeq *StreamData
+}
+
+// NewFlvRecorder returns a recorder with the setting
+func NewFlvRecorder(setting *RecordSetting) *FlvRecorder {
+	r := &FlvRecorder{
+		setting:    *setting,
+		recordings: make(map[*StreamMeta]*flvRecording),
+	}
+	if r.setting.Path == "" {
+		r.setting.Path = defaultRecordPath
+	}
+	return r
+}
+
+// Write writes data of the stream to its recording, it is a StreamDataHandler
+func (r *FlvRecorder) Write(meta *StreamMeta, data *StreamData) error {
+	r.mux.Lock()
+	rec, ok := r.recordings[meta]
+	if !ok {
+		rec = &flvRecording{}
+		r.recordings[meta] = rec
+	}
+	r.mux.Unlock()
+
+	switch data.Type {
+	case FlvHeader:
+		rec.header = data.Data
+		return nil
+	case FlvScript:
+		rec.metaData = data
+		if rec.file == nil {
+			return nil
+		}
+		return rec.writeTag(data, rec.start)
+	case FlvVideo:
+		rec.hasVideo = true
+	}
+
+	if rec.file != nil && r.shouldSplit(rec, data) {
+		if err := r.finalize(rec); err != nil {
+			return err
+		}
+	}
+	if rec.file == nil {
+		if err := rec.open(r.path(meta), data.DTS); err != nil {
+			return err
+		}
+	}
+	if data.SequenceHeader {
+		if data.Type == FlvVideo {
+			rec.videoSeq = data
+		} else {
+			rec.audioSeq = data
+		}
+	}
+	return rec.writeTag(data, rec.start)
+}
+
+// Close finalizes the recording of the stream, it is a StreamCloseHandler
+func (r *FlvRecorder) Close(meta *StreamMeta, err error) {
+	r.mux.Lock()
+	rec, ok := r.recordings[meta]
+	delete(r.recordings, meta)
+	r.mux.Unlock()
+	if !ok || rec.file == nil {
+		return
+	}
+	if err := r.finalize(rec); err != nil {
+		logging.Logger.Warnf("failed to close recording of %v: %v", meta.streamName, err)
+	}
+}
+
+// Wait waits for files being finalized in background, e.g. before the program exits
+func (r *FlvRecorder) Wait() {
+	r.finalizing.Wait()
+}
+
+// finalize closes the file of the recording and finalizes it in background
+func (r *FlvRecorder) finalize(rec *flvRecording) error {
+	part := rec.file.Name()
+	err := rec.file.Close()
+	rec.file = nil
+	if err != nil {
+		return err
+	}
+	r.finalizing.Add(1)
+	go func() {
+		defer r.finalizing.Done()
+		path, err := FinalizeFlv(part)
+		if err != nil {
+			logging.Logger.Warnf("failed to finalize recording %v: %v", part, err)
+		}
+		if r.setting.OnFinalize != nil {
+			r.setting.OnFinalize(path, err)
+		}
+	}()
+	return nil
+}
+
+// shouldSplit returns whether a new file starts with the data, files are split on keyframes,
+// or on any audio data of streams without video
+func (r *FlvRecorder) shouldSplit(rec *flvRecording, data *StreamData) bool {
+	if data.SequenceHeader {
+		return false
+	}
+	if rec.hasVideo && !(data.Type == FlvVideo && data.Keyframe) {
+		return false
+	}
+	if r.setting.MaxDuration > 0 && time.Duration(data.DTS-rec.start)*time.Millisecond >= r.setting.MaxDuration {
+		return true
+	}
+	return r.setting.MaxSize > 0 && rec.size >= r.setting.MaxSize
+}
+
+// path returns file path of a new recording of the stream
+func (r *FlvRecorder) path(meta *StreamMeta) string {
+	sanitize := strings.NewReplacer("/", "_", "\\", "_", "..", "_")
+	path := strings.NewReplacer(
+		"{app}", sanitize.Replace(meta.app),
+		"{stream}", sanitize.Replace(meta.streamName),
+		"{time}", time.Now().Format("20060102-150405"),
+	).Replace(r.setting.Path)
+
+	// files split within a second get a sequence number
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; fileExists(path) || fileExists(path+partSuffix); i++ {
+		path = fmt.Sprintf("%v-%v%v", base, i, ext)
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// open creates the ".part" file, and writes flv header, metadata and sequence headers so that
+// every file is playable on its own
+func (rec *flvRecording) open(path string, start int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path + partSuffix)
+	if err != nil {
+		return err
+	}
+	logging.Logger.Infof("recording %v", path)
+	rec.file = file
+	rec.path = path
+	rec.size = 0
+	rec.start = start
+
+	header := rec.header
+	if header == nil {
+		header = flvHeader(true, true)
+	}
+	if err := rec.write(header); err != nil {
+		return err
+	}
+	for _, data := range []*StreamData{rec.metaData, rec.videoSeq, rec.audioSeq} {
+		if data == nil {
+			continue
+		}
+		if err := rec.writeTag(data, data.DTS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTag writes the flv tag of data with timestamp relative to start
+func (rec *flvRecording) writeTag(data *StreamData, start int64) error {
+	if len(data.Data) < 11 {
+		return errors.New("invalid flv tag")
+	}
+	timestamp := data.DTS - start
+	if timestamp < 0 {
+		timestamp = 0
+	}
+	// data is shared with other consumers, so the header is copied to set timestamp
+	header := make([]byte, 11)
+	copy(header, data.Data)
+	header[4], header[5], header[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	header[7] = byte(timestamp >> 24)
+	if err := rec.write(header); err != nil {
+		return err
+	}
+	return rec.write(data.Data[11:])
+}
+
+func (rec *flvRecording) write(b []byte) error {
+	n, err := rec.file.Write(b)
+	rec.size += int64(n)
+	return err
+}
+
+// FinalizeFlv turns a ".part" file written by FlvRecorder into the final flv file, whose onMetaData
+// carries duration, filesize and keyframes index of times and filepositions. The ".part" file is
+// removed once the final file is written, it returns path of the final file
+func FinalizeFlv(part string) (string, error) {
+	path := strings.TrimSuffix(part, partSuffix)
+	if path == part {
+		return "", fmt.Errorf("%v is not a part file", part)
+	}
+	in, err := os.Open(part)
+	if err != nil {
+		return "", err
+	}
+	err = writeFinalFlv(in, path)
+	in.Close()
+	if err != nil {
+		return "", err
+	}
+	return path, os.Remove(part)
+}
+
+// writeFinalFlv writes the indexed flv file to path, through a temporary file so that path never
+// holds a partially written file
+func writeFinalFlv(in *os.File, path string) error {
+	index, err := indexFlv(in)
+	if err != nil {
+		return err
+	}
+	metaTag, err := index.metaDataTag()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(index.header)
+	if err == nil {
+		_, err = out.Write(metaTag)
+	}
+	for _, r := range index.ranges {
+		if err != nil {
+			break
+		}
+		_, err = io.Copy(out, io.NewSectionReader(in, r[0], r[1]-r[0]))
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// flvIndex is the result of scanning a flv file
+type flvIndex struct {
+	header    []byte // flv header and previous tag size
+	metaData  amf.ECMAArray
+	ranges    [][2]int64 // ranges of tags to keep, original onMetaData tags are left out
+	dataSize  int64      // size of kept tags
+	positions []int64    // keyframe positions relative to the kept tags
+	times     []int64    // keyframe timestamps in milliseconds
+	duration  int64      // last timestamp in milliseconds
+}
+
+// indexFlv scans tags of a flv file, a truncated tag at the end is left out
+func indexFlv(r io.ReadSeeker) (*flvIndex, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	index := &flvIndex{header: make([]byte, 13)}
+	if _, err := io.ReadFull(r, index.header); err != nil {
+		return nil, err
+	}
+	if string(index.header[:3]) != "FLV" {
+		return nil, errors.New("invalid flv header")
+	}
+	offset := int64(13)
+	tagHeader := make([]byte, 11)
+	for {
+		if _, err := io.ReadFull(r, tagHeader); err != nil {
+			break
+		}
+		size := int64(tagHeader[1])<<16 | int64(tagHeader[2])<<8 | int64(tagHeader[3])
+		timestamp := int64(tagHeader[7])<<24 | int64(tagHeader[4])<<16 | int64(tagHeader[5])<<8 | int64(tagHeader[6])
+		body := make([]byte, 0)
+		if tagHeader[0] != flvTagAudio {
+			// script and video tags are read, only the beginning of video tags is needed
+			n := size
+			if tagHeader[0] == flvTagVideo && n > 16 {
+				n = 16
+			}
+			body = make([]byte, n)
+			if _, err := io.ReadFull(r, body); err != nil {
+				break
+			}
+		}
+		end := offset + 11 + size + 4
+		if end > fileSize {
+			break
+		}
+		if _, err := r.Seek(end, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		if tagHeader[0] == flvTagScript {
+			if metaData, ok := decodeOnMetaData(body); ok {
+				if index.metaData == nil {
+					index.metaData = metaData
+				}
+				offset = end
+				continue
+			}
+		}
+		if tagHeader[0] == flvTagVideo && codec.IsVideoKeyframe(body) && !codec.IsVideoSequenceHeader(body) {
+			index.positions = append(index.positions, index.dataSize)
+			index.times = append(index.times, timestamp)
+		}
+		if tagHeader[0] != flvTagScript && timestamp > index.duration {
+			index.duration = timestamp
+		}
+		index.addRange(offset, end)
+		offset = end
+	}
+	return index, nil
+}
+
+func (index *flvIndex) addRange(start int64, end int64) {
+	if n := len(index.ranges); n > 0 && index.ranges[n-1][1] == start {
+		index.ranges[n-1][1] = end
+	} else {
+		index.ranges = append(index.ranges, [2]int64{start, end})
+	}
+	index.dataSize += end - start
+}
+
+// decodeOnMetaData decodes body of an onMetaData script tag
+func decodeOnMetaData(body []byte) (amf.ECMAArray, bool) {
+	dec := amf.NewDecoder(bytes.NewReader(body), amf.AMF0)
+	dec.UseOrderedObjects()
+	var name string
+	if err := dec.Decode(&name); err != nil || name != "onMetaData" {
+		return nil, false
+	}
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return amf.ECMAArray{}, true
+	}
+	switch v := value.(type) {
+	case amf.ECMAArray:
+		return v, true
+	case amf.Object:
+		return amf.ECMAArray(v), true
+	}
+	return amf.ECMAArray{}, true
+}
+
+// metaDataTag returns the onMetaData tag of the final file
+func (index *flvIndex) metaDataTag() ([]byte, error) {
+	build := func(dataStart int64) ([]byte, error) {
+		positions := make([]interface{}, len(index.positions))
+		times := make([]interface{}, len(index.times))
+		for i := range index.positions {
+			positions[i] = float64(dataStart + index.positions[i])
+			times[i] = float64(index.times[i]) / 1000
+		}
+		metaData := append(amf.ECMAArray{}, index.metaData...)
+		metaData = metaData.Set("duration", float64(index.duration)/1000)
+		metaData = metaData.Set("filesize", float64(dataStart+index.dataSize))
+		metaData = metaData.Set("keyframes", amf.Object{
+			{Name: "filepositions", Value: positions},
+			{Name: "times", Value: times},
+		})
+		body, err := amf.MarshalAll("onMetaData", metaData)
+		if err != nil {
+			return nil, err
+		}
+		return flvTag(flvTagScript, 0, body), nil
+	}
+	// numbers have fixed size in AMF0, so the tag size does not depend on the positions
+	tag, err := build(0)
+	if err != nil {
+		return nil, err
+	}
+	return build(int64(len(index.header)) + int64(len(tag)))
+}
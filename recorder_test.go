@@ -0,0 +1,152 @@
+package rtmp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/junli1026/gortmp/amf"
+)
+
+func newTagData(dataType StreamDataType, dts int64, body []byte) *StreamData {
+	tagType := byte(flvTagAudio)
+	switch dataType {
+	case FlvVideo:
+		tagType = flvTagVideo
+	case FlvScript:
+		tagType = flvTagScript
+	}
+	return &StreamData{
+		Type:           dataType,
+		Timestamp:      uint32(dts),
+		DTS:            dts,
+		Data:           flvTag(tagType, uint32(dts), body),
+		Keyframe:       dataType == FlvVideo && body[0]>>4 == 1,
+		SequenceHeader: len(body) > 1 && body[1] == 0,
+	}
+}
+
+// readIndex scans a finalized flv file
+func readIndex(t *testing.T, path string) (*flvIndex, []byte) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	index, err := indexFlv(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index, data
+}
+
+func Test_FlvRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewFlvRecorder(&RecordSetting{
+		Path:        filepath.Join(dir, "{app}", "{stream}.flv"),
+		MaxDuration: 2 * time.Second,
+		OnFinalize: func(path string, err error) {
+			if err != nil {
+				t.Errorf("failed to finalize %v: %v", path, err)
+			}
+		},
+	})
+	meta := &StreamMeta{app: "live", streamName: "cam"}
+	metaData, _ := amf.MarshalAll("onMetaData", amf.ECMAArray{{Name: "width", Value: 1280}})
+	keyframe := []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA}
+	frame := []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB}
+	for _, data := range []*StreamData{
+		{Type: FlvHeader, Data: flvHeader(true, true)},
+		newTagData(FlvScript, 0, metaData),
+		newTagData(FlvVideo, 5000, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		newTagData(FlvAudio, 5000, []byte{0xAF, 0x00, 0x12, 0x10}),
+		newTagData(FlvVideo, 5000, keyframe),
+		newTagData(FlvAudio, 5020, []byte{0xAF, 0x01, 0xCC}),
+		newTagData(FlvVideo, 6000, frame),
+		newTagData(FlvVideo, 7000, keyframe),
+		newTagData(FlvVideo, 7500, frame),
+		newTagData(FlvVideo, 8000, keyframe),
+		newTagData(FlvVideo, 8500, frame),
+	} {
+		if err := r.Write(meta, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close(meta, nil)
+	r.Wait()
+
+	cases := []struct {
+		path     string
+		duration float64
+		times    []int64
+	}{
+		{filepath.Join(dir, "live", "cam.flv"), 1, []int64{0}},
+		{filepath.Join(dir, "live", "cam-1.flv"), 1.5, []int64{0, 1000}},
+	}
+	for _, c := range cases {
+		index, data := readIndex(t, c.path)
+		if index.metaData.Get("width") != float64(1280) || index.metaData.Get("duration") != c.duration ||
+			index.metaData.Get("filesize") != float64(len(data)) {
+			t.Errorf("unexpected metadata of %v: %v", c.path, index.metaData)
+		}
+		keyframes, _ := index.metaData.Get("keyframes").(amf.Object)
+		positions, _ := keyframes.Get("filepositions").([]interface{})
+		times, _ := keyframes.Get("times").([]interface{})
+		if len(positions) != len(c.times) || len(times) != len(c.times) {
+			t.Fatalf("unexpected keyframes of %v: %v", c.path, keyframes)
+		}
+		for i := range c.times {
+			pos := int(positions[i].(float64))
+			if data[pos] != flvTagVideo || data[pos+11] != 0x17 || data[pos+12] != 0x01 ||
+				times[i] != float64(c.times[i])/1000 {
+				t.Errorf("unexpected keyframe %v of %v", i, c.path)
+			}
+		}
+		// every file starts with sequence headers
+		if len(index.times) != len(c.times) || data[index.ranges[0][0]] != flvTagVideo {
+			t.Errorf("unexpected tags of %v", c.path)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "live", "*.part")); len(matches) != 0 {
+		t.Errorf("part files are left %v", matches)
+	}
+}
+
+func Test_FinalizeTruncatedFlv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := flvHeader(false, true)
+	data = append(data, flvTag(flvTagVideo, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA})...)
+	data = append(data, flvTag(flvTagVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB})...)
+	part := filepath.Join(dir, "crashed.flv.part")
+	if err := ioutil.WriteFile(part, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := FinalizeFlv(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, final := readIndex(t, path)
+	if index.dataSize != int64(11+6+4) || index.metaData.Get("filesize") != float64(len(final)) ||
+		index.metaData.Get("duration") != float64(0) {
+		t.Errorf("unexpected finalized file %v", index.metaData)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Error("part file is expected to be removed")
+	}
+}
@@ -0,0 +1,176 @@
+package relay
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/client"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// State is the state of pushing a stream to a destination
+type State int
+
+// Push states
+const (
+	Connecting State = iota // dialing and publishing to the destination
+	Pushing                 // the destination accepted publishing and data is being pushed
+	Waiting                 // waiting for backoff to reconnect after a failure
+	Stopped                 // the stream is unpublished
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Pushing:
+		return "pushing"
+	case Waiting:
+		return "waiting"
+	case Stopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// Status is the status of pushing a stream to a destination
+type Status struct {
+	Stream     string    // "app/stream" without query
+	URL        string    // url of the destination
+	State      State     // current state
+	Since      time.Time // time of entering the state
+	Reconnects int       // connections made after failures
+	Sent       int64     // stream data sent to the destination, over all connections
+	LastError  error     // error of the last failure, nil if never failed
+}
+
+var errPushStopped = errors.New("push stopped")
+
+// push pushes a stream to a destination until it is closed or the stream is unpublished
+type push struct {
+	hub     *rtmp.StreamHub
+	app     string
+	name    string
+	key     string
+	url     string
+	setting *Setting
+	stop    chan struct{}
+	once    sync.Once
+	mux     sync.Mutex
+	status  Status
+}
+
+func newPush(hub *rtmp.StreamHub, app string, name string, url string, setting *Setting) *push {
+	return &push{
+		hub:     hub,
+		app:     app,
+		name:    name,
+		key:     app + "/" + name,
+		url:     url,
+		setting: setting,
+		stop:    make(chan struct{}),
+		status: Status{
+			Stream: app + "/" + name,
+			URL:    url,
+			State:  Connecting,
+			Since:  time.Now(),
+		},
+	}
+}
+
+func (p *push) getStatus() Status {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.status
+}
+
+func (p *push) setState(state State, err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.status.State = state
+	p.status.Since = time.Now()
+	if err != nil {
+		p.status.LastError = err
+	}
+}
+
+func (p *push) addSent() {
+	p.mux.Lock()
+	p.status.Sent++
+	p.mux.Unlock()
+}
+
+// run reconnects with exponential backoff until the push is closed or the stream is unpublished,
+// the backoff is reset once a connection succeeds in publishing
+func (p *push) run() {
+	backoff := p.setting.MinBackoff
+	for {
+		published, err := p.push()
+		if err == nil || err == errPushStopped {
+			break
+		}
+		logging.Logger.Warnf("failed to push %v to %v: %v", p.key, p.url, err)
+		if published {
+			backoff = p.setting.MinBackoff
+		}
+		p.setState(Waiting, err)
+		select {
+		case <-p.stop:
+			p.setState(Stopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > p.setting.MaxBackoff {
+			backoff = p.setting.MaxBackoff
+		}
+		p.mux.Lock()
+		p.status.Reconnects++
+		p.mux.Unlock()
+		p.setState(Connecting, nil)
+	}
+	p.setState(Stopped, nil)
+	logging.Logger.Infof("push of %v to %v stopped", p.key, p.url)
+}
+
+// push publishes the stream to the destination by one connection, it returns whether the
+// destination accepted publishing. It returns nil when the stream is unpublished
+func (p *push) push() (bool, error) {
+	c, err := client.Dial(p.url, &p.setting.Client)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if err := c.Publish(); err != nil {
+		return false, err
+	}
+	// the hub replays metadata, sequence headers and the cached GOP to new subscribers
+	sub := p.hub.Subscribe(p.app, p.name)
+	defer sub.Close()
+	if !sub.Live() {
+		return true, nil
+	}
+	p.setState(Pushing, nil)
+	for {
+		select {
+		case <-p.stop:
+			return true, errPushStopped
+		case event, ok := <-sub.C:
+			if !ok || event.Type == rtmp.StreamUnpublished {
+				return true, nil
+			}
+			if event.Type != rtmp.StreamPacket || event.Data.Type == rtmp.FlvHeader {
+				continue
+			}
+			if err := c.Write(event.Data); err != nil {
+				return true, err
+			}
+			p.addSent()
+		}
+	}
+}
+
+func (p *push) close() {
+	p.once.Do(func() { close(p.stop) })
+}
@@ -0,0 +1,132 @@
+// Package relay pushes streams published to an rtmp server to upstream rtmp servers, e.g. to
+// simulcast one publishing to several platforms
+package relay
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/client"
+	"github.com/junli1026/gortmp/logging"
+)
+
+// Rule pushes streams whose app and stream name match the patterns to its destinations
+type Rule struct {
+	App    string   // path.Match pattern of app, e.g. "live", empty matches any app
+	Stream string   // path.Match pattern of stream name without query, empty matches any stream
+	URLs   []string // "rtmp://" or "rtmps://" urls with stream key, {app} and {stream} are replaced
+}
+
+// Setting is the setting of Relay
+type Setting struct {
+	Rules      []Rule
+	Client     client.Setting // setting of connections to destinations
+	MinBackoff time.Duration  // delay of the first reconnection after a failure, default 1 second
+	MaxBackoff time.Duration  // the delay doubles on consecutive failures up to MaxBackoff, default 30 seconds
+}
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Relay pushes published streams matching its rules to upstream servers, Write and Close are
+// meant to be registered by OnStreamData and OnStreamClose. A push starts with the first data of
+// a stream, and every destination is pushed by its own connection subscribed to the hub, so a
+// slow or failing destination never blocks the publisher or other destinations. A failed push
+// reconnects with exponential backoff and starts again with metadata, sequence headers and the
+// cached GOP. Pushes stop when the stream is closed
+type Relay struct {
+	hub     *rtmp.StreamHub
+	setting Setting
+	mux     sync.Mutex
+	pushes  map[*rtmp.StreamMeta][]*push
+}
+
+// New returns a relay of streams of the hub, usually the hub of RtmpServer
+func New(hub *rtmp.StreamHub, setting *Setting) *Relay {
+	r := &Relay{
+		hub:     hub,
+		setting: *setting,
+		pushes:  make(map[*rtmp.StreamMeta][]*push),
+	}
+	if r.setting.MinBackoff <= 0 {
+		r.setting.MinBackoff = defaultMinBackoff
+	}
+	if r.setting.MaxBackoff < r.setting.MinBackoff {
+		r.setting.MaxBackoff = defaultMaxBackoff
+		if r.setting.MaxBackoff < r.setting.MinBackoff {
+			r.setting.MaxBackoff = r.setting.MinBackoff
+		}
+	}
+	return r
+}
+
+// Write starts pushing the stream on its first data, it is a StreamDataHandler
+func (r *Relay) Write(meta *rtmp.StreamMeta, data *rtmp.StreamData) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.pushes[meta]; ok {
+		return nil
+	}
+	pushes := make([]*push, 0) // streams matching no rule are remembered as well
+	name := meta.StreamName()
+	for _, rule := range r.setting.Rules {
+		if !match(rule.App, meta.App()) || !match(rule.Stream, name) {
+			continue
+		}
+		for _, u := range rule.URLs {
+			replacer := strings.NewReplacer("{app}", meta.App(), "{stream}", name)
+			p := newPush(r.hub, meta.App(), name, replacer.Replace(u), &r.setting)
+			pushes = append(pushes, p)
+			go p.run()
+		}
+	}
+	r.pushes[meta] = pushes
+	return nil
+}
+
+// Close stops pushes of the stream, it is a StreamCloseHandler
+func (r *Relay) Close(meta *rtmp.StreamMeta, err error) {
+	r.mux.Lock()
+	pushes := r.pushes[meta]
+	delete(r.pushes, meta)
+	r.mux.Unlock()
+	for _, p := range pushes {
+		p.close()
+	}
+}
+
+// Status returns status of pushes of streams being published, ordered by stream and url
+func (r *Relay) Status() []Status {
+	r.mux.Lock()
+	result := make([]Status, 0)
+	for _, pushes := range r.pushes {
+		for _, p := range pushes {
+			result = append(result, p.getStatus())
+		}
+	}
+	r.mux.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Stream != result[j].Stream {
+			return result[i].Stream < result[j].Stream
+		}
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
+
+func match(pattern string, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, name)
+	if err != nil {
+		logging.Logger.Warnf("invalid relay pattern %q: %v", pattern, err)
+	}
+	return ok
+}
@@ -0,0 +1,132 @@
+package relay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	rtmp "github.com/junli1026/gortmp"
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/client"
+)
+
+func serve(t *testing.T, s *rtmp.RtmpServer, addr string) string {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	return listener.Addr().String()
+}
+
+// freeAddr returns an address nothing listens on
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+// waitStatus waits until the status of the relay satisfies the condition
+func waitStatus(t *testing.T, r *Relay, condition func(status []Status) bool) []Status {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := r.Status(); condition(status) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("unexpected status %+v", r.Status())
+	return nil
+}
+
+func waitState(t *testing.T, r *Relay, state State) Status {
+	return waitStatus(t, r, func(status []Status) bool {
+		return len(status) == 1 && status[0].State == state
+	})[0]
+}
+
+func Test_Relay(t *testing.T) {
+	upstreamAddr := freeAddr(t)
+	origin := rtmp.NewServer()
+	r := New(origin.Hub(), &Setting{
+		Rules: []Rule{
+			{App: "live", Stream: "cam*", URLs: []string{"rtmp://" + upstreamAddr + "/push/{stream}-copy"}},
+			{App: "other", URLs: []string{"rtmp://" + upstreamAddr + "/push/other"}},
+		},
+		Client:     client.Setting{ReadTimeout: 5 * time.Second},
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+	origin.OnStreamData(r.Write)
+	origin.OnStreamClose(r.Close)
+	originAddr := serve(t, origin, "127.0.0.1:0")
+	defer origin.Stop()
+
+	pub, err := client.Dial("rtmp://"+originAddr+"/live/cam1?key=1", &client.Setting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	if err := pub.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	metaData, _ := amf.MarshalAll("onMetaData", amf.ECMAArray{{Name: "videocodecid", Value: 7}})
+	input := []*rtmp.StreamData{
+		rtmp.NewStreamData(rtmp.FlvScript, 0, metaData),
+		rtmp.NewStreamData(rtmp.FlvVideo, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		rtmp.NewStreamData(rtmp.FlvVideo, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA}),
+		rtmp.NewStreamData(rtmp.FlvVideo, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xBB}),
+	}
+	for _, data := range input {
+		if err := pub.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the destination is down, the push retries with backoff
+	status := waitState(t, r, Waiting)
+	if status.Stream != "live/cam1" || status.URL != "rtmp://"+upstreamAddr+"/push/cam1-copy" || status.LastError == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	upstream := rtmp.NewServer()
+	serve(t, upstream, upstreamAddr)
+	defer upstream.Stop()
+	waitState(t, r, Pushing)
+
+	// metadata, sequence header and GOP are replayed once the push reconnects
+	player, err := client.Dial("rtmp://"+upstreamAddr+"/push/cam1-copy", &client.Setting{ReadTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+	if err := player.Play(); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := player.Read(); err != nil || header.Type != rtmp.FlvHeader {
+		t.Fatalf("unexpected flv header %+v, %v", header, err)
+	}
+	for i, expected := range input {
+		data, err := player.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Type != expected.Type || !bytes.Equal(data.Data, expected.Data) {
+			t.Errorf("unexpected data %v: %+v", i, data)
+		}
+	}
+	waitStatus(t, r, func(status []Status) bool {
+		return len(status) == 1 && status[0].Reconnects > 0 && status[0].Sent == int64(len(input))
+	})
+
+	// unpublishing stops the push, which unpublishes the destination
+	pub.Close()
+	if _, err := player.Read(); err != client.ErrUnpublished {
+		t.Errorf("unexpected error %v after unpublishing", err)
+	}
+	waitStatus(t, r, func(status []Status) bool { return len(status) == 0 })
+}
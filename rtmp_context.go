@@ -1,60 +1,151 @@
 package rtmp
 
 import (
-	"encoding/binary"
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/codec"
 	"github.com/junli1026/gortmp/logging"
 	"github.com/junli1026/gortmp/message"
 )
 
+const outChunkSize = 4096
+
 type rtmpContext struct {
+	conn              net.Conn
 	streams           []*StreamMeta
+	players           map[int]*player
+	published         map[int]*hubStream
 	app               string
 	tcURL             string
 	swfURL            string
+	pageURL           string
 	flashVer          string
+	objectEncoding    int
+	amf3Commands      bool
+	enhanced          *enhancedCaps
 	hs                *handshakeState
 	windowSize        int
 	chunkReader       *chunkReader
+	chunkWriter       *chunkWriter
+	writeMux          sync.Mutex
+	writeBuf          []byte
 	createStreamCount int
 	received          uint32
+	ping              *pingState
+	done              chan struct{}
+	doneOnce          sync.Once
+	pinging           bool
 
-	flvHeaderWritten bool
-	s                *RtmpServer
+	s *RtmpServer
 }
 
-func newRtmpContext(s *RtmpServer) *rtmpContext {
+func newRtmpContext(s *RtmpServer, conn net.Conn) *rtmpContext {
 	ctx := &rtmpContext{}
+	ctx.conn = conn
 	ctx.hs = newHandshakeState()
+	ctx.hs.strictC2 = s.handshakeSetting.StrictC2
 	ctx.windowSize = 2500000
 	ctx.chunkReader = newChunkReader()
+	ctx.chunkWriter = newChunkWriter()
 	ctx.streams = make([]*StreamMeta, 0)
+	ctx.players = make(map[int]*player)
+	ctx.published = make(map[int]*hubStream)
 	ctx.s = s
 	ctx.received = 0
+	ctx.ping = newPingState()
+	ctx.done = make(chan struct{})
 	return ctx
 }
 
+func (ctx *rtmpContext) remoteAddr() net.Addr {
+	if ctx.conn == nil {
+		return nil
+	}
+	return ctx.conn.RemoteAddr()
+}
+
 func (ctx *rtmpContext) updateReceived(delta uint32) {
 	ctx.received += delta
+	ctx.ping.onReceived()
+}
+
+// send writes messages to connection, it is called by the connection itself for replies and by
+// players pushing media, so chunk writer state and connection writes are protected by writeMux
+func (ctx *rtmpContext) send(msgs ...message.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ctx.writeMux.Lock()
+	defer ctx.writeMux.Unlock()
+
+	var err error
+	data := ctx.writeBuf[:0]
+	for _, msg := range msgs {
+		if data, err = ctx.chunkWriter.write(data, msg); err != nil {
+			return err
+		}
+		// chunk size takes effect after the message is sent
+		if v, ok := msg.(*message.SetChunkSizeMessage); ok {
+			ctx.chunkWriter.setChunkSize(v.ChunkSize)
+		}
+	}
+	ctx.writeBuf = data
+	if err = ctx.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	_, err = ctx.conn.Write(data)
+	return err
+}
+
+func newStatus(streamID int, level string, code string, description string) *message.Amf0CommandMessage {
+	status := message.NewAmf0CommandMessage("onStatus", 0)
+	status.StreamID = streamID
+	status.AddOther(amf.Object{
+		{Name: "level", Value: level},
+		{Name: "code", Value: code},
+		{Name: "description", Value: description},
+	})
+	return status
+}
+
+func (ctx *rtmpContext) sendStatus(streamID int, level string, code string, description string) {
+	if err := ctx.sendCommands(newStatus(streamID, level, code, description)); err != nil {
+		logging.Logger.Warnf("failed to send %v: %v", code, err)
+	}
 }
 
 func (ctx *rtmpContext) handle(msg message.Message) (reply []message.Message, err error) {
 	switch v := msg.(type) {
 	case *message.SetChunkSizeMessage:
 		ctx.chunkReader.setChunkSize(int(v.ChunkSize))
+	case *message.AbortMessage:
+		ctx.chunkReader.abort(int(v.AbortChunkStreamID))
 	case *message.AckWindowSizeMessage:
 		ctx.windowSize = v.WindowSize
 	case *message.Amf0CommandMessage:
 		reply, err = ctx.handleCommand(v)
+		if v.MsgType == 17 || ctx.amf3Commands {
+			reply = answerAmf3(reply)
+		}
 	case *message.Amf0DataMessage:
 		reply, err = ctx.handleData(v)
 	case *message.VideoMessage:
 		reply, err = ctx.onVideoData(v)
 	case *message.AudioMessage:
 		reply, err = ctx.onAudioData(v)
+	case *message.UserControlMessage:
+		reply, err = ctx.onUserControl(v)
+	case *message.AggregateMessage:
+		reply, err = ctx.onAggregate(v)
 	default:
 		logging.Logger.Warnf("unhandled message, type: %v", msg.GetType())
 	}
@@ -66,6 +157,25 @@ func (ctx *rtmpContext) handle(msg message.Message) (reply []message.Message, er
 	return
 }
 
+// sendCommands sends commands which are not replies to a received command, such as play statuses,
+// in the encoding of the commands negotiated at connect
+func (ctx *rtmpContext) sendCommands(msgs ...message.Message) error {
+	if ctx.amf3Commands {
+		msgs = answerAmf3(msgs)
+	}
+	return ctx.send(msgs...)
+}
+
+// answerAmf3 sends command replies as AMF3 commands to a command received in AMF3
+func answerAmf3(reply []message.Message) []message.Message {
+	for _, msg := range reply {
+		if cmd, ok := msg.(*message.Amf0CommandMessage); ok && cmd.MsgType == 20 {
+			cmd.MsgType = 17
+		}
+	}
+	return reply
+}
+
 func (ctx *rtmpContext) handleData(cmd *message.Amf0DataMessage) ([]message.Message, error) {
 	if cmd.CommandName == "@setDataFrame" &&
 		(cmd.CallbackName == "onMetaData" || cmd.CallbackName == "onmetadata") {
@@ -82,10 +192,24 @@ func (ctx *rtmpContext) handleCommand(cmd *message.Amf0CommandMessage) ([]messag
 		return ctx.onPublish(cmd)
 	case "FCPublish":
 		return ctx.onFCPublish(cmd)
+	case "FCUnpublish":
+		return ctx.onFCUnpublish(cmd)
+	case "closeStream":
+		return ctx.onCloseStream(cmd)
+	case "deleteStream":
+		return ctx.onDeleteStream(cmd)
 	case "releaseStream":
 		return ctx.emptyResult(cmd)
 	case "createStream":
 		return ctx.onCreateStream(cmd)
+	case "play":
+		return ctx.onPlay(cmd)
+	case "play2":
+		return ctx.onPlay2(cmd)
+	case "receiveAudio":
+		return ctx.onReceive(cmd, false)
+	case "receiveVideo":
+		return ctx.onReceive(cmd, true)
 	default:
 		return ctx.emptyResult(cmd)
 	}
@@ -95,44 +219,90 @@ func (ctx *rtmpContext) onCreateStream(cmd *message.Amf0CommandMessage) ([]messa
 	result := message.NewAmf0CommandMessage("_result", cmd.TransactionID)
 	ctx.createStreamCount++
 	result.AddOther(ctx.createStreamCount)
-	return []message.Message{result}, nil
+	begin := message.NewStreamBeginMessage(uint32(ctx.createStreamCount))
+	return []message.Message{result, begin}, nil
 }
 
 func (ctx *rtmpContext) onConnect(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
 	logging.Logger.Infof(
 		"connect stream-id:%v objects:%v", cmd.GetStreamID(), cmd.CommandObject)
-	kv := cmd.CommandObject.(map[string]interface{})
+	kv, _ := cmd.CommandObject.(map[string]interface{})
+	var query url.Values
+	if v, ok := kv["app"].(string); ok {
+		ctx.app, query = splitQuery(v)
+	}
 	if v, ok := kv["tcUrl"].(string); ok {
 		ctx.tcURL = v
 	}
 	if v, ok := kv["swfUrl"].(string); ok {
 		ctx.swfURL = v
 	}
+	if v, ok := kv["pageUrl"].(string); ok {
+		ctx.pageURL = v
+	}
 	if v, ok := kv["flashVer"].(string); ok {
 		ctx.flashVer = v
 	}
+	ctx.amf3Commands = cmd.MsgType == 17
+	if v, ok := kv["objectEncoding"].(float64); ok && v == 3 {
+		ctx.objectEncoding = 3
+	}
+	ctx.enhanced = parseEnhancedCaps(kv)
+
+	if ctx.s.connectHandler != nil {
+		if len(query) == 0 {
+			_, query = splitQuery(ctx.tcURL)
+		}
+		info := &ConnectInfo{
+			App:        ctx.app,
+			TcURL:      ctx.tcURL,
+			SwfURL:     ctx.swfURL,
+			PageURL:    ctx.pageURL,
+			FlashVer:   ctx.flashVer,
+			RemoteAddr: ctx.remoteAddr(),
+			Query:      query,
+		}
+		if ctx.enhanced != nil {
+			info.FourCCs = ctx.enhanced.fourCCs
+		}
+		if err := ctx.s.connectHandler(info); err != nil {
+			logging.Logger.Warnf("connection from %v rejected: %v", info.RemoteAddr, err)
+			result := message.NewAmf0CommandMessage("_error", cmd.TransactionID)
+			result.AddOther(amf.Object{
+				{Name: "level", Value: "error"},
+				{Name: "code", Value: "NetConnection.Connect.Rejected"},
+				{Name: "description", Value: err.Error()},
+			})
+			return []message.Message{result}, errCloseAfterReply
+		}
+	}
 
 	reply := make([]message.Message, 0)
 	reply = append(reply, message.NewAckWindowSizeMessage(ctx.windowSize))
 	reply = append(reply, message.NewSetPeerBandwidthMessage(2500000, 2))
-	reply = append(reply, message.NewSetChunkSizeMessage(ctx.chunkReader.chunkSize))
+	reply = append(reply, message.NewSetChunkSizeMessage(outChunkSize))
 
 	result := message.NewAmf0CommandMessage("_result", cmd.TransactionID)
-	result.SetCommandObject(map[string]interface{}{
-		"rtmpVer":      "RS/1.0",
-		"capabilities": 255,
-		"mode":         1,
-	})
-	result.AddOther(map[string]interface{}{
-		"level":          "status",
-		"code":           "NetConnection.Connect.Success",
-		"description":    "Connection succeeded.",
-		"objectEncoding": 0,
+	properties := amf.Object{
+		{Name: "rtmpVer", Value: "RS/1.0"},
+		{Name: "capabilities", Value: 255},
+		{Name: "mode", Value: 1},
+	}
+	if ctx.enhanced != nil {
+		properties = append(properties, ctx.enhanced.answer()...)
+	}
+	result.SetCommandObject(properties)
+	result.AddOther(amf.Object{
+		{Name: "level", Value: "status"},
+		{Name: "code", Value: "NetConnection.Connect.Success"},
+		{Name: "description", Value: "Connection succeeded."},
+		{Name: "objectEncoding", Value: ctx.objectEncoding},
 	})
 	reply = append(reply, result)
 
 	onBWDone := message.NewAmf0CommandMessage("onBWDone", 0)
 	reply = append(reply, onBWDone)
+	ctx.startPing(ctx.s.pingSetting)
 	return reply, nil
 }
 
@@ -158,6 +328,8 @@ func (ctx *rtmpContext) onPublish(cmd *message.Amf0CommandMessage) ([]message.Me
 	if v, ok := cmd.Others[1].(string); ok {
 		publishingType = v
 	}
+	// query of publishing name, such as a stream key, is kept out of the name and logs
+	publishingName, query := splitQuery(publishingName)
 	logging.Logger.Info("publish(\"", publishingName, "\")")
 	if strings.ToLower(publishingType) != "live" {
 		return nil, fmt.Errorf("Only support publishing type live, while get %v", publishingType)
@@ -168,19 +340,134 @@ func (ctx *rtmpContext) onPublish(cmd *message.Amf0CommandMessage) ([]message.Me
 	if stream == nil {
 		stream = &StreamMeta{}
 		stream.streamID = cmd.StreamID
-		ctx.streams = append(ctx.streams, stream)
 	}
+	stream.app = ctx.app
+	stream.url = ctx.tcURL
+	stream.remoteAddr = ctx.remoteAddr()
 	stream.streamName = publishingName
+	stream.query = query
+	stream.ping = ctx.ping
+
+	if ctx.s.publishHandler != nil {
+		if err := ctx.s.publishHandler(stream, query); err != nil {
+			logging.Logger.Warnf("publishing %v rejected: %v", publishingName, err)
+			code := "NetStream.Publish.Denied"
+			if errors.Is(err, ErrBadName) {
+				code = "NetStream.Publish.BadName"
+			}
+			status := newStatus(cmd.StreamID, "error", code, err.Error())
+			return []message.Message{status}, errCloseAfterReply
+		}
+	}
+
+	// the stream is added once the hub accepts it, so that a rejected duplicate emits nothing
+	st, err := ctx.s.hub.publish(ctx.app, stream)
+	if err != nil {
+		logging.Logger.Warnf("publishing %v rejected: %v", publishingName, err)
+		status := newStatus(cmd.StreamID, "error", "NetStream.Publish.BadName", err.Error())
+		return []message.Message{status}, errCloseAfterReply
+	}
+	if ctx.findStream(cmd.StreamID) == nil {
+		ctx.streams = append(ctx.streams, stream)
+	}
+	ctx.published[cmd.StreamID] = st
 
 	/* prepare reply */
-	result := message.NewAmf0CommandMessage("onStatus", 0)
-	result.StreamID = cmd.StreamID
-	result.AddOther(map[string]interface{}{
-		"level":       "status",
-		"code":        "NetStream.Publish.Start",
-		"description": "publishing " + publishingName,
-	})
-	return []message.Message{result}, nil
+	begin := message.NewStreamBeginMessage(uint32(cmd.StreamID))
+	result := newStatus(cmd.StreamID, "status", "NetStream.Publish.Start", "publishing "+publishingName)
+	return []message.Message{begin, result}, nil
+}
+
+func (ctx *rtmpContext) onPlay(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
+	if len(cmd.Others) < 1 {
+		return nil, fmt.Errorf("invalid play meesage %v", *cmd)
+	}
+	streamName, ok := cmd.Others[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream name in play meesage %v", *cmd)
+	}
+	logging.Logger.Info("play(\"", streamName, "\")")
+
+	reset := true
+	if len(cmd.Others) >= 4 {
+		if v, ok := cmd.Others[3].(bool); ok {
+			reset = v
+		}
+	}
+
+	ctx.stopPlaying(cmd.StreamID)
+	reply := make([]message.Message, 0)
+	reply = append(reply, message.NewStreamBeginMessage(uint32(cmd.StreamID)))
+	if reset {
+		reply = append(reply, newStatus(cmd.StreamID, "status", "NetStream.Play.Reset", "playing and resetting "+streamName))
+	}
+	reply = append(reply, newStatus(cmd.StreamID, "status", "NetStream.Play.Start", "started playing "+streamName))
+
+	/* statuses are sent before subscribing, so that media never goes before Play.Start */
+	ctx.startPlaying(cmd.StreamID, streamName, reply...)
+	return nil, nil
+}
+
+func (ctx *rtmpContext) onPlay2(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
+	if len(cmd.Others) < 1 {
+		return nil, fmt.Errorf("invalid play2 meesage %v", *cmd)
+	}
+	params, ok := cmd.Others[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid parameters in play2 meesage %v", *cmd)
+	}
+	streamName, ok := params["streamName"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing streamName in play2 meesage %v", *cmd)
+	}
+	logging.Logger.Info("play2(\"", streamName, "\")")
+
+	ctx.stopPlaying(cmd.StreamID)
+	status := newStatus(cmd.StreamID, "status", "NetStream.Play.Transition", "transition to "+streamName)
+	ctx.startPlaying(cmd.StreamID, streamName, status)
+	return nil, nil
+}
+
+func (ctx *rtmpContext) onReceive(cmd *message.Amf0CommandMessage, video bool) ([]message.Message, error) {
+	flag := true
+	if len(cmd.Others) >= 1 {
+		if v, ok := cmd.Others[0].(bool); ok {
+			flag = v
+		}
+	}
+	if p, ok := ctx.players[cmd.StreamID]; ok {
+		p.setReceive(video, flag)
+	}
+	return nil, nil
+}
+
+func (ctx *rtmpContext) startPlaying(streamID int, streamName string, statuses ...message.Message) {
+	if err := ctx.sendCommands(statuses...); err != nil {
+		logging.Logger.Warnf("failed to start playing %v: %v", streamName, err)
+	}
+	p := newPlayer(ctx, streamID, streamName)
+	p.sub = ctx.s.hub.Subscribe(ctx.app, streamName)
+	ctx.players[streamID] = p
+	go p.run()
+}
+
+func (ctx *rtmpContext) stopPlaying(streamID int) {
+	if p, ok := ctx.players[streamID]; ok {
+		p.sub.Close()
+		delete(ctx.players, streamID)
+	}
+}
+
+// release detaches the connection from all live streams it publishes or plays
+func (ctx *rtmpContext) release() {
+	ctx.doneOnce.Do(func() { close(ctx.done) })
+	for streamID := range ctx.players {
+		ctx.stopPlaying(streamID)
+	}
+	for streamID, st := range ctx.published {
+		st.unpublish()
+		delete(ctx.published, streamID)
+	}
 }
 
 func (ctx *rtmpContext) onFCPublish(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
@@ -192,9 +479,9 @@ func (ctx *rtmpContext) onFCPublish(cmd *message.Amf0CommandMessage) ([]message.
 	}
 
 	msg := message.NewAmf0CommandMessage("onFCPublish", 0)
-	msg.AddOther(map[string]interface{}{
-		"code":        "NetStream.Publish.Start",
-		"description": streamName,
+	msg.AddOther(amf.Object{
+		{Name: "code", Value: "NetStream.Publish.Start"},
+		{Name: "description", Value: streamName},
 	})
 
 	/* prepare reply */
@@ -203,6 +490,78 @@ func (ctx *rtmpContext) onFCPublish(cmd *message.Amf0CommandMessage) ([]message.
 	return []message.Message{msg, result}, nil
 }
 
+func (ctx *rtmpContext) onFCUnpublish(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
+	var streamName string
+	if len(cmd.Others) > 0 {
+		streamName, _ = cmd.Others[0].(string)
+	}
+	if streamName == "" {
+		return nil, errors.New("FCUnpublish stream name empty")
+	}
+	for _, stream := range ctx.streams {
+		if stream.streamName == streamName {
+			ctx.endStream(stream.streamID, nil)
+			break
+		}
+	}
+
+	msg := message.NewAmf0CommandMessage("onFCUnpublish", 0)
+	msg.AddOther(amf.Object{
+		{Name: "code", Value: "NetStream.Unpublish.Success"},
+		{Name: "description", Value: streamName},
+	})
+	result := message.NewAmf0CommandMessage("_result", cmd.TransactionID)
+	return []message.Message{msg, result}, nil
+}
+
+func (ctx *rtmpContext) onCloseStream(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
+	stream := ctx.findStream(cmd.StreamID)
+	if !ctx.endStream(cmd.StreamID, nil) || stream == nil {
+		return nil, nil
+	}
+	status := newStatus(cmd.StreamID, "status", "NetStream.Unpublish.Success", stream.streamName+" is now unpublished")
+	return []message.Message{status}, nil
+}
+
+func (ctx *rtmpContext) onDeleteStream(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
+	if len(cmd.Others) < 1 {
+		return nil, fmt.Errorf("invalid deleteStream meesage %v", *cmd)
+	}
+	streamID, ok := cmd.Others[0].(float64)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream id in deleteStream meesage %v", *cmd)
+	}
+	ctx.endStream(int(streamID), nil)
+	return nil, nil
+}
+
+// endStream stops playing or publishing on the message stream and calls stream close handler of
+// the published stream, it returns whether a publishing ended. A new stream may be published on
+// the message stream afterwards
+func (ctx *rtmpContext) endStream(streamID int, err error) bool {
+	ctx.stopPlaying(streamID)
+	if st, ok := ctx.published[streamID]; ok {
+		st.unpublish()
+		delete(ctx.published, streamID)
+	}
+
+	var stream *StreamMeta
+	for i, s := range ctx.streams {
+		if s.streamID == streamID {
+			stream = s
+			ctx.streams = append(ctx.streams[:i], ctx.streams[i+1:]...)
+			break
+		}
+	}
+	if stream == nil {
+		return false
+	}
+	logging.Logger.Infof("stream %v ended", stream.streamName)
+	ctx.s.saveTimestamp(stream)
+	ctx.s.streamClose(stream, err)
+	return true
+}
+
 func (ctx *rtmpContext) emptyResult(cmd *message.Amf0CommandMessage) ([]message.Message, error) {
 	result := message.NewAmf0CommandMessage("_result", cmd.TransactionID)
 	return []message.Message{result}, nil
@@ -215,163 +574,253 @@ func (ctx *rtmpContext) onMetaData(cmd *message.Amf0DataMessage) ([]message.Mess
 		return nil, fmt.Errorf("failed to find stream with id %v", cmd.StreamID)
 	}
 
-	ctx.setStreamMeta(stream, cmd.Parameters)
+	ctx.setStreamMeta(stream, cmd.Raw)
+
+	if err := ctx.writeFlvHeader(stream); err != nil {
+		return nil, err
+	}
+
+	metaData := cmd.Raw[16:] // skip @setDataFrame
+	scriptData := flvTag(flvTagScript, 0, metaData)
+
+	flvScript := StreamData{
+		Type:      FlvScript,
+		Timestamp: 0,
+		Data:      scriptData,
+	}
+	if err := ctx.emit(stream, &flvScript); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// metaData is the onMetaData object, codec ids are numbers or strings depending on encoder.
+// Fields missing in the object are nil, so that they do not reset values of the stream
+type metaData struct {
+	Width           *int        `amf:"width"`
+	Height          *int        `amf:"height"`
+	VideoCodecID    interface{} `amf:"videocodecid"`
+	VideoDataRate   *int        `amf:"videodatarate"`
+	FrameRate       *int        `amf:"framerate"`
+	AudioCodecID    interface{} `amf:"audiocodecid"`
+	AudioDataRate   *int        `amf:"audiodatarate"`
+	AudioSampleRate *int        `amf:"audiosamplerate"`
+	AudioSampleSize *int        `amf:"audiosamplesize"`
+	AudioChannels   *int        `amf:"audiochannels"`
+	Stereo          *bool       `amf:"stereo"`
+	Encoder         *string     `amf:"encoder"`
+}
 
-	if !ctx.flvHeaderWritten && ctx.s.streamDataHandler != nil {
-		flvHeader := StreamData{
-			Type:      FlvHeader,
-			Timestamp: 0,
-			Data: []byte{
-				'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09,
-				0x00, 0x00, 0x00, 0x00, // previous tag size
-			},
+// String formats fields present in the object
+func (m metaData) String() string {
+	fields := make([]string, 0)
+	v := reflect.ValueOf(m)
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); !f.IsNil() {
+			fields = append(fields, fmt.Sprintf("%v:%v", v.Type().Field(i).Name, f.Elem()))
 		}
+	}
+	return "{" + strings.Join(fields, " ") + "}"
+}
 
-		if err := ctx.s.streamDataHandler(stream, &flvHeader); err != nil {
+func setInt(dst *int, src *int) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// decodeMetaData decodes onMetaData object following onMetaData name, which is preceded by
+// @setDataFrame for data of publishers
+func decodeMetaData(raw []byte) (*metaData, error) {
+	dec := amf.NewDecoder(bytes.NewReader(raw), amf.AMF0)
+	var name string
+	if err := dec.Decode(&name); err != nil {
+		return nil, err
+	}
+	if name == "@setDataFrame" {
+		if err := dec.Decode(&name); err != nil {
 			return nil, err
 		}
-		ctx.flvHeaderWritten = true
-	}
-
-	if ctx.s.streamDataHandler != nil {
-		metaData := cmd.Raw[16:] // skip @setDataFrame
-		scriptData := make([]byte, 0)
-		l := make([]byte, 4)
-		binary.BigEndian.PutUint32(l, uint32(len(metaData)))
-		scriptData = append(scriptData,
-			0x12,             //tag type script data
-			l[1], l[2], l[3], //data size
-			0x00, 0x00, 0x00, 0x00, //timestamp
-			0x00, 0x00, 0x00, //stream id
-		)
-		scriptData = append(scriptData, metaData[:]...)
-		binary.BigEndian.PutUint32(l, uint32(len(scriptData)))
-		scriptData = append(scriptData, l[:]...)
-
-		flvScript := StreamData{
-			Type:      FlvScript,
-			Timestamp: 0,
-			Data:      scriptData,
+	}
+	meta := &metaData{}
+	err := dec.Decode(meta)
+	return meta, err
+}
+
+func (ctx *rtmpContext) setStreamMeta(stream *StreamMeta, raw []byte) {
+	stream.url = ctx.tcURL
+	stream.setMetaData(raw)
+}
+
+// setMetaData updates the stream with onMetaData of publishers or players
+func (stream *StreamMeta) setMetaData(raw []byte) {
+	meta, err := decodeMetaData(raw)
+	if err != nil {
+		logging.Logger.Warnf("invalid metadata of %v: %v", stream.streamName, err)
+		if meta == nil {
+			return
 		}
-		if err := ctx.s.streamDataHandler(stream, &flvScript); err != nil {
-			return nil, err
+	}
+	logging.Logger.Debugf("metadata of %v: %+v", stream.streamName, *meta)
+	// only values present in metadata are updated, e.g. by @setDataFrame sent again mid-stream
+	setInt(&stream.videoDataRate, meta.VideoDataRate)
+	setInt(&stream.frameRate, meta.FrameRate)
+	setInt(&stream.audioDataRate, meta.AudioDataRate)
+	if meta.Encoder != nil {
+		stream.encoder = *meta.Encoder
+	}
+	if meta.VideoCodecID != nil {
+		stream.hasVideo = true
+	}
+	if meta.AudioCodecID != nil {
+		stream.hasAudio = true
+	}
+
+	// values parsed from sequence headers are more reliable than metadata
+	if stream.videoCodecString == "" {
+		setInt(&stream.width, meta.Width)
+		setInt(&stream.height, meta.Height)
+		if meta.VideoCodecID != nil {
+			stream.videoCodec = codecName(meta.VideoCodecID, codec.VideoCodecName)
+		}
+	}
+	if !stream.audioParsed {
+		setInt(&stream.audioSampleRate, meta.AudioSampleRate)
+		setInt(&stream.audioSampleSize, meta.AudioSampleSize)
+		setInt(&stream.audioChannels, meta.AudioChannels)
+		if meta.Stereo != nil {
+			stream.stereo = *meta.Stereo
+		}
+		if meta.AudioCodecID != nil {
+			stream.audioCodec = codecName(meta.AudioCodecID, codec.AudioCodecName)
 		}
 	}
-	return nil, nil
 }
 
-func (ctx *rtmpContext) setStreamMeta(stream *StreamMeta, meta map[string]interface{}) {
-	logging.Logger.Info(meta)
-	stream.url = ctx.tcURL
-	for key, value := range meta {
-		switch key {
-		case "width":
-			if v, ok := value.(float64); ok {
-				stream.width = int(v)
-			}
-		case "height":
-			if v, ok := value.(float64); ok {
-				stream.height = int(v)
-			}
-		case "videocodecid":
-			if v, ok := value.(string); ok {
-				stream.videoCodec = v
-			}
-			stream.hasVideo = true
-		case "videodatarate":
-			if v, ok := value.(float64); ok {
-				stream.videoDataRate = int(v)
-			}
-		case "framerate":
-			if v, ok := value.(float64); ok {
-				stream.frameRate = int(v)
-			}
-		case "audiocodecid":
-			if v, ok := value.(string); ok {
-				stream.audioCodec = v
-			}
-			stream.hasAudio = true
-		case "audiodatarate":
-			if v, ok := value.(float64); ok {
-				stream.audioDataRate = int(v)
-			}
-		case "audiosamplerate":
-			if v, ok := value.(float64); ok {
-				stream.audioSampleRate = int(v)
-			}
-		case "audiosamplesize":
-			if v, ok := value.(float64); ok {
-				stream.audioSampleSize = int(v)
-			}
-		case "audiochannels":
-			if v, ok := value.(float64); ok {
-				stream.audioChannels = int(v)
-			}
-		case "stereo":
-			if v, ok := value.(bool); ok {
-				stream.stereo = v
-			}
-		case "encoder":
-			if v, ok := value.(string); ok {
-				stream.encoder = v
-			}
+// codecName returns codec name of metadata codec id, which is a number such as 7 or a fourcc
+// string such as "avc1" depending on encoder
+func codecName(id interface{}, name func(int) string) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case float64:
+		if n := name(int(v)); n != "" {
+			return n
 		}
+		return fmt.Sprint(v)
 	}
+	return ""
 }
 
 func (ctx *rtmpContext) onVideoData(msg *message.VideoMessage) ([]message.Message, error) {
-	if ctx.s.streamDataHandler != nil {
-		if err := ctx.onMediaData(msg.RawMessage, 0x09); err != nil {
-			return nil, err
-		}
+	if err := ctx.onMediaData(msg.RawMessage, flvTagVideo); err != nil {
+		return nil, err
 	}
 	return nil, nil
 }
 
 func (ctx *rtmpContext) onAudioData(msg *message.AudioMessage) ([]message.Message, error) {
-	if ctx.s.streamDataHandler != nil {
-		if err := ctx.onMediaData(msg.RawMessage, 0x08); err != nil {
-			return nil, err
-		}
+	if err := ctx.onMediaData(msg.RawMessage, flvTagAudio); err != nil {
+		return nil, err
 	}
 	return nil, nil
 }
 
+func (ctx *rtmpContext) onAggregate(msg *message.AggregateMessage) ([]message.Message, error) {
+	reply := make([]message.Message, 0)
+	for _, sub := range msg.Messages {
+		r, err := ctx.handle(sub)
+		reply = append(reply, r...)
+		if err != nil {
+			return reply, err
+		}
+	}
+	return reply, nil
+}
+
 func (ctx *rtmpContext) onMediaData(msg message.RawMessage, tagTye byte) error {
 	stream := ctx.findStream(msg.StreamID)
 	if stream == nil {
 		return fmt.Errorf("failed to find stream with id %v", msg.StreamID)
 	}
 
-	l := make([]byte, 4)
-	binary.BigEndian.PutUint32(l, uint32(len(msg.Raw)))
-
-	mediaData := make([]byte, 0)
-
-	timestamp := make([]byte, 4)
-	binary.BigEndian.PutUint32(timestamp, msg.Timestamp)
-	mediaData = append(mediaData,
-		tagTye,           //tag type script data
-		l[1], l[2], l[3], //data size
-		timestamp[1], timestamp[2], timestamp[3], timestamp[0], //timestamp
-		0x00, 0x00, 0x00, //stream id
-	)
+	if err := ctx.writeFlvHeader(stream); err != nil {
+		return err
+	}
 
-	mediaData = append(mediaData, msg.Raw[:]...)
-	binary.BigEndian.PutUint32(l, uint32(len(mediaData)))
-	mediaData = append(mediaData, l[:]...) //previous tag size
+	streamData := &StreamData{
+		Type:      FlvAudio,
+		Timestamp: msg.Timestamp,
+	}
+	if tagTye == flvTagVideo {
+		streamData.Type = FlvVideo
+	}
+	ctx.normalizeTimestamp(stream, streamData)
+	stream.setMediaTag(streamData, tagTye, msg.Raw)
+	return ctx.emit(stream, streamData)
+}
 
+// setMediaTag builds flv tag of media data whose timestamp is normalized, fills its frame view
+// and updates codec information of the stream
+func (stream *StreamMeta) setMediaTag(data *StreamData, tagType byte, raw []byte) {
+	data.PTS = data.DTS
+	data.Data = flvTag(tagType, data.Timestamp, raw)
+	body := data.body()
 	var err error
-	var streamData StreamData
-	if tagTye == 0x09 {
-		streamData.Type = FlvVideo
+	if data.Type == FlvVideo {
+		var h *codec.VideoTagHeader
+		var payload []byte
+		if h, payload, err = codec.ParseVideoTagHeader(body); err == nil {
+			data.setVideoFrame(h, payload)
+			err = stream.updateVideo(h, payload)
+		}
+		data.Codec = stream.videoCodec
 	} else {
-		streamData.Type = FlvAudio
+		var h *codec.AudioTagHeader
+		var payload []byte
+		if h, payload, err = codec.ParseAudioTagHeader(body); err == nil {
+			data.setAudioFrame(h, payload)
+			err = stream.updateAudio(h, payload)
+		}
+		data.Codec = stream.audioCodec
+	}
+	if err != nil {
+		logging.Logger.Warnf("invalid media data of %v: %v", stream.streamName, err)
 	}
-	streamData.Timestamp = msg.Timestamp
-	streamData.Data = mediaData
-	if err = ctx.s.streamDataHandler(stream, &streamData); err != nil {
+}
+
+// writeFlvHeader emits flv header before the first tag of the stream. The audio and video flags
+// come from codec ids of metadata, both are set if the stream starts without metadata
+func (ctx *rtmpContext) writeFlvHeader(stream *StreamMeta) error {
+	if header := stream.flvHeaderData(); header != nil {
+		return ctx.emit(stream, header)
+	}
+	return nil
+}
+
+// flvHeaderData returns flv header of the stream, or nil if it is already written
+func (stream *StreamMeta) flvHeaderData() *StreamData {
+	if stream.flvHeaderWritten {
+		return nil
+	}
+	hasAudio, hasVideo := stream.hasAudio, stream.hasVideo
+	if !hasAudio && !hasVideo {
+		hasAudio, hasVideo = true, true
+	}
+	stream.flvHeaderWritten = true
+	return &StreamData{
+		Type: FlvHeader,
+		Data: flvHeader(hasAudio, hasVideo),
+	}
+}
+
+// emit passes stream data to data handlers and to subscribers of the stream
+func (ctx *rtmpContext) emit(stream *StreamMeta, data *StreamData) error {
+	if err := ctx.s.streamData(stream, data); err != nil {
 		return err
 	}
+	if st, ok := ctx.published[stream.streamID]; ok {
+		st.write(data)
+	}
 	return nil
 }
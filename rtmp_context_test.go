@@ -0,0 +1,369 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/message"
+)
+
+func newConnectCommand(app string, tcURL string) *message.Amf0CommandMessage {
+	cmd := message.NewAmf0CommandMessage("connect", 1)
+	cmd.SetCommandObject(map[string]interface{}{
+		"app":      app,
+		"tcUrl":    tcURL,
+		"flashVer": "FMLE/3.0",
+	})
+	return cmd
+}
+
+func newPublishCommand(streamID int, name string) *message.Amf0CommandMessage {
+	cmd := message.NewAmf0CommandMessage("publish", 5)
+	cmd.StreamID = streamID
+	cmd.AddOther(name)
+	cmd.AddOther("live")
+	return cmd
+}
+
+func statusCode(msg message.Message) string {
+	cmd, ok := msg.(*message.Amf0CommandMessage)
+	if !ok || len(cmd.Others) == 0 {
+		return ""
+	}
+	info, _ := cmd.Others[len(cmd.Others)-1].(amf.Object)
+	code, _ := info.Get("code").(string)
+	return code
+}
+
+func Test_ConnectRejected(t *testing.T) {
+	s := newRtmpServer()
+	var got *ConnectInfo
+	s.OnConnect(func(info *ConnectInfo) error {
+		got = info
+		if info.Query.Get("token") != "secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+
+	ctx := newRtmpContext(s, nil)
+	reply, err := ctx.handle(newConnectCommand("live?token=bad", "rtmp://localhost/live?token=bad"))
+	if err != errCloseAfterReply || len(reply) != 1 {
+		t.Fatal("connection is expected to be closed")
+	}
+	if reply[0].(*message.Amf0CommandMessage).Name != "_error" ||
+		statusCode(reply[0]) != "NetConnection.Connect.Rejected" {
+		t.Fail()
+	}
+	if got.App != "live" || got.FlashVer != "FMLE/3.0" || got.TcURL != "rtmp://localhost/live?token=bad" {
+		t.Fail()
+	}
+
+	ctx = newRtmpContext(s, nil)
+	reply, err = ctx.handle(newConnectCommand("live", "rtmp://localhost/live?token=secret"))
+	if err != nil || statusCode(reply[len(reply)-2]) != "NetConnection.Connect.Success" {
+		t.Fail()
+	}
+	ctx.release()
+}
+
+func Test_PublishRejected(t *testing.T) {
+	s := newRtmpServer()
+	s.OnPublish(func(meta *StreamMeta, query url.Values) error {
+		if meta.App() != "live" {
+			return ErrBadName
+		}
+		if query.Get("key") != "123" {
+			return errors.New("invalid stream key")
+		}
+		return nil
+	})
+
+	cases := []struct {
+		app  string
+		name string
+		code string
+		err  error
+	}{
+		{"other", "test?key=123", "NetStream.Publish.BadName", errCloseAfterReply},
+		{"live", "test?key=000", "NetStream.Publish.Denied", errCloseAfterReply},
+		{"live", "test?key=123", "NetStream.Publish.Start", nil},
+	}
+	for _, c := range cases {
+		ctx := newRtmpContext(s, nil)
+		ctx.handle(newConnectCommand(c.app, "rtmp://localhost/"+c.app))
+		reply, err := ctx.handle(newPublishCommand(1, c.name))
+		if err != c.err || len(reply) == 0 || statusCode(reply[len(reply)-1]) != c.code {
+			t.Errorf("publish %v/%v: unexpected reply %v", c.app, c.name, err)
+		}
+		if c.err != nil && len(ctx.streams) != 0 {
+			t.Fail()
+		}
+		// the stream key is kept out of the stream name
+		if c.err == nil && (ctx.streams[0].StreamName() != "test" || ctx.streams[0].Query().Get("key") != "123") {
+			t.Errorf("unexpected stream name %v", ctx.streams[0].StreamName())
+		}
+		ctx.release()
+	}
+
+	// a stream already publishing is rejected without emitting data of the duplicate
+	ctx := newRtmpContext(s, nil)
+	defer ctx.release()
+	ctx.handle(newConnectCommand("live", "rtmp://localhost/live"))
+	ctx.handle(newPublishCommand(1, "test?key=123"))
+	emitted := 0
+	s.OnStreamData(func(meta *StreamMeta, data *StreamData) error {
+		emitted++
+		return nil
+	})
+	duplicate := newRtmpContext(s, nil)
+	defer duplicate.release()
+	duplicate.handle(newConnectCommand("live", "rtmp://localhost/live"))
+	reply, err := duplicate.handle(newPublishCommand(1, "test?key=123"))
+	if err != errCloseAfterReply || len(reply) == 0 || statusCode(reply[len(reply)-1]) != "NetStream.Publish.BadName" {
+		t.Errorf("unexpected reply of duplicate publishing %v", err)
+	}
+	duplicate.handle(message.NewVideoMessage(1, 0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xAA}))
+	if len(duplicate.streams) != 0 || emitted != 0 {
+		t.Errorf("duplicate stream is not expected to emit data")
+	}
+}
+
+func Test_UserControl(t *testing.T) {
+	s := newRtmpServer()
+	ctx := newRtmpContext(s, nil)
+	defer ctx.release()
+
+	reply, err := ctx.handle(message.NewPingRequestMessage(1234))
+	if err != nil || len(reply) != 1 {
+		t.Fatal("ping request is expected to be answered")
+	}
+	if v, ok := reply[0].(*message.UserControlMessage); !ok ||
+		v.EventType != message.EventPingResponse || v.PingTimestamp != 1234 {
+		t.Fail()
+	}
+
+	reply, err = ctx.handle(message.NewAmf0CommandMessage("createStream", 2))
+	if err != nil || len(reply) != 2 {
+		t.Fatal("createStream is expected to be answered with StreamBegin")
+	}
+	if v, ok := reply[1].(*message.UserControlMessage); !ok ||
+		v.EventType != message.EventStreamBegin || v.TargetStreamID != 1 {
+		t.Fail()
+	}
+
+	ctx.handle(message.NewPingResponseMessage(0))
+	if ctx.ping.RTT() <= 0 {
+		t.Fail()
+	}
+}
+
+func Test_ConnectAmf3(t *testing.T) {
+	s := newRtmpServer()
+	ctx := newRtmpContext(s, nil)
+	defer ctx.release()
+
+	cmd := newConnectCommand("live", "rtmp://localhost/live")
+	cmd.MsgType = 17
+	cmd.CommandObject.(map[string]interface{})["objectEncoding"] = float64(3)
+	reply, err := ctx.handle(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := reply[len(reply)-2].(*message.Amf0CommandMessage)
+	info := result.Others[0].(amf.Object)
+	if result.GetType() != 17 || info.Get("objectEncoding") != 3 || !ctx.amf3Commands {
+		t.Fail()
+	}
+}
+
+func Test_ConnectEnhanced(t *testing.T) {
+	s := newRtmpServer()
+	var fourCCs []string
+	s.OnConnect(func(info *ConnectInfo) error {
+		fourCCs = info.FourCCs
+		return nil
+	})
+	ctx := newRtmpContext(s, nil)
+	defer ctx.release()
+
+	cmd := newConnectCommand("live", "rtmp://localhost/live")
+	object := cmd.CommandObject.(map[string]interface{})
+	object["fourCcList"] = []interface{}{"hvc1", "av01", "xxxx"}
+	object["videoFourCcInfoMap"] = map[string]interface{}{"*": float64(7)}
+	object["capsEx"] = float64(2)
+	reply, err := ctx.handle(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fourCCs) < 2 || fourCCs[0] != "hvc1" || fourCCs[1] != "av01" {
+		t.Errorf("unexpected fourCcList %v", fourCCs)
+	}
+
+	result := reply[len(reply)-2].(*message.Amf0CommandMessage)
+	properties := result.CommandObject.(amf.Object)
+	list, _ := properties.Get("fourCcList").([]interface{})
+	videoInfo, _ := properties.Get("videoFourCcInfoMap").(amf.Object)
+	if len(list) != len(fourCCs) || videoInfo.Get("vp09") != fourCCCanForward || properties.Get("capsEx") != serverCapsEx {
+		t.Errorf("unexpected connect result %v", properties)
+	}
+
+	// legacy clients are answered without enhanced rtmp properties
+	reply, _ = ctx.handle(newConnectCommand("live", "rtmp://localhost/live"))
+	properties = reply[len(reply)-2].(*message.Amf0CommandMessage).CommandObject.(amf.Object)
+	if properties.Get("fourCcList") != nil {
+		t.Fail()
+	}
+}
+
+func Test_SetStreamMeta(t *testing.T) {
+	raw, err := amf.MarshalAll("@setDataFrame", "onMetaData", amf.ECMAArray{
+		{Name: "width", Value: 1280},
+		{Name: "height", Value: 720},
+		{Name: "framerate", Value: 29.97},
+		{Name: "videocodecid", Value: 7},
+		{Name: "audiocodecid", Value: "mp4a"},
+		{Name: "stereo", Value: true},
+		{Name: "encoder", Value: "obs-output module"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newRtmpContext(newRtmpServer(), nil)
+	stream := &StreamMeta{}
+	ctx.setStreamMeta(stream, raw)
+	if stream.width != 1280 || stream.height != 720 || stream.frameRate != 29 || !stream.stereo ||
+		!stream.hasVideo || !stream.hasAudio || stream.audioCodec != "mp4a" || stream.encoder != "obs-output module" {
+		t.Errorf("unexpected stream meta %+v", stream)
+	}
+
+	// metadata sent again without some values keeps them
+	raw, _ = amf.MarshalAll("@setDataFrame", "onMetaData", amf.ECMAArray{{Name: "videodatarate", Value: 2500}})
+	ctx.setStreamMeta(stream, raw)
+	if stream.width != 1280 || stream.height != 720 || stream.frameRate != 29 || !stream.stereo ||
+		stream.videoCodec != "avc1" || stream.audioCodec != "mp4a" || stream.encoder != "obs-output module" ||
+		stream.videoDataRate != 2500 {
+		t.Errorf("unexpected stream meta %+v", stream)
+	}
+	if meta, _ := decodeMetaData(raw); fmt.Sprintf("%+v", *meta) != "{VideoDataRate:2500}" {
+		t.Errorf("unexpected format of metadata %+v", *meta)
+	}
+}
+
+func Test_FrameView(t *testing.T) {
+	s := newRtmpServer()
+	s.ConfigTimestamp(&TimestampSetting{}) // keep the jump to an extended timestamp
+	received := make([]*StreamData, 0)
+	s.OnStreamData(func(meta *StreamMeta, data *StreamData) error {
+		if data.Type != FlvHeader {
+			received = append(received, data)
+		}
+		return nil
+	})
+	ctx := newRtmpContext(s, nil)
+	ctx.streams = append(ctx.streams, &StreamMeta{streamID: 1, streamName: "test"})
+
+	ctx.onMediaData(message.NewVideoMessage(1, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}).RawMessage, flvTagVideo)
+	ctx.onMediaData(message.NewVideoMessage(1, 40, []byte{0x27, 0x01, 0x00, 0x00, 0x50, 0xAA, 0xBB}).RawMessage, flvTagVideo)
+	ctx.onMediaData(message.NewAudioMessage(1, 0x01000010, []byte{0xAF, 0x01, 0xCC}).RawMessage, flvTagAudio)
+	if len(received) != 3 {
+		t.Fatalf("expect 3 data, got %v", len(received))
+	}
+
+	seq, frame, audio := received[0], received[1], received[2]
+	if !seq.SequenceHeader || !seq.Keyframe || seq.CodecID != 7 || seq.Codec != "avc1" {
+		t.Errorf("unexpected sequence header %+v", seq)
+	}
+	if frame.SequenceHeader || frame.Keyframe || frame.DTS != 40 || frame.PTS != 120 || frame.CompositionTime != 80 ||
+		string(frame.Payload) != "\xAA\xBB" || !bytes.Equal(frame.body(), []byte{0x27, 0x01, 0x00, 0x00, 0x50, 0xAA, 0xBB}) {
+		t.Errorf("unexpected frame %+v", frame)
+	}
+	if audio.Type != FlvAudio || audio.CodecID != 10 || audio.DTS != 0x01000010 || string(audio.Payload) != "\xCC" ||
+		audio.Data[4] != 0x00 || audio.Data[6] != 0x10 || audio.Data[7] != 0x01 {
+		t.Errorf("unexpected audio %+v", audio)
+	}
+}
+
+func Test_MultipleStreams(t *testing.T) {
+	s := newRtmpServer()
+	headers := make(map[string][]byte)
+	s.OnStreamData(func(meta *StreamMeta, data *StreamData) error {
+		if data.Type == FlvHeader {
+			if _, ok := headers[meta.streamName]; ok {
+				t.Errorf("duplicated flv header of %v", meta.streamName)
+			}
+			headers[meta.streamName] = data.Data
+		}
+		return nil
+	})
+	closed := make([]string, 0)
+	s.OnStreamClose(func(meta *StreamMeta, err error) {
+		closed = append(closed, meta.streamName)
+	})
+	// handlers registered later are called as well
+	received, closes := 0, 0
+	s.OnStreamData(func(meta *StreamMeta, data *StreamData) error {
+		received++
+		return nil
+	})
+	s.OnStreamClose(func(meta *StreamMeta, err error) {
+		closes++
+	})
+
+	ctx := newRtmpContext(s, nil)
+	ctx.handle(newConnectCommand("live", "rtmp://localhost/live"))
+	for i, name := range []string{"first", "second"} {
+		if _, err := ctx.handle(newPublishCommand(i+1, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := amf.MarshalAll("@setDataFrame", "onMetaData", amf.ECMAArray{{Name: "videocodecid", Value: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.onMetaData(message.NewAmf0DataMessage(2, 0, raw))
+	ctx.onMediaData(message.NewVideoMessage(1, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}).RawMessage, flvTagVideo)
+	ctx.onMediaData(message.NewVideoMessage(2, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}).RawMessage, flvTagVideo)
+	if len(headers) != 2 || headers["first"][4] != 0x05 || headers["second"][4] != 0x01 {
+		t.Fatalf("unexpected flv headers %v", headers)
+	}
+
+	unpublish := message.NewAmf0CommandMessage("FCUnpublish", 6)
+	unpublish.AddOther("first")
+	reply, _ := ctx.handle(unpublish)
+	if len(reply) != 2 || statusCode(reply[0]) != "NetStream.Unpublish.Success" {
+		t.Errorf("unexpected FCUnpublish reply %v", reply)
+	}
+	deleteStream := message.NewAmf0CommandMessage("deleteStream", 7)
+	deleteStream.AddOther(float64(2))
+	ctx.handle(deleteStream)
+	if len(closed) != 2 || closed[0] != "first" || closed[1] != "second" || len(ctx.streams) != 0 {
+		t.Errorf("unexpected closed streams %v", closed)
+	}
+	if received != 5 || closes != 2 {
+		t.Errorf("unexpected calls of later handlers %v %v", received, closes)
+	}
+	if s.Hub().Meta("live", "first") != nil {
+		t.Error("stream is expected to be unpublished")
+	}
+
+	// publish again on the same message stream
+	delete(headers, "first")
+	ctx.handle(newPublishCommand(1, "first"))
+	ctx.onMediaData(message.NewAudioMessage(1, 0, []byte{0xAF, 0x01, 0xCC}).RawMessage, flvTagAudio)
+	if _, ok := headers["first"]; !ok {
+		t.Error("flv header is expected for the new stream")
+	}
+	closeStream := message.NewAmf0CommandMessage("closeStream", 8)
+	closeStream.StreamID = 1
+	reply, _ = ctx.handle(closeStream)
+	if len(reply) != 1 || statusCode(reply[0]) != "NetStream.Unpublish.Success" || len(closed) != 3 {
+		t.Errorf("unexpected closeStream reply %v", reply)
+	}
+}
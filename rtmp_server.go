@@ -1,8 +1,11 @@
 package rtmp
 
 import (
+	"crypto/tls"
 	"net"
+	"net/url"
 
+	"github.com/junli1026/gortmp/codec"
 	"github.com/junli1026/gortmp/logging"
 	"github.com/junli1026/gortmp/message"
 	"github.com/sirupsen/logrus"
@@ -41,8 +44,17 @@ type LogSetting struct {
 
 type StreamDataHandler func(meta *StreamMeta, data *StreamData) error
 
+// StreamCloseHandler is called once for every published stream when it ends, err is nil if the
+// client unpublished the stream by FCUnpublish, closeStream or deleteStream
 type StreamCloseHandler func(meta *StreamMeta, err error)
 
+// ConnectHandler authorizes a connection, returning error rejects it with NetConnection.Connect.Rejected
+type ConnectHandler func(info *ConnectInfo) error
+
+// PublishHandler authorizes a publishing stream, query is parsed from stream name such as "key?token=xxx".
+// Returning ErrBadName rejects it with NetStream.Publish.BadName, other errors with NetStream.Publish.Denied
+type PublishHandler func(meta *StreamMeta, query url.Values) error
+
 type StreamDataType int
 
 const (
@@ -52,16 +64,62 @@ const (
 	FlvAudio
 )
 
+// StreamData is a flv header, script, video or audio tag of a stream. Video and audio data also
+// carry a frame view of the tag, so that consumers do not need to parse tag headers
 type StreamData struct {
 	Type      StreamDataType
 	Timestamp uint32
-	Data      []byte
+	Data      []byte // flv header, or flv tag followed by previous tag size
+	Codec     string // FourCC of audio and video, e.g. "avc1", "hvc1", "av01" or "mp4a"
+
+	CodecID         int    // flv video codec id or sound format, 0 for enhanced rtmp video and 9 for audio
+	Keyframe        bool   // video keyframe
+	SequenceHeader  bool   // codec configuration instead of frame, e.g. AVCDecoderConfigurationRecord
+	CompositionTime int32  // AVC and HEVC only, in milliseconds
+	DTS             int64  // decoding timestamp in milliseconds
+	PTS             int64  // presentation timestamp in milliseconds, DTS plus composition time
+	Payload         []byte // elementary payload without tag header, of the first track of multitrack tags
+	Discontinuity   bool   // timestamps jump before this data, see DiscontinuityHandler
+}
+
+// body returns tag data of a flv tag, without tag header and previous tag size
+func (d *StreamData) body() []byte {
+	if d.Type == FlvHeader || len(d.Data) < 11+4 {
+		return nil
+	}
+	return d.Data[11 : len(d.Data)-4]
+}
+
+// setVideoFrame fills frame view of video data, payload is a sub slice of Data
+func (d *StreamData) setVideoFrame(h *codec.VideoTagHeader, payload []byte) {
+	d.CodecID = h.CodecID
+	d.Keyframe = h.IsKeyframe()
+	d.SequenceHeader = h.IsSequenceHeader()
+	d.CompositionTime = h.CompositionTime
+	d.PTS = d.DTS + int64(h.CompositionTime)
+	d.Payload = payload
+}
+
+// setAudioFrame fills frame view of audio data, payload is a sub slice of Data
+func (d *StreamData) setAudioFrame(h *codec.AudioTagHeader, payload []byte) {
+	d.CodecID = h.SoundFormat
+	d.SequenceHeader = h.IsSequenceHeader()
+	d.Payload = payload
 }
 
 type RtmpServer struct {
 	*baseServer
-	streamDataHandler  StreamDataHandler
-	streamCloseHandler StreamCloseHandler
+	streamDataHandlers  []StreamDataHandler
+	streamCloseHandlers []StreamCloseHandler
+	connectHandler      ConnectHandler
+	publishHandler      PublishHandler
+	hub                 *StreamHub
+	handshakeSetting    HandshakeSetting
+	pingSetting         PingSetting
+
+	timestampSetting     TimestampSetting
+	timestampBases       *timestampBases
+	discontinuityHandler DiscontinuityHandler
 }
 
 func NewServer() *RtmpServer {
@@ -80,30 +138,119 @@ func (s *RtmpServer) ConfigLog(setting *LogSetting) {
 	logging.ConfigLogger(config)
 }
 
+// ConfigHandshake configures handshake of connections accepted afterwards
+func (s *RtmpServer) ConfigHandshake(setting *HandshakeSetting) {
+	s.handshakeSetting = *setting
+}
+
+// ConfigPing configures pings of connections accepted afterwards, pings measure round trip
+// time and close peers which stop responding
+func (s *RtmpServer) ConfigPing(setting *PingSetting) {
+	s.pingSetting = *setting
+}
+
+// ConfigGopCache configures GOP cache of streams published afterwards, the cache lets new
+// subscribers start playing on a keyframe immediately
+func (s *RtmpServer) ConfigGopCache(setting *GopCacheSetting) {
+	s.hub.setGopCache(*setting)
+}
+
+// ConfigTimestamp configures timestamp normalization of streams published afterwards
+func (s *RtmpServer) ConfigTimestamp(setting *TimestampSetting) {
+	s.timestampSetting = *setting
+}
+
+// Run listens on the TCP address and serves rtmp connections, it returns error if it fails to listen
 func (s *RtmpServer) Run(addr string) error {
 	return s.baseServer.listenAndServe(addr)
 }
 
+// Serve accepts rtmp connections on the listener until the server is stopped. It can be called
+// from several goroutines to serve multiple listeners, e.g. TCP, RTMPS and Unix domain socket
+func (s *RtmpServer) Serve(listener net.Listener) error {
+	return s.baseServer.serve(listener)
+}
+
+// RunTLS runs an RTMPS server with the given PEM encoded certificate and key,
+// the certificate is reloaded once the files are modified
+func (s *RtmpServer) RunTLS(addr string, certFile string, keyFile string) error {
+	store := NewCertificateStore()
+	if err := store.Add(certFile, keyFile); err != nil {
+		return err
+	}
+	return s.RunTLSConfig(addr, store.TLSConfig())
+}
+
+// RunTLSConfig runs an RTMPS server with the given tls config, see CertificateStore for SNI and reloading support
+func (s *RtmpServer) RunTLSConfig(addr string, config *tls.Config) error {
+	return s.baseServer.listenAndServeTLS(addr, config)
+}
+
 func (s *RtmpServer) Stop() {
 	s.stop()
 }
 
 func newRtmpServer() *RtmpServer {
-	s := &RtmpServer{}
+	s := &RtmpServer{
+		hub:         newStreamHub(),
+		pingSetting: defaultPingSetting,
+
+		timestampSetting: defaultTimestampSetting,
+		timestampBases:   newTimestampBases(),
+	}
 	s.baseServer = newBaseServer(s)
 	return s
 }
 
+// Hub returns the registry of live streams published to the server
+func (s *RtmpServer) Hub() *StreamHub {
+	return s.hub
+}
+
+// OnStreamData adds a handler of stream data, handlers are called in order of registration so
+// that e.g. recording, hls and relay work together. An error of a handler closes the connection
 func (s *RtmpServer) OnStreamData(handler StreamDataHandler) {
-	s.streamDataHandler = handler
+	s.streamDataHandlers = append(s.streamDataHandlers, handler)
 }
 
+// OnStreamClose adds a handler of ended streams, handlers are called in order of registration
 func (s *RtmpServer) OnStreamClose(handler StreamCloseHandler) {
-	s.streamCloseHandler = handler
+	s.streamCloseHandlers = append(s.streamCloseHandlers, handler)
+}
+
+// streamData passes stream data to handlers, the first error stops it
+func (s *RtmpServer) streamData(meta *StreamMeta, data *StreamData) error {
+	for _, handler := range s.streamDataHandlers {
+		if err := handler(meta, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RtmpServer) streamClose(meta *StreamMeta, err error) {
+	for _, handler := range s.streamCloseHandlers {
+		handler(meta, err)
+	}
+}
+
+// OnConnect registers handler to authorize connections
+func (s *RtmpServer) OnConnect(handler ConnectHandler) {
+	s.connectHandler = handler
+}
+
+// OnPublish registers handler to authorize publishing
+func (s *RtmpServer) OnPublish(handler PublishHandler) {
+	s.publishHandler = handler
+}
+
+// OnDiscontinuity registers handler called on timestamp discontinuities of published streams
+func (s *RtmpServer) OnDiscontinuity(handler DiscontinuityHandler) {
+	s.discontinuityHandler = handler
 }
 
 func (s *RtmpServer) newContext(conn net.Conn) interface{} {
-	return newRtmpContext(s)
+	return newRtmpContext(s, conn)
 }
 
 func (*RtmpServer) read(data []byte, context interface{}) (consumed int, reply []byte, err error) {
@@ -129,23 +276,24 @@ func (*RtmpServer) read(data []byte, context interface{}) (consumed int, reply [
 
 	var resp []message.Message
 	resp, err = ctx.handle(msg)
-	if err != nil {
+	if err != nil && err != errCloseAfterReply {
 		return 0, nil, err
 	}
-	for _, r := range resp {
-		buf, err := message.Serialize(ctx.chunkReader.chunkSize, r)
-		if err != nil {
-			return 0, nil, err
-		}
-		reply = append(reply, buf...)
+	// replies are written by context, so that they are chunked in order with media pushed to players
+	if serr := ctx.send(resp...); serr != nil {
+		return 0, nil, serr
 	}
 
-	return consumed, reply, nil
+	return consumed, nil, err
 }
 
 func (s *RtmpServer) close(err error, context interface{}) {
 	ctx := context.(*rtmpContext)
+	ctx.release()
+	for _, stream := range ctx.streams {
+		s.saveTimestamp(stream)
+	}
 	for _, stream := range ctx.streams {
-		s.streamCloseHandler(stream, err)
+		s.streamClose(stream, err)
 	}
 }
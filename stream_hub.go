@@ -0,0 +1,404 @@
+package rtmp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/junli1026/gortmp/codec"
+)
+
+// StreamEventType is the type of event delivered to hub subscribers
+type StreamEventType int
+
+const (
+	// StreamPublished is delivered when a publisher starts, or right after subscribing to a live stream
+	StreamPublished StreamEventType = iota
+	// StreamPacket carries one piece of stream data
+	StreamPacket
+	// StreamUnpublished is delivered when the publisher stops
+	StreamUnpublished
+)
+
+// StreamEvent is an event of a live stream
+type StreamEvent struct {
+	Type StreamEventType
+	Meta *StreamMeta // copy of meta of the publisher, taken when metadata or sequence headers change
+	Data *StreamData
+}
+
+const defaultQueueSize = 1024
+
+// StreamHub is a server wide registry of live streams keyed by app and stream name.
+// Publishers never block on subscribers: each subscriber has a bounded queue, when the
+// queue is full the subscriber skips data until the next video keyframe.
+type StreamHub struct {
+	mux        sync.Mutex
+	streams    map[string]*hubStream
+	queueSize  int
+	gopSetting GopCacheSetting
+}
+
+type hubStream struct {
+	key         string
+	hub         *StreamHub
+	mux         sync.Mutex
+	meta        *StreamMeta // updated by the publisher, never read by subscribers
+	snapshot    *StreamMeta // copy of meta handed to subscribers
+	header      *StreamData
+	metaData    *StreamData
+	videoSeqs   []*StreamData // per track of enhanced rtmp multitrack
+	audioSeqs   []*StreamData
+	gop         *gopCache
+	subscribers map[*Subscriber]struct{}
+}
+
+// Subscriber is a consumer of a live stream
+type Subscriber struct {
+	stream       *hubStream
+	ch           chan *StreamEvent
+	waitKeyframe bool
+	dropped      int
+	closed       bool
+	live         bool
+
+	// C delivers stream events, it is closed after Close is called
+	C <-chan *StreamEvent
+}
+
+func newStreamHub() *StreamHub {
+	return &StreamHub{
+		streams:    make(map[string]*hubStream),
+		queueSize:  defaultQueueSize,
+		gopSetting: defaultGopCacheSetting,
+	}
+}
+
+// streamKey builds hub key from app and stream name, query string such as "?key=xxx" is stripped
+func streamKey(app string, streamName string) string {
+	streamName, _ = splitQuery(streamName)
+	return app + "/" + streamName
+}
+
+// SetQueueSize sets the queue size of subscribers created afterwards
+func (h *StreamHub) SetQueueSize(size int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if size > 0 {
+		h.queueSize = size
+	}
+}
+
+func (h *StreamHub) setGopCache(setting GopCacheSetting) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.gopSetting = setting
+}
+
+func (h *StreamHub) getStream(key string) *hubStream {
+	st, ok := h.streams[key]
+	if !ok {
+		st = &hubStream{
+			key:         key,
+			hub:         h,
+			subscribers: make(map[*Subscriber]struct{}),
+		}
+		h.streams[key] = st
+	}
+	return st
+}
+
+// release drops the stream from registry once it has neither publisher nor subscribers
+func (h *StreamHub) release(st *hubStream) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if st.meta == nil && len(st.subscribers) == 0 && h.streams[st.key] == st {
+		delete(h.streams, st.key)
+	}
+}
+
+// Subscribe subscribes to stream "app/streamName", the stream does not have to be published yet
+func (h *StreamHub) Subscribe(app string, streamName string) *Subscriber {
+	// the subscriber is attached while holding the hub lock, otherwise release may drop the
+	// stream from registry in between and the subscriber would wait on an orphaned stream
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	st := h.getStream(streamKey(app, streamName))
+	ch := make(chan *StreamEvent, h.queueSize)
+	sub := &Subscriber{
+		stream: st,
+		ch:     ch,
+		C:      ch,
+	}
+
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	st.subscribers[sub] = struct{}{}
+	if st.meta != nil {
+		sub.live = true
+		st.replay(sub)
+	}
+	return sub
+}
+
+// Streams returns keys of streams being published
+func (h *StreamHub) Streams() []string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	keys := make([]string, 0)
+	for key, st := range h.streams {
+		st.mux.Lock()
+		if st.meta != nil {
+			keys = append(keys, key)
+		}
+		st.mux.Unlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Cache returns data a new consumer needs to start decoding the stream immediately: flv header,
+// metadata, sequence headers and frames cached since the last keyframe
+func (h *StreamHub) Cache(app string, streamName string) []*StreamData {
+	h.mux.Lock()
+	st, ok := h.streams[streamKey(app, streamName)]
+	h.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if st.meta == nil {
+		return nil
+	}
+	return st.cache()
+}
+
+// Meta returns a copy of meta of the publishing stream, nil if the stream is not published
+func (h *StreamHub) Meta(app string, streamName string) *StreamMeta {
+	h.mux.Lock()
+	st, ok := h.streams[streamKey(app, streamName)]
+	h.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if st.meta == nil {
+		return nil
+	}
+	return st.snapshot
+}
+
+func (h *StreamHub) publish(app string, meta *StreamMeta) (*hubStream, error) {
+	// attached while holding the hub lock, see Subscribe
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	st := h.getStream(streamKey(app, meta.streamName))
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if st.meta != nil {
+		return nil, fmt.Errorf("stream %v is already publishing", st.key)
+	}
+	st.meta = meta
+	st.snapshot = meta.copy()
+	st.gop = newGopCache(h.gopSetting)
+	st.header = nil
+	st.metaData = nil
+	st.videoSeqs = nil
+	st.audioSeqs = nil
+	for sub := range st.subscribers {
+		sub.waitKeyframe = false
+		sub.push(&StreamEvent{Type: StreamPublished, Meta: st.snapshot})
+	}
+	return st, nil
+}
+
+func (st *hubStream) unpublish() {
+	st.mux.Lock()
+	if st.meta == nil {
+		st.mux.Unlock()
+		return
+	}
+	st.meta = nil
+	st.gop = nil
+	for sub := range st.subscribers {
+		sub.push(&StreamEvent{Type: StreamUnpublished, Meta: st.snapshot})
+	}
+	st.mux.Unlock()
+	st.hub.release(st)
+}
+
+// write dispatches data of the publisher to all subscribers without blocking, it is called by
+// the publisher after meta is updated with the data
+func (st *hubStream) write(data *StreamData) {
+	st.mux.Lock()
+	defer st.mux.Unlock()
+	if st.meta == nil {
+		return
+	}
+
+	switch data.Type {
+	case FlvHeader:
+		st.header = data
+	case FlvScript:
+		st.metaData = data
+		st.snapshot = st.meta.copy()
+	case FlvVideo:
+		if isSequenceHeader(data) {
+			st.videoSeqs = setSequenceHeader(st.videoSeqs, data)
+			st.snapshot = st.meta.copy()
+		}
+	case FlvAudio:
+		if isSequenceHeader(data) {
+			st.audioSeqs = setSequenceHeader(st.audioSeqs, data)
+			st.snapshot = st.meta.copy()
+		}
+	}
+	st.gop.write(data)
+
+	for sub := range st.subscribers {
+		sub.write(data, len(st.videoSeqs) > 0)
+	}
+}
+
+// headers returns flv header, metadata and sequence headers of the stream
+func (st *hubStream) headers() []*StreamData {
+	headers := make([]*StreamData, 0)
+	for _, data := range []*StreamData{st.header, st.metaData} {
+		if data != nil {
+			headers = append(headers, data)
+		}
+	}
+	headers = append(headers, st.videoSeqs...)
+	return append(headers, st.audioSeqs...)
+}
+
+func (st *hubStream) cache() []*StreamData {
+	return append(st.headers(), st.gop.frames()...)
+}
+
+// replay sends cached data to a subscriber joining a live stream, so that it starts on a keyframe
+func (st *hubStream) replay(sub *Subscriber) {
+	sub.push(&StreamEvent{Type: StreamPublished, Meta: st.snapshot})
+	for _, data := range st.headers() {
+		sub.push(&StreamEvent{Type: StreamPacket, Meta: st.snapshot, Data: data})
+	}
+	hasVideo := len(st.videoSeqs) > 0
+	sub.waitKeyframe = hasVideo
+	for _, data := range st.gop.frames() {
+		sub.write(data, hasVideo)
+	}
+}
+
+func (sub *Subscriber) write(data *StreamData, hasVideo bool) {
+	media := data.Type == FlvVideo || data.Type == FlvAudio
+	if sub.waitKeyframe && media && !isSequenceHeader(data) {
+		if data.Type == FlvAudio && hasVideo {
+			return
+		}
+		if data.Type == FlvVideo && !isKeyframe(data) {
+			return
+		}
+		sub.waitKeyframe = false
+	}
+
+	event := &StreamEvent{Type: StreamPacket, Meta: sub.stream.snapshot, Data: data}
+	select {
+	case sub.ch <- event:
+	default:
+		// queue is full, skip until next keyframe
+		sub.dropped++
+		sub.waitKeyframe = hasVideo
+	}
+}
+
+// push enqueues an event which must not be lost, the oldest event is discarded if queue is full
+func (sub *Subscriber) push(event *StreamEvent) {
+	for {
+		select {
+		case sub.ch <- event:
+			return
+		default:
+		}
+		select {
+		case <-sub.ch:
+			sub.dropped++
+		default:
+		}
+	}
+}
+
+// Key returns key of the subscribed stream in form of "app/streamName"
+func (sub *Subscriber) Key() string {
+	return sub.stream.key
+}
+
+// Live returns whether the stream was being published at the time of subscribing
+func (sub *Subscriber) Live() bool {
+	return sub.live
+}
+
+// Dropped returns number of events dropped because of a full queue
+func (sub *Subscriber) Dropped() int {
+	sub.stream.mux.Lock()
+	defer sub.stream.mux.Unlock()
+	return sub.dropped
+}
+
+// Close unsubscribes from the stream and closes C
+func (sub *Subscriber) Close() {
+	st := sub.stream
+	st.mux.Lock()
+	if sub.closed {
+		st.mux.Unlock()
+		return
+	}
+	sub.closed = true
+	delete(st.subscribers, sub)
+	close(sub.ch)
+	st.mux.Unlock()
+	st.hub.release(st)
+}
+
+func isSequenceHeader(data *StreamData) bool {
+	if data.Type == FlvVideo {
+		return codec.IsVideoSequenceHeader(data.body())
+	}
+	return codec.IsAudioSequenceHeader(data.body())
+}
+
+// setSequenceHeader replaces sequence header of the same track, tracks of enhanced rtmp multitrack
+// tags can have their own sequence headers
+func setSequenceHeader(seqs []*StreamData, data *StreamData) []*StreamData {
+	track := trackID(data)
+	for i, seq := range seqs {
+		if trackID(seq) == track {
+			seqs[i] = data
+			return seqs
+		}
+	}
+	return append(seqs, data)
+}
+
+// trackID returns id of the first track of enhanced rtmp multitrack tags, 0 for other tags
+func trackID(data *StreamData) int {
+	var tracks []codec.Track
+	if data.Type == FlvVideo {
+		if h, _, err := codec.ParseVideoTagHeader(data.body()); err == nil {
+			tracks = h.Tracks
+		}
+	} else if h, _, err := codec.ParseAudioTagHeader(data.body()); err == nil {
+		tracks = h.Tracks
+	}
+	if len(tracks) == 0 {
+		return 0
+	}
+	return tracks[0].ID
+}
+
+func isKeyframe(data *StreamData) bool {
+	return codec.IsVideoKeyframe(data.body())
+}
@@ -0,0 +1,202 @@
+package rtmp
+
+import (
+	"sync"
+	"testing"
+)
+
+func newTestTag(tagType StreamDataType, timestamp uint32, body ...byte) *StreamData {
+	data := make([]byte, 11)
+	data = append(data, body...)
+	data = append(data, 0, 0, 0, 0)
+	return &StreamData{Type: tagType, Timestamp: timestamp, Data: data}
+}
+
+func Test_HubSubscribe(t *testing.T) {
+	hub := newStreamHub()
+	sub := hub.Subscribe("live", "test")
+	if sub.Key() != "live/test" || sub.Live() {
+		t.Fail()
+	}
+
+	meta := &StreamMeta{streamID: 1, streamName: "test"}
+	st, err := hub.publish("live", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.publish("live", &StreamMeta{streamName: "test"}); err == nil {
+		t.Fail()
+	}
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x00))
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x01))
+
+	if e := <-sub.C; e.Type != StreamPublished || e.Meta == meta || e.Meta.StreamName() != "test" {
+		t.Fail()
+	}
+	if e := <-sub.C; e.Type != StreamPacket || !isSequenceHeader(e.Data) {
+		t.Fail()
+	}
+	if e := <-sub.C; e.Type != StreamPacket || !isKeyframe(e.Data) {
+		t.Fail()
+	}
+
+	// late subscriber gets cached sequence header and gop right after published event
+	late := hub.Subscribe("live", "test?key=1")
+	if !late.Live() {
+		t.Fail()
+	}
+	if e := <-late.C; e.Type != StreamPublished {
+		t.Fail()
+	}
+	if e := <-late.C; e.Type != StreamPacket || !isSequenceHeader(e.Data) {
+		t.Fail()
+	}
+	if e := <-late.C; e.Type != StreamPacket || !isKeyframe(e.Data) {
+		t.Fail()
+	}
+	late.Close()
+	if _, ok := <-late.C; ok {
+		t.Fail()
+	}
+
+	st.unpublish()
+	if e := <-sub.C; e.Type != StreamUnpublished {
+		t.Fail()
+	}
+	sub.Close()
+	if len(hub.streams) != 0 {
+		t.Fail()
+	}
+}
+
+func Test_HubMetaSnapshot(t *testing.T) {
+	hub := newStreamHub()
+	sub := hub.Subscribe("live", "test")
+	defer sub.Close()
+	meta := &StreamMeta{streamID: 1, streamName: "test"}
+	st, err := hub.publish("live", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.C
+
+	// subscribers get meta as of the last metadata or sequence header, the publisher keeps
+	// updating its own meta without racing with them
+	meta.width = 1280
+	st.write(newTestTag(FlvScript, 0, 0x02))
+	e := <-sub.C
+	meta.width = 1920
+	if e.Meta.Width() != 1280 || hub.Meta("live", "test").Width() != 1280 {
+		t.Errorf("unexpected meta %+v", e.Meta)
+	}
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x00))
+	if e := <-sub.C; e.Meta.Width() != 1920 {
+		t.Errorf("unexpected meta %+v", e.Meta)
+	}
+	st.unpublish()
+}
+
+func Test_HubSlowSubscriber(t *testing.T) {
+	hub := newStreamHub()
+	hub.SetQueueSize(4)
+	st, _ := hub.publish("live", &StreamMeta{streamName: "test"})
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x00))
+	sub := hub.Subscribe("live", "test")
+
+	// nobody reads, publisher must never block
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			st.write(newTestTag(FlvVideo, uint32(i), 0x17, 0x01))
+		} else {
+			st.write(newTestTag(FlvVideo, uint32(i), 0x27, 0x01))
+		}
+	}
+	if sub.Dropped() == 0 {
+		t.Fail()
+	}
+
+	// drain the queue, next data must start from a keyframe
+	for len(sub.C) > 0 {
+		<-sub.C
+	}
+	st.write(newTestTag(FlvVideo, 101, 0x27, 0x01))
+	st.write(newTestTag(FlvVideo, 110, 0x17, 0x01))
+	if e := <-sub.C; e.Data.Timestamp != 110 {
+		t.Fail()
+	}
+	sub.Close()
+	st.unpublish()
+}
+
+func Test_HubGopCache(t *testing.T) {
+	hub := newStreamHub()
+	hub.setGopCache(GopCacheSetting{MaxGops: 1})
+	st, _ := hub.publish("live", &StreamMeta{streamName: "test"})
+	st.write(newTestTag(FlvScript, 0, 0x02))
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x00))
+	st.write(newTestTag(FlvAudio, 0, 0xAF, 0x00))
+	st.write(newTestTag(FlvVideo, 0, 0x17, 0x01))
+	st.write(newTestTag(FlvVideo, 40, 0x27, 0x01))
+	st.write(newTestTag(FlvVideo, 80, 0x17, 0x01))
+	st.write(newTestTag(FlvAudio, 90, 0xAF, 0x01))
+	st.write(newTestTag(FlvVideo, 120, 0x27, 0x01))
+
+	cached := hub.Cache("live", "test")
+	if len(cached) != 6 {
+		t.Fatalf("expect 6 cached, got %v", len(cached))
+	}
+	// metadata, sequence headers, then last gop starting from keyframe at 80
+	if cached[0].Type != FlvScript || !isSequenceHeader(cached[1]) || !isSequenceHeader(cached[2]) {
+		t.Fail()
+	}
+	if cached[3].Timestamp != 80 || cached[5].Timestamp != 120 {
+		t.Fail()
+	}
+
+	sub := hub.Subscribe("live", "test")
+	if e := <-sub.C; e.Type != StreamPublished {
+		t.Fail()
+	}
+	for i := 0; i < 6; i++ {
+		if e := <-sub.C; e.Data != cached[i] {
+			t.Fail()
+		}
+	}
+	sub.Close()
+
+	// a gop over byte budget is not cached
+	c := newGopCache(GopCacheSetting{MaxGops: 2, MaxBytes: 40})
+	c.write(newTestTag(FlvVideo, 0, 0x17, 0x01))
+	c.write(newTestTag(FlvVideo, 40, 0x27, 0x01))
+	c.write(newTestTag(FlvVideo, 80, 0x27, 0x01))
+	if len(c.frames()) != 0 {
+		t.Fail()
+	}
+	st.unpublish()
+}
+
+func Test_HubConcurrentRelease(t *testing.T) {
+	hub := newStreamHub()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				hub.Subscribe("live", "test").Close()
+			}
+		}()
+	}
+	for i := 0; i < 2000; i++ {
+		st, err := hub.publish("live", &StreamMeta{streamName: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a stream published while subscribers come and go stays registered
+		if hub.Meta("live", "test") == nil {
+			t.Fatal("published stream is not registered")
+		}
+		st.unpublish()
+	}
+	wg.Wait()
+}
@@ -1,10 +1,21 @@
 package rtmp
 
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/junli1026/gortmp/codec"
+)
+
 //StreamMeta describes stream metadata
 type StreamMeta struct {
+	app             string
 	url             string
+	remoteAddr      net.Addr
 	streamID        int
 	streamName      string
+	query           url.Values
 	hasVideo        bool
 	hasAudio        bool
 	width           int
@@ -19,6 +30,30 @@ type StreamMeta struct {
 	audioSampleSize int
 	stereo          bool
 	encoder         string
+	ping            *pingState
+
+	videoCodecString string
+	videoProfile     int
+	videoLevel       int
+	chromaFormat     int
+	bitDepth         int
+	audioCodecString string
+	audioObjectType  int
+	audioParsed      bool
+	flvHeaderWritten bool
+	timestamps       *timestampNormalizer
+}
+
+// copy returns a copy of the meta, which is safe to read while the publisher updates the meta
+func (st *StreamMeta) copy() *StreamMeta {
+	c := *st
+	c.timestamps = nil
+	return &c
+}
+
+//App returns application name the stream is published to
+func (st *StreamMeta) App() string {
+	return st.app
 }
 
 //URL returns stream url
@@ -26,16 +61,34 @@ func (st *StreamMeta) URL() string {
 	return st.url
 }
 
+//RemoteAddr returns address of the publisher
+func (st *StreamMeta) RemoteAddr() net.Addr {
+	return st.remoteAddr
+}
+
+//RTT returns round trip time to the publisher measured by ping, 0 if not measured yet
+func (st *StreamMeta) RTT() time.Duration {
+	if st.ping == nil {
+		return 0
+	}
+	return st.ping.RTT()
+}
+
 //StreamID returns stream id
 func (st *StreamMeta) StreamID() int {
 	return st.streamID
 }
 
-//StreamName returns stream name
+//StreamName returns stream name without query, e.g. "test" of "test?key=xxx"
 func (st *StreamMeta) StreamName() string {
 	return st.streamName
 }
 
+//Query returns query of the publishing name, such as a stream key
+func (st *StreamMeta) Query() url.Values {
+	return st.query
+}
+
 //Width returns video width
 func (st *StreamMeta) Width() int {
 	return st.width
@@ -56,6 +109,31 @@ func (st *StreamMeta) VideoCodec() string {
 	return st.videoCodec
 }
 
+//VideoCodecString returns RFC 6381 codec string of video, e.g. "avc1.64001F", parsed from sequence header
+func (st *StreamMeta) VideoCodecString() string {
+	return st.videoCodecString
+}
+
+//VideoProfile returns profile_idc of AVC or HEVC video
+func (st *StreamMeta) VideoProfile() int {
+	return st.videoProfile
+}
+
+//VideoLevel returns level_idc of AVC or HEVC video
+func (st *StreamMeta) VideoLevel() int {
+	return st.videoLevel
+}
+
+//ChromaFormat returns chroma_format_idc of AVC or HEVC video, 1 is 4:2:0
+func (st *StreamMeta) ChromaFormat() int {
+	return st.chromaFormat
+}
+
+//BitDepth returns luma bit depth of AVC or HEVC video
+func (st *StreamMeta) BitDepth() int {
+	return st.bitDepth
+}
+
 //VideoDataRate returns video data rate
 func (st *StreamMeta) VideoDataRate() int {
 	return st.videoDataRate
@@ -66,6 +144,16 @@ func (st *StreamMeta) AudioCodec() string {
 	return st.audioCodec
 }
 
+//AudioCodecString returns RFC 6381 codec string of audio, e.g. "mp4a.40.2", parsed from sequence header
+func (st *StreamMeta) AudioCodecString() string {
+	return st.audioCodecString
+}
+
+//AudioObjectType returns AAC audio object type, 2 is AAC LC
+func (st *StreamMeta) AudioObjectType() int {
+	return st.audioObjectType
+}
+
 //AudioDataRate return audio data rate
 func (st *StreamMeta) AudioDataRate() int {
 	return st.audioDataRate
@@ -95,3 +183,152 @@ func (st *StreamMeta) Stereo() bool {
 func (st *StreamMeta) Encoder() string {
 	return st.encoder
 }
+
+// updateVideo updates stream meta from header and payload of flv video tag data, codec
+// configuration of sequence header takes precedence over metadata sent by encoder
+func (st *StreamMeta) updateVideo(h *codec.VideoTagHeader, payload []byte) error {
+	st.hasVideo = true
+	if !h.IsSequenceHeader() {
+		if st.videoCodec == "" {
+			st.videoCodec = h.FourCC
+		}
+		return nil
+	}
+	st.videoCodec = h.FourCC
+	if h.PacketType == codec.ExPacketMPEG2TSSequenceStart && h.ExHeader {
+		return nil // descriptor of mpeg-ts, not a codec configuration record
+	}
+
+	switch h.FourCC {
+	case codec.FourCCAVC:
+		c, err := codec.ParseAVCDecoderConfig(payload)
+		if err != nil {
+			return err
+		}
+		st.videoCodecString = c.Codec()
+		st.videoProfile = int(c.Profile)
+		st.videoLevel = int(c.Level)
+		if len(c.SPS) == 0 {
+			return nil
+		}
+		sps, err := codec.ParseAVCSPS(c.SPS[0])
+		if err != nil {
+			return err
+		}
+		st.width, st.height = sps.Width, sps.Height
+		st.chromaFormat = sps.ChromaFormat
+		st.bitDepth = sps.BitDepthLuma
+	case codec.FourCCHEVC:
+		c, err := codec.ParseHEVCDecoderConfig(payload)
+		if err != nil {
+			return err
+		}
+		st.videoCodecString = c.Codec()
+		st.videoProfile = c.Profile
+		st.videoLevel = c.Level
+		st.chromaFormat = c.ChromaFormat
+		st.bitDepth = c.BitDepthLuma
+		if len(c.SPS) == 0 {
+			return nil
+		}
+		sps, err := codec.ParseHEVCSPS(c.SPS[0])
+		if err != nil {
+			return err
+		}
+		st.width, st.height = sps.Width, sps.Height
+	case codec.FourCCAV1:
+		c, err := codec.ParseAV1CodecConfig(payload)
+		if err != nil {
+			return err
+		}
+		st.videoCodecString = c.Codec()
+		st.videoProfile = c.Profile
+		st.videoLevel = c.Level
+		st.bitDepth = c.BitDepth
+		st.chromaFormat = av1ChromaFormat(c)
+		if seq, err := codec.ParseAV1SequenceHeader(c.ConfigOBUs); err == nil {
+			st.width, st.height = seq.Width, seq.Height
+		}
+	case codec.FourCCVP8, codec.FourCCVP9:
+		c, err := codec.ParseVPCodecConfig(payload)
+		if err != nil {
+			return err
+		}
+		st.videoCodecString = c.Codec(h.FourCC)
+		st.videoProfile = c.Profile
+		st.videoLevel = c.Level
+		st.bitDepth = c.BitDepth
+	}
+	return nil
+}
+
+// av1ChromaFormat returns chroma_format_idc like value of AV1 subsampling
+func av1ChromaFormat(c *codec.AV1CodecConfig) int {
+	switch {
+	case c.Monochrome:
+		return 0
+	case c.ChromaSubsamplingX == 1 && c.ChromaSubsamplingY == 1:
+		return 1
+	case c.ChromaSubsamplingX == 1:
+		return 2
+	}
+	return 3
+}
+
+// updateAudio updates stream meta from header and payload of flv audio tag data
+func (st *StreamMeta) updateAudio(h *codec.AudioTagHeader, payload []byte) error {
+	st.hasAudio = true
+	if !h.ExHeader && h.SoundFormat != codec.AudioAAC {
+		if !st.audioParsed {
+			st.audioParsed = true
+			st.audioCodec = h.FourCC
+			st.audioSampleRate = h.SampleRate
+			st.audioSampleSize = h.SampleSize
+			st.audioChannels = h.Channels
+			st.stereo = h.Channels == 2
+			if h.SoundFormat == codec.AudioMP3 {
+				st.audioCodecString = "mp4a.40.34"
+			}
+		}
+		return nil
+	}
+	if !h.IsSequenceHeader() {
+		if st.audioCodec == "" {
+			st.audioCodec = h.FourCC
+		}
+		return nil
+	}
+
+	st.audioParsed = true
+	st.audioCodec = h.FourCC
+	switch h.FourCC {
+	case codec.FourCCAAC:
+		c, err := codec.ParseAudioSpecificConfig(payload)
+		if err != nil {
+			return err
+		}
+		st.audioCodecString = c.Codec()
+		st.audioObjectType = c.ObjectType
+		st.audioSampleRate = c.SampleRate
+		st.audioChannels = c.Channels
+		if !h.ExHeader {
+			st.audioSampleSize = h.SampleSize
+		}
+	case codec.FourCCOpus:
+		head, err := codec.ParseOpusHead(payload)
+		if err != nil {
+			return err
+		}
+		st.audioCodecString = "opus"
+		st.audioSampleRate = codec.OpusSampleRate
+		st.audioChannels = head.Channels
+	case codec.FourCCFLAC:
+		st.audioCodecString = "flac"
+	case codec.FourCCAC3, codec.FourCCEAC3:
+		st.audioCodecString = h.FourCC
+	case codec.FourCCMP3:
+		st.audioCodecString = "mp4a.40.34"
+	}
+	st.stereo = st.audioChannels == 2
+	return nil
+}
@@ -0,0 +1,106 @@
+package rtmp
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/junli1026/gortmp/amf"
+	"github.com/junli1026/gortmp/codec"
+)
+
+func updateMediaTags(stream *StreamMeta, video []byte, audio []byte) error {
+	if video != nil {
+		h, payload, err := codec.ParseVideoTagHeader(video)
+		if err == nil {
+			err = stream.updateVideo(h, payload)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if audio != nil {
+		h, payload, err := codec.ParseAudioTagHeader(audio)
+		if err == nil {
+			err = stream.updateAudio(h, payload)
+		}
+		return err
+	}
+	return nil
+}
+
+func Test_ParseMediaTags(t *testing.T) {
+	sps, _ := hex.DecodeString("67640028acd940780227e540")
+	pps, _ := hex.DecodeString("68ebe3cb22c0")
+	video := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, byte(len(sps))}
+	video = append(video, sps...)
+	video = append(video, 0x01, 0x00, byte(len(pps)))
+	video = append(video, pps...)
+	audio := []byte{0xAF, 0x00, 0x11, 0x90}
+
+	stream := &StreamMeta{}
+	if err := updateMediaTags(stream, video, audio); err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata written by encoder does not override the bitstream
+	raw, err := amf.MarshalAll("@setDataFrame", "onMetaData", amf.ECMAArray{
+		{Name: "width", Value: 1280},
+		{Name: "height", Value: 720},
+		{Name: "videocodecid", Value: 7},
+		{Name: "audiocodecid", Value: 10},
+		{Name: "audiosamplerate", Value: 44100},
+		{Name: "videodatarate", Value: 4000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newRtmpContext(newRtmpServer(), nil)
+	ctx.setStreamMeta(stream, raw)
+
+	if stream.VideoCodec() != "avc1" || stream.VideoCodecString() != "avc1.640028" || stream.VideoProfile() != 100 ||
+		stream.VideoLevel() != 40 || stream.ChromaFormat() != 1 || stream.Width() != 1920 || stream.Height() != 1080 ||
+		stream.VideoDataRate() != 4000 {
+		t.Errorf("unexpected video meta %+v", stream)
+	}
+	if stream.AudioCodec() != "mp4a" || stream.AudioCodecString() != "mp4a.40.2" || stream.AudioObjectType() != 2 ||
+		stream.AudioSampleRate() != 48000 || stream.AudioChannels() != 2 || !stream.Stereo() {
+		t.Errorf("unexpected audio meta %+v", stream)
+	}
+
+	// numeric codec ids of metadata are named
+	stream = &StreamMeta{}
+	ctx.setStreamMeta(stream, raw)
+	if stream.VideoCodec() != "avc1" || stream.AudioCodec() != "mp4a" || stream.Width() != 1280 {
+		t.Errorf("unexpected meta %+v", stream)
+	}
+}
+
+func Test_ParseEnhancedTags(t *testing.T) {
+	sps, _ := hex.DecodeString("420101016000000300b0000003000003005da003c0801107cbc0")
+	video := []byte{0x90, 'h', 'v', 'c', '1',
+		0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0xB0, 0x00, 0x00, 0x00, 0x00, 0x00, 93,
+		0xF0, 0x00, 0xFC, 0xFD, 0xF8, 0xF8, 0x00, 0x00, 0x0F,
+		0x01, 0xA1, 0x00, 0x01, 0x00, byte(len(sps)),
+	}
+	video = append(video, sps...)
+	audio := []byte{0x90, 'O', 'p', 'u', 's', 'O', 'p', 'u', 's', 'H', 'e', 'a', 'd',
+		0x01, 0x02, 0x38, 0x01, 0x80, 0xBB, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	stream := &StreamMeta{}
+	if err := updateMediaTags(stream, video, audio); err != nil {
+		t.Fatal(err)
+	}
+	if stream.VideoCodec() != "hvc1" || stream.VideoCodecString() != "hvc1.1.6.L93.B0" || stream.Width() != 1920 ||
+		stream.Height() != 1080 {
+		t.Errorf("unexpected video meta %+v", stream)
+	}
+	if stream.AudioCodec() != "Opus" || stream.AudioCodecString() != "opus" || stream.AudioSampleRate() != 48000 ||
+		stream.AudioChannels() != 2 {
+		t.Errorf("unexpected audio meta %+v", stream)
+	}
+
+	// coded frames keep the codec
+	if err := updateMediaTags(stream, []byte{0x93, 'h', 'v', 'c', '1', 0x00}, nil); err != nil || stream.VideoCodec() != "hvc1" {
+		t.Fail()
+	}
+}
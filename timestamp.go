@@ -0,0 +1,173 @@
+package rtmp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/junli1026/gortmp/logging"
+)
+
+// TimestampSetting is the setting of timestamp normalization of published streams
+type TimestampSetting struct {
+	MaxJump time.Duration // larger jumps between consecutive timestamps are discontinuities, 0 disables detection
+	Rebase  bool          // a stream published again continues from the timestamp where it stopped instead of 0
+}
+
+var defaultTimestampSetting = TimestampSetting{
+	MaxJump: 10 * time.Second,
+}
+
+// Discontinuity is a jump of timestamps of a published stream, e.g. an encoder restart
+// resetting timestamps to 0. The stream continues one frame after the latest normalized timestamp
+type Discontinuity struct {
+	Type     StreamDataType // FlvVideo or FlvAudio
+	Previous uint32         // timestamp received before the jump
+	Current  uint32         // timestamp received after the jump
+	DTS      int64          // normalized timestamp the stream continues with
+	Rebased  bool           // the stream is published again and continues from its previous publishing
+}
+
+// DiscontinuityHandler is called when a discontinuity of timestamps is detected
+type DiscontinuityHandler func(meta *StreamMeta, d *Discontinuity)
+
+// timestampNormalizer turns 32 bits rtmp timestamps of a stream into monotonic 64 bits DTS
+// starting from 0. Audio and video share the clock of the encoder, so they are extended
+// together while monotonicity is kept per track
+type timestampNormalizer struct {
+	maxJump int64 // milliseconds
+	start   int64
+	started bool
+	rebased bool
+	last    uint32 // last received timestamp
+	ext     int64  // last received timestamp extended to 64 bits
+	offset  int64  // added to extended timestamps
+	lastDTS [2]int64
+	seen    [2]bool
+	maxDTS  int64
+	frame   [2]int64 // last positive DTS delta per track, which is the frame duration
+}
+
+func newTimestampNormalizer(setting TimestampSetting, start int64) *timestampNormalizer {
+	return &timestampNormalizer{
+		maxJump: int64(setting.MaxJump / time.Millisecond),
+		start:   start,
+		rebased: start > 0,
+		maxDTS:  start,
+	}
+}
+
+// normalize returns normalized DTS of a received timestamp, and a discontinuity if the
+// timestamp jumps
+func (n *timestampNormalizer) normalize(timestamp uint32, dataType StreamDataType) (int64, *Discontinuity) {
+	track := 0
+	if dataType == FlvAudio {
+		track = 1
+	}
+	var d *Discontinuity
+	if !n.started {
+		n.started = true
+		n.ext = int64(timestamp)
+		n.offset = n.start - n.ext
+		if n.rebased {
+			d = &Discontinuity{Type: dataType, Current: timestamp, DTS: n.start, Rebased: true}
+		}
+	} else {
+		// the signed difference handles 32 bits rollover
+		delta := int64(int32(timestamp - n.last))
+		n.ext += delta
+		if n.maxJump > 0 && (delta > n.maxJump || delta < -n.maxJump) {
+			next := n.next(track)
+			n.offset = next - n.ext
+			d = &Discontinuity{Type: dataType, Previous: n.last, Current: timestamp, DTS: next}
+		}
+	}
+	n.last = timestamp
+
+	dts := n.ext + n.offset
+	if dts < n.start {
+		dts = n.start
+	}
+	if n.seen[track] && dts < n.lastDTS[track] {
+		dts = n.lastDTS[track]
+	}
+	if n.seen[track] && dts > n.lastDTS[track] {
+		n.frame[track] = dts - n.lastDTS[track]
+	}
+	n.seen[track] = true
+	n.lastDTS[track] = dts
+	if dts > n.maxDTS {
+		n.maxDTS = dts
+	}
+	return dts, d
+}
+
+// next returns the DTS a stream continues with after a discontinuity, which is one frame of the
+// track after the latest DTS, so that the first frame does not duplicate the last one sent
+func (n *timestampNormalizer) next(track int) int64 {
+	if n.frame[track] > 0 {
+		return n.maxDTS + n.frame[track]
+	}
+	return n.maxDTS + 1
+}
+
+// timestampBases keeps the last timestamp of ended streams, so that a stream published
+// again may continue from it
+type timestampBases struct {
+	mux   sync.Mutex
+	bases map[string]int64
+}
+
+func newTimestampBases() *timestampBases {
+	return &timestampBases{bases: make(map[string]int64)}
+}
+
+func (b *timestampBases) save(key string, dts int64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.bases[key] = dts
+}
+
+// take returns and forgets the last timestamp of the stream
+func (b *timestampBases) take(key string) int64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	dts := b.bases[key]
+	delete(b.bases, key)
+	return dts
+}
+
+// normalizeTimestamp sets normalized DTS and timestamp of stream data, it marks the data and
+// calls discontinuity handler on jumps
+func (ctx *rtmpContext) normalizeTimestamp(stream *StreamMeta, data *StreamData) {
+	if stream.timestamps == nil {
+		var start int64
+		if ctx.s.timestampSetting.Rebase {
+			start = ctx.s.timestampBases.take(streamKey(stream.app, stream.streamName))
+		}
+		stream.timestamps = newTimestampNormalizer(ctx.s.timestampSetting, start)
+	}
+	dts, d := stream.timestamps.normalize(data.Timestamp, data.Type)
+	data.DTS = dts
+	data.Timestamp = uint32(dts)
+	if d == nil {
+		return
+	}
+	data.Discontinuity = true
+	logging.Logger.Warnf("timestamp discontinuity of %v: %v -> %v", stream.streamName, d.Previous, d.Current)
+	if ctx.s.discontinuityHandler != nil {
+		ctx.s.discontinuityHandler(stream, d)
+	}
+}
+
+// saveTimestamp keeps the timestamp an ending stream continues with when it is rebased, the
+// frame duration of video is preferred
+func (s *RtmpServer) saveTimestamp(stream *StreamMeta) {
+	if s.timestampSetting.Rebase && stream.timestamps != nil && stream.timestamps.started {
+		n := stream.timestamps
+		track := 0
+		if !n.seen[0] {
+			track = 1
+		}
+		s.timestampBases.save(streamKey(stream.app, stream.streamName), n.next(track))
+	}
+}
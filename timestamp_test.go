@@ -0,0 +1,83 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/junli1026/gortmp/message"
+)
+
+func Test_NormalizeTimestamp(t *testing.T) {
+	cases := []struct {
+		timestamp uint32
+		dataType  StreamDataType
+		dts       int64
+		jump      bool
+	}{
+		{5000, FlvVideo, 0, false},
+		{5000, FlvAudio, 0, false},
+		{5040, FlvVideo, 40, false},
+		{5030, FlvAudio, 30, false},
+		{5020, FlvAudio, 30, false}, // negative delta of audio
+		{5080, FlvVideo, 80, false},
+		{0, FlvVideo, 120, true}, // encoder restart, one video frame after the last dts
+		{23, FlvAudio, 143, false},
+		{40, FlvVideo, 160, false},
+	}
+	n := newTimestampNormalizer(TimestampSetting{MaxJump: time.Second}, 0)
+	for i, c := range cases {
+		dts, d := n.normalize(c.timestamp, c.dataType)
+		if dts != c.dts || (d != nil) != c.jump {
+			t.Errorf("case %v: expect dts %v jump %v, got %v %v", i, c.dts, c.jump, dts, d)
+		}
+	}
+
+	// without a known frame duration the stream continues 1ms after the last dts
+	n = newTimestampNormalizer(TimestampSetting{MaxJump: time.Second}, 0)
+	n.normalize(5000, FlvVideo)
+	if dts, d := n.normalize(0, FlvVideo); dts != 1 || d == nil || d.DTS != 1 {
+		t.Errorf("unexpected dts %v after jump", dts)
+	}
+
+	// 32 bits rollover
+	n = newTimestampNormalizer(TimestampSetting{MaxJump: time.Second}, 0)
+	n.normalize(0xFFFFFF00, FlvVideo)
+	if dts, d := n.normalize(0x00000100, FlvVideo); dts != 0x200 || d != nil {
+		t.Errorf("unexpected dts %v after rollover", dts)
+	}
+}
+
+func Test_RebaseTimestamp(t *testing.T) {
+	s := newRtmpServer()
+	s.ConfigTimestamp(&TimestampSetting{MaxJump: time.Second, Rebase: true})
+	received := make([]*StreamData, 0)
+	s.OnStreamData(func(meta *StreamMeta, data *StreamData) error {
+		if data.Type == FlvVideo {
+			received = append(received, data)
+		}
+		return nil
+	})
+	discontinuities := make([]*Discontinuity, 0)
+	s.OnDiscontinuity(func(meta *StreamMeta, d *Discontinuity) {
+		discontinuities = append(discontinuities, d)
+	})
+
+	video := []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xAA}
+	for i := 0; i < 2; i++ {
+		ctx := newRtmpContext(s, nil)
+		ctx.handle(newConnectCommand("live", "rtmp://localhost/live"))
+		ctx.handle(newPublishCommand(1, "test"))
+		ctx.onMediaData(message.NewVideoMessage(1, 1000, video).RawMessage, flvTagVideo)
+		ctx.onMediaData(message.NewVideoMessage(1, 1040, video).RawMessage, flvTagVideo)
+		s.close(nil, ctx)
+	}
+
+	// the second publishing starts one frame after the last one of the first
+	if len(received) != 4 || received[1].DTS != 40 || received[2].DTS != 80 || received[3].DTS != 120 ||
+		received[3].Timestamp != 120 || received[3].Data[6] != 120 || !received[2].Discontinuity {
+		t.Errorf("unexpected rebased data %v", received)
+	}
+	if len(discontinuities) != 1 || !discontinuities[0].Rebased || discontinuities[0].DTS != 80 {
+		t.Errorf("unexpected discontinuities %v", discontinuities)
+	}
+}
@@ -0,0 +1,143 @@
+package rtmp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junli1026/gortmp/logging"
+)
+
+const certCheckInterval = time.Second
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	cert     *tls.Certificate
+}
+
+// CertificateStore holds certificates of an RTMPS server. The certificate is selected by
+// SNI server name, and reloaded once its files are modified on disk.
+type CertificateStore struct {
+	mux       sync.Mutex
+	entries   []*certEntry
+	lastCheck time.Time
+}
+
+// NewCertificateStore creates an empty certificate store
+func NewCertificateStore() *CertificateStore {
+	return &CertificateStore{
+		entries: make([]*certEntry, 0),
+	}
+}
+
+// Add loads a PEM encoded certificate and key pair, the first one added is the default certificate
+func (c *CertificateStore) Add(certFile string, keyFile string) error {
+	entry := &certEntry{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := entry.load(); err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+// Reload reloads certificates whose files are modified since last loading
+func (c *CertificateStore) Reload() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.reload()
+}
+
+func (c *CertificateStore) reload() error {
+	c.lastCheck = time.Now()
+	var lastErr error
+	for _, entry := range c.entries {
+		modTime, err := entry.latestModTime()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !modTime.After(entry.modTime) {
+			continue
+		}
+		// keep serving the old certificate if the new one is broken, e.g. half written
+		if err := entry.load(); err != nil {
+			lastErr = err
+			continue
+		}
+		logging.Logger.Infof("certificate %v reloaded", entry.certFile)
+	}
+	return lastErr
+}
+
+// GetCertificate selects certificate by SNI server name, it is meant to be used as tls.Config.GetCertificate
+func (c *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if time.Since(c.lastCheck) >= certCheckInterval {
+		if err := c.reload(); err != nil {
+			logging.Logger.Warnf("failed to reload certificate: %v", err)
+		}
+	}
+	if len(c.entries) == 0 {
+		return nil, errors.New("no certificate")
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name != "" {
+		for _, entry := range c.entries {
+			if entry.cert.Leaf.VerifyHostname(name) == nil {
+				return entry.cert, nil
+			}
+		}
+	}
+	return c.entries[0].cert, nil
+}
+
+// TLSConfig returns a tls config serving certificates of the store
+func (c *CertificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+func (entry *certEntry) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{entry.certFile, entry.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (entry *certEntry) load() error {
+	modTime, err := entry.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(entry.certFile, entry.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	entry.cert = &cert
+	entry.modTime = modTime
+	return nil
+}
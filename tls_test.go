@@ -0,0 +1,125 @@
+package rtmp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for host to dir, returns cert and key file
+func writeSelfSignedCert(t *testing.T, dir string, host string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, host+".crt")
+	keyFile := filepath.Join(dir, host+".key")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func dialTLS(t *testing.T, serverName string) (*tls.Conn, *x509.Certificate) {
+	conn, err := tls.Dial("tcp", "127.0.0.1:1235", &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, conn.ConnectionState().PeerCertificates[0]
+}
+
+func Test_RunTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtmps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewCertificateStore()
+	if err := store.Add(writeSelfSignedCert(t, dir, "a.test", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(writeSelfSignedCert(t, dir, "b.test", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newRtmpServer()
+	go s.RunTLSConfig(":1235", store.TLSConfig())
+	time.Sleep(1 * time.Second)
+	defer s.stop()
+
+	// certificate is selected by server name
+	conn, cert := dialTLS(t, "b.test")
+	if cert.Subject.CommonName != "b.test" {
+		t.Fail()
+	}
+	if err = sendc0(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err = sendc1(conn); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1+handshakeSize)
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != 3 {
+		t.Fail()
+	}
+	conn.Close()
+
+	conn, cert = dialTLS(t, "unknown.test")
+	if cert.Subject.CommonName != "a.test" {
+		t.Fail()
+	}
+	conn.Close()
+
+	// certificate is reloaded once files are modified
+	certFile, keyFile := writeSelfSignedCert(t, dir, "a.test", 3)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	conn, cert = dialTLS(t, "a.test")
+	if cert.SerialNumber.Int64() != 3 {
+		t.Fail()
+	}
+	conn.Close()
+}
@@ -0,0 +1,195 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes, RFC 6455 5.2
+const (
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xA
+)
+
+const (
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// players send nothing but control frames, larger frames are protocol errors
+	maxWebSocketFrame = 64 * 1024
+)
+
+var errWebSocketFrame = errors.New("websocket frame too large")
+
+// webSocket is the server side of a WebSocket connection, it sends binary messages and answers
+// control frames of the client
+type webSocket struct {
+	conn    net.Conn
+	timeout time.Duration
+	mux     sync.Mutex // guards writing frames
+	closed  chan struct{}
+}
+
+// isWebSocketUpgrade returns whether the request asks for a WebSocket upgrade
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket hijacks the connection of the request and completes the opening handshake
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, timeout time.Duration) (*webSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "invalid websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection can not be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	ws := &webSocket{
+		conn:    conn,
+		timeout: timeout,
+		closed:  make(chan struct{}),
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go ws.readLoop(rw.Reader)
+	return ws, nil
+}
+
+// readLoop reads frames of the client until it closes, pings are answered with pongs
+func (ws *webSocket) readLoop(r *bufio.Reader) {
+	defer close(ws.closed)
+	for {
+		opcode, payload, err := readWebSocketFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			if len(payload) > 2 {
+				payload = payload[:2] // echo the status code only
+			}
+			ws.writeFrame(wsOpClose, payload)
+			return
+		case wsOpPing:
+			ws.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+// readWebSocketFrame reads a frame and unmasks its payload
+func readWebSocketFrame(r *bufio.Reader) (byte, []byte, error) {
+	head := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b)
+	}
+	if size > maxWebSocketFrame {
+		return 0, nil, errWebSocketFrame
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// writeFrame writes an unfragmented frame, frames of servers are not masked
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode // fin
+	switch size := len(payload); {
+	case size < 126:
+		header[1] = byte(size)
+	case size <= 0xFFFF:
+		header[1] = 126
+		header = append(header, byte(size>>8), byte(size))
+	default:
+		header[1] = 127
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(size))
+		header = append(header, b...)
+	}
+
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(ws.conn)
+	return err
+}
+
+func (ws *webSocket) write(data []byte) error {
+	return ws.writeFrame(wsOpBinary, data)
+}
+
+func (ws *webSocket) flush() error {
+	return nil
+}
+
+func (ws *webSocket) done() <-chan struct{} {
+	return ws.closed
+}
+
+// close sends a normal closure and closes the connection
+func (ws *webSocket) close() {
+	ws.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+	ws.conn.Close()
+}